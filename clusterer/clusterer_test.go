@@ -0,0 +1,38 @@
+package clusterer
+
+import (
+	"math/rand"
+	"testing"
+)
+
+type points []Point
+
+func (p points) Len() int                { return len(p) }
+func (p points) At(i int) (x, y float64) { return p[i].X, p[i].Y }
+func (p points) Weight(i int) float64    { return 1 }
+
+func TestMakeTree(t *testing.T) {
+	var pts points
+	for i := 0; i < 1000; i++ {
+		x := (rand.Float64() - 0.5) * 360
+		y := (rand.Float64() - 0.5) * 360
+		pts = append(pts, Point{x, y})
+	}
+
+	n := 0
+	for _, c := range MakeClusters(pts, 30) {
+		n += len(c.Elem)
+	}
+	if n != len(pts) {
+		t.Errorf("MakeClusters yields %v, want %v", n, len(pts))
+	}
+
+	tree := NewTree(pts, 5e-5)
+	n = 0
+	tree.Query(-180, -180, 180, 180, 0, func(pt Point, vi []int) {
+		n += len(vi)
+	})
+	if n != len(pts) {
+		t.Errorf("Query yields %v, want %v", n, len(pts))
+	}
+}
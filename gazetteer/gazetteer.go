@@ -0,0 +1,273 @@
+// Package gazetteer implements offline reverse geocoding
+// using the city lists of GeoNames (http://www.geonames.org).
+package gazetteer
+
+import (
+	"archive/zip"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tajtiattila/photomap/quadtree"
+)
+
+// Place is a populated place in the gazetteer.
+type Place struct {
+	Name    string `json:"name"`
+	Region  string `json:"region,omitempty"`  // first level administrative division
+	Country string `json:"country,omitempty"` // country name, or ISO code if unknown
+
+	Lat  float64 `json:"lat"`
+	Long float64 `json:"long"`
+
+	Population int `json:"population,omitempty"`
+
+	ascii string // ascii name if it differs from Name
+}
+
+// Gazetteer is a set of places indexed by location and name.
+type Gazetteer struct {
+	places []Place
+	qt     *quadtree.Quadtree
+
+	names [][]string // lowercase names of places for Search
+}
+
+// New creates a Gazetteer of places.
+func New(places []Place) *Gazetteer {
+	g := &Gazetteer{places: places}
+	if len(places) != 0 {
+		g.qt = quadtree.New(placeSource(places))
+	}
+	g.names = make([][]string, len(places))
+	for i, p := range places {
+		g.names[i] = []string{strings.ToLower(p.Name)}
+		if p.ascii != "" {
+			g.names[i] = append(g.names[i], strings.ToLower(p.ascii))
+		}
+	}
+	return g
+}
+
+// Len returns the number of places in g.
+func (g *Gazetteer) Len() int {
+	return len(g.places)
+}
+
+// NearDist is the distance in degrees of arc (~55 km) within which
+// places are near enough to name locations after them.
+const NearDist = 0.5
+
+// Nearest returns the place nearest to lat, long. It reports
+// false if there is no place within maxDist degrees of arc.
+func (g *Gazetteer) Nearest(lat, long, maxDist float64) (Place, bool) {
+	if len(g.places) == 0 {
+		return Place{}, false
+	}
+	best, bestd := -1, math.Inf(1)
+	for r := math.Min(0.5, maxDist); ; r = math.Min(2*r, maxDist) {
+		g.rect(lat, long, r, func(i int) bool {
+			p := &g.places[i]
+			if d := Dist(lat, long, p.Lat, p.Long); d < bestd {
+				best, bestd = i, d
+			}
+			return true
+		})
+		if (best >= 0 && bestd <= r) || r >= maxDist || r >= 180 {
+			break
+		}
+	}
+	if best < 0 || bestd > maxDist {
+		return Place{}, false
+	}
+	return g.places[best], true
+}
+
+// rect calls f with the indices of places within a rectangle around
+// lat, long that has all places within r degrees of arc.
+func (g *Gazetteer) rect(lat, long, r float64, f func(i int) bool) {
+	la0, la1 := lat-r, lat+r
+	w := 180.0
+	if c := math.Abs(lat) + r; c < 90 {
+		w = math.Min(w, r/math.Cos(c*math.Pi/180))
+	}
+	if w >= 180 {
+		g.qt.RectFunc(-180, la0, 180, la1, f)
+		return
+	}
+	lo0, lo1 := long-w, long+w
+	g.qt.RectFunc(lo0, la0, lo1, la1, f)
+	// wrap around the antimeridian
+	if lo0 < -180 {
+		g.qt.RectFunc(lo0+360, la0, 180, la1, f)
+	}
+	if lo1 > 180 {
+		g.qt.RectFunc(-180, la0, lo1-360, la1, f)
+	}
+}
+
+// Dist returns the great circle distance
+// between two locations in degrees of arc.
+func Dist(lat0, long0, lat1, long1 float64) float64 {
+	const rad = math.Pi / 180
+	sdlat := math.Sin((lat1 - lat0) * rad / 2)
+	sdlong := math.Sin((long1 - long0) * rad / 2)
+	h := sdlat*sdlat + math.Cos(lat0*rad)*math.Cos(lat1*rad)*sdlong*sdlong
+	return 2 * math.Asin(math.Sqrt(math.Min(h, 1))) / rad
+}
+
+type placeSource []Place
+
+func (s placeSource) Len() int                { return len(s) }
+func (s placeSource) At(i int) (x, y float64) { return s[i].Long, s[i].Lat }
+
+// Open loads the GeoNames cities file at path, such as cities15000.txt
+// or cities15000.zip. Region and country names are read from
+// admin1CodesASCII.txt and countryInfo.txt in the same directory
+// if they exist. Otherwise regions are left empty and countries
+// are ISO 3166 codes.
+func Open(path string) (*Gazetteer, error) {
+	dir := filepath.Dir(path)
+	regions, err := readNames(filepath.Join(dir, "admin1CodesASCII.txt"), 0, 1)
+	if err != nil {
+		return nil, err
+	}
+	countries, err := readNames(filepath.Join(dir, "countryInfo.txt"), 0, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		z, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, err
+		}
+		defer z.Close()
+		var zf *zip.File
+		for _, f := range z.File {
+			if strings.HasSuffix(f.Name, ".txt") {
+				zf = f
+				break
+			}
+		}
+		if zf == nil {
+			return nil, fmt.Errorf("gazetteer: no .txt file in %s", path)
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		r = rc
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	places, err := readCities(r, regions, countries)
+	if err != nil {
+		return nil, fmt.Errorf("gazetteer: %s: %v", path, err)
+	}
+	return New(places), nil
+}
+
+// GeoNames cities file columns
+const (
+	colName       = 1
+	colASCIIName  = 2
+	colLat        = 4
+	colLong       = 5
+	colCountry    = 8
+	colAdmin1     = 10
+	colPopulation = 14
+
+	numCols = 19
+)
+
+// readCities reads places from the GeoNames cities file in r.
+// Region and country codes are resolved using regions and countries.
+func readCities(r io.Reader, regions, countries map[string]string) ([]Place, error) {
+	var places []Place
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20) // alternate names may be long
+	for line := 1; s.Scan(); line++ {
+		if s.Text() == "" {
+			continue
+		}
+		f := strings.Split(s.Text(), "\t")
+		if len(f) < numCols {
+			return nil, fmt.Errorf("line %d: %d columns, want %d", line, len(f), numCols)
+		}
+		lat, err1 := strconv.ParseFloat(f[colLat], 64)
+		long, err2 := strconv.ParseFloat(f[colLong], 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("line %d: invalid location", line)
+		}
+		pop, _ := strconv.Atoi(f[colPopulation])
+		cc := f[colCountry]
+		p := Place{
+			Name:       f[colName],
+			Region:     regions[cc+"."+f[colAdmin1]],
+			Country:    cc,
+			Lat:        lat,
+			Long:       long,
+			Population: pop,
+		}
+		if n, ok := countries[cc]; ok {
+			p.Country = n
+		}
+		if a := f[colASCIIName]; a != p.Name {
+			p.ascii = a
+		}
+		places = append(places, p)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(places) == 0 {
+		return nil, errors.New("no places")
+	}
+	return places, nil
+}
+
+// readNames reads a tab separated GeoNames code file at path,
+// and returns the names in column namecol by the code in keycol.
+// Lines starting with '#' are comments. It returns nil
+// without an error if path does not exist.
+func readNames(path string, keycol, namecol int) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	m := make(map[string]string)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if strings.HasPrefix(s.Text(), "#") {
+			continue
+		}
+		v := strings.Split(s.Text(), "\t")
+		if len(v) > keycol && len(v) > namecol {
+			m[v[keycol]] = v[namecol]
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("gazetteer: %s: %v", path, err)
+	}
+	return m, nil
+}
@@ -0,0 +1,113 @@
+package gazetteer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// cityLine returns a line of a GeoNames cities file.
+func cityLine(name, ascii, lat, long, cc, admin1, pop string) string {
+	f := make([]string, numCols)
+	f[0], f[1], f[2] = "1", name, ascii
+	f[4], f[5], f[8], f[10], f[14] = lat, long, cc, admin1, pop
+	return strings.Join(f, "\t")
+}
+
+var testCities = []string{
+	cityLine("Lisbon", "Lisbon", "38.71667", "-9.13333", "PT", "14", "517802"),
+	cityLine("Porto", "Porto", "41.14961", "-8.61099", "PT", "17", "249633"),
+	cityLine("Kyōto", "Kyoto", "35.02107", "135.75385", "JP", "22", "1459640"),
+	cityLine("Suva", "Suva", "-18.14161", "178.44149", "FJ", "01", "77366"),
+	cityLine("Apia", "Apia", "-13.83333", "-171.76666", "WS", "11", "40407"),
+}
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gazetteer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"cities15000.txt":      strings.Join(testCities, "\n") + "\n",
+		"admin1CodesASCII.txt": "PT.14\tLisbon\tLisbon\t2267056\nJP.22\tKyoto\tKyoto\t1857907\n",
+		"countryInfo.txt":      "#ISO\tISO3\tISO-Numeric\tfips\tCountry\nPT\tPRT\t620\tPO\tPortugal\n",
+	}
+	for n, s := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, n), []byte(s), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g, err := Open(filepath.Join(dir, "cities15000.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Len() != len(testCities) {
+		t.Fatalf("got %d places, want %d", g.Len(), len(testCities))
+	}
+
+	tests := []struct {
+		lat, long, max float64
+		want           Place // empty if none within max
+	}{
+		{38.7, -9.2, NearDist, Place{Name: "Lisbon", Region: "Lisbon", Country: "Portugal"}},
+		{40, -8.7, 180, Place{Name: "Porto", Country: "Portugal"}},
+		{40, -8.7, NearDist, Place{}},
+		{35, 135.7, NearDist, Place{Name: "Kyōto", Region: "Kyoto", Country: "JP"}},
+		{-17, 179.9, 180, Place{Name: "Suva", Country: "FJ"}},
+		{-14, -179.9, 180, Place{Name: "Suva", Country: "FJ"}}, // across the antimeridian
+		{-13, -170, 180, Place{Name: "Apia", Country: "WS"}},
+		{89, 0, 180, Place{Name: "Porto", Country: "Portugal"}},
+		{89, 0, 10, Place{}},
+		{38.7, -9.2, 0, Place{}},
+	}
+	for _, tt := range tests {
+		p, ok := g.Nearest(tt.lat, tt.long, tt.max)
+		if ok != (tt.want.Name != "") || p.Name != tt.want.Name || p.Region != tt.want.Region || p.Country != tt.want.Country {
+			t.Errorf("Nearest(%v, %v, %v) = %+v, %v, want %+v", tt.lat, tt.long, tt.max, p, ok, tt.want)
+		}
+	}
+
+	if _, ok := New(nil).Nearest(0, 0, 180); ok {
+		t.Error("empty gazetteer has nearest place")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	g := New([]Place{
+		{Name: "Lisbon", Population: 517802},
+		{Name: "Lisburn", Population: 71465},
+		{Name: "Kyōto", ascii: "Kyoto", Population: 1459640},
+		{Name: "Kyōtanabe", ascii: "Kyotanabe", Population: 67000},
+		{Name: "New York City", Population: 8175133},
+		{Name: "Porto", Population: 249633},
+	})
+	tests := []struct {
+		q    string
+		want []string
+	}{
+		{"lisb", []string{"Lisbon", "Lisburn"}},
+		{"Lisburn", []string{"Lisburn"}},
+		{"lisbn", []string{"Lisbon", "Lisburn"}},
+		{"kyoto", []string{"Kyōto", "Kyōtanabe"}},
+		{"KYŌ", []string{"Kyōto", "Kyōtanabe"}},
+		{"york", []string{"New York City"}},
+		{"prto", []string{"Porto"}},
+		{"xyz", nil},
+		{" ", nil},
+	}
+	for _, tt := range tests {
+		m := g.Search(tt.q)
+		var got []string
+		for _, p := range m {
+			got = append(got, p.Name)
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("Search(%q) = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+}
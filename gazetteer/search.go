@@ -0,0 +1,141 @@
+package gazetteer
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Match is a place matching a search query.
+type Match struct {
+	Place
+
+	// Score is the quality of the match, lower is better:
+	// 0 for full names, 1 for name prefixes, 2 for word prefixes
+	// and 2 + edit distance for fuzzy matches.
+	Score int
+}
+
+// Search returns the places having a name or a word in their name
+// starting with q, ignoring case. Names or their prefixes within
+// a small edit distance of q, depending on its length, match as well.
+// Matches are sorted by score, then by population.
+func (g *Gazetteer) Search(q string) []Match {
+	q = strings.ToLower(strings.TrimSpace(q))
+	if q == "" {
+		return nil
+	}
+	qr := []rune(q)
+	maxd := maxEdits(len(qr))
+
+	var m []Match
+	for i, names := range g.names {
+		score := -1
+		for _, n := range names {
+			if s, ok := matchName(q, qr, n, maxd); ok && (score < 0 || s < score) {
+				score = s
+			}
+		}
+		if score >= 0 {
+			m = append(m, Match{g.places[i], score})
+		}
+	}
+	sort.Sort(matchesByScore(m))
+	return m
+}
+
+// maxEdits returns the edit distance allowed
+// for fuzzy matching a query of n runes.
+func maxEdits(n int) int {
+	switch {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	}
+	return 2
+}
+
+// matchName returns the score of the lowercase query q having runes qr
+// for the lowercase name n, and reports whether n matches.
+func matchName(q string, qr []rune, n string, maxd int) (int, bool) {
+	switch {
+	case n == q:
+		return 0, true
+	case strings.HasPrefix(n, q):
+		return 1, true
+	}
+	for _, w := range strings.FieldsFunc(n, isWordSep) {
+		if strings.HasPrefix(w, q) {
+			return 2, true
+		}
+	}
+	if maxd == 0 {
+		return 0, false
+	}
+	nr := []rune(n)
+	d := editDist(qr, nr, maxd)
+	if len(nr) > len(qr) {
+		// fuzzy prefix
+		if pd := editDist(qr, nr[:len(qr)], maxd); pd < d {
+			d = pd
+		}
+	}
+	if d > maxd {
+		return 0, false
+	}
+	return 2 + d, true
+}
+
+func isWordSep(r rune) bool {
+	return r == '-' || r == '\'' || unicode.IsSpace(r)
+}
+
+// editDist returns the Levenshtein distance of a and b,
+// or a value above max if it is larger than max.
+func editDist(a, b []rune, max int) int {
+	if d := len(a) - len(b); d > max || -d > max {
+		return max + 1
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowmin := i
+		for j := 1; j <= len(b); j++ {
+			c := prev[j-1]
+			if a[i-1] != b[j-1] {
+				c++
+			}
+			if v := prev[j] + 1; v < c {
+				c = v
+			}
+			if v := cur[j-1] + 1; v < c {
+				c = v
+			}
+			cur[j] = c
+			if c < rowmin {
+				rowmin = c
+			}
+		}
+		if rowmin > max {
+			return max + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+type matchesByScore []Match
+
+func (s matchesByScore) Len() int      { return len(s) }
+func (s matchesByScore) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s matchesByScore) Less(i, j int) bool {
+	if s[i].Score != s[j].Score {
+		return s[i].Score < s[j].Score
+	}
+	return s[i].Population > s[j].Population
+}
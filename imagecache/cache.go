@@ -2,22 +2,26 @@ package imagecache
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
 	"image"
 	"image/color"
+	"image/draw"
 	"image/jpeg"
 	"image/png"
 	"io"
 	"log"
+	"path"
 	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/tajtiattila/basedir"
+	"github.com/tajtiattila/photomap/gazetteer"
 	"github.com/tajtiattila/photomap/source"
 )
 
@@ -25,8 +29,20 @@ type ImageInfo struct {
 	// key for thumb/icon lookup
 	Id string `json:"id"`
 
+	// source.MediaVideo for videos, empty for photos
+	MediaType string `json:"type,omitempty"`
+
 	CreateTime time.Time
 
+	// local wall clock time and UTC of CreateTime,
+	// and where it comes from (eg. source.TimeOffset)
+	LocalTime  string    `json:"localTime,omitempty"`
+	UTC        time.Time `json:"utc"`
+	TimeSource string    `json:"timesrc,omitempty"`
+
+	// length of videos in seconds
+	Duration float64 `json:"duration,omitempty"`
+
 	// image dimensions
 	Width  int `json:"w,omitempty"`
 	Height int `json:"h,omitempty"`
@@ -34,15 +50,81 @@ type ImageInfo struct {
 	// gps position
 	Lat  float64 `json:"lat,omitempty"`
 	Long float64 `json:"long,omitempty"`
+
+	// source of gps position if not from image
+	LocSource string `json:"locsrc,omitempty"`
+
+	// nearest place in the gazetteer, if any
+	City    string `json:"city,omitempty"`
+	Region  string `json:"region,omitempty"`
+	Country string `json:"country,omitempty"`
+
+	Rating int `json:"rating,omitempty"`
+
+	// gps image direction in degrees, nil if unknown
+	Direction *float64 `json:"dir,omitempty"`
+
+	// Direction is from magnetic rather than true north
+	Magnetic bool `json:"magnetic,omitempty"`
+
+	// label of the image source, if the source is a source.Labeler
+	Source string `json:"src,omitempty"`
+
+	// collections of the image, if the source is a source.Collector
+	Collections []string `json:"collections,omitempty"`
+}
+
+// Details are the metadata of an image not needed to show it on the map.
+type Details struct {
+	// camera
+	Make  string `json:"make,omitempty"`
+	Model string `json:"model,omitempty"`
+	Lens  string `json:"lens,omitempty"`
+
+	// exposure
+	FocalLength float64 `json:"focalLength,omitempty"` // mm
+	Aperture    float64 `json:"aperture,omitempty"`    // f-number
+	Exposure    float64 `json:"exposure,omitempty"`    // seconds
+	ISO         int     `json:"iso,omitempty"`
+
+	// gps altitude in meters
+	Altitude *float64 `json:"alt,omitempty"`
+
+	Keywords []string `json:"keywords,omitempty"`
+}
+
+// localTimeLayout is the layout of ImageInfo.LocalTime.
+const localTimeLayout = "2006-01-02T15:04:05"
+
+// Photo is an image with its details.
+type Photo struct {
+	ImageInfo
+	Details
 }
 
 type ImageCache struct {
-	src source.ImageSource
-	db  *leveldb.DB
+	src  source.ImageSource
+	csrc source.Source // src adapted for listing, Info and Open
+	db   *leveldb.DB
+
+	loc source.Locator // locates images without gps position, may be nil
+
+	cameraZone *time.Location // zone of local creation times, nil for time.Local
+
+	gaz *gazetteer.Gazetteer // names places of images, may be nil
+
+	writeBack bool // write location edits to src
 
-	// keysrcid images are read-only after initialized in init()
-	keysrcid map[string]string
-	images   []ImageInfo
+	mtx      sync.RWMutex      // protects keysrcid, images, imageidx and onChange
+	keysrcid map[string]string // source ids of keys
+	images   []ImageInfo       // replaced on change, never modified in place
+	imageidx map[string]int    // index of keys into images
+	onChange func()            // called after changes from src
+
+	editMtx sync.Mutex // serializes location edits and changes from src
+
+	watchSrc  bool          // watch src for changes
+	watchDone chan struct{} // closed when watching src has stopped
 
 	photoIconMtx sync.RWMutex // protects photoIcon
 	photoIcon    map[string]cachedImage
@@ -63,7 +145,8 @@ type cachedData struct {
 	err error
 }
 
-func New(src source.ImageSource) (*ImageCache, error) {
+// New creates a new ImageCache for src using opt.
+func New(src source.ImageSource, opt ...Option) (*ImageCache, error) {
 	cachedir, err := basedir.Cache.EnsureDir("PhotoMap", 0700)
 	if err != nil {
 		return nil, err
@@ -72,28 +155,60 @@ func New(src source.ImageSource) (*ImageCache, error) {
 	if err != nil {
 		return nil, err
 	}
+	ic, err := newCache(src, db, opt...)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return ic, nil
+}
+
+// newCache creates a new ImageCache for src using db.
+func newCache(src source.ImageSource, db *leveldb.DB, opt ...Option) (*ImageCache, error) {
 	ic := &ImageCache{
 		src:      src,
+		csrc:     source.Adapt(src),
 		db:       db,
 		keysrcid: make(map[string]string),
+		imageidx: make(map[string]int),
 
 		photoIcon: make(map[string]cachedImage),
 	}
+	for _, o := range opt {
+		o.set(ic)
+	}
 	ic.photoIconGen = newParallelGroup(4)
 	ic.thumbGen = newParallelGroup(4)
-	return ic, ic.init()
+	if err := ic.init(context.Background()); err != nil {
+		return nil, err
+	}
+	if ic.watchSrc {
+		if err := ic.startWatch(); err != nil {
+			return nil, err
+		}
+	}
+	return ic, nil
 }
 
+// Images returns the images that have a location.
+// The result must not be modified.
 func (ic *ImageCache) Images() []ImageInfo {
+	ic.mtx.RLock()
+	defer ic.mtx.RUnlock()
 	return ic.images
 }
 
 func (ic *ImageCache) Close() error {
 	ic.src.Close()
+	if ic.watchDone != nil {
+		<-ic.watchDone
+	}
 	return ic.db.Close()
 }
 
-func (ic *ImageCache) PhotoIcon(key string) (image.Image, error) {
+// PhotoIcon returns the icon of key shown on the map. Reading the
+// source stops when ctx is done and no other callers wait for the icon.
+func (ic *ImageCache) PhotoIcon(ctx context.Context, key string) (image.Image, error) {
 	ic.photoIconMtx.RLock()
 	cim, ok := ic.photoIcon[key]
 	ic.photoIconMtx.RUnlock()
@@ -101,9 +216,13 @@ func (ic *ImageCache) PhotoIcon(key string) (image.Image, error) {
 		return cim.im, cim.err
 	}
 
-	im, err := ic.photoIconGen.Do(key, func() (interface{}, error) {
-		return ic.createPhotoIcon(key)
+	im, err := ic.photoIconGen.Do(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return ic.createPhotoIcon(ctx, key)
 	})
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		// not cached, may succeed next time
+		return nil, err
+	}
 	if im != nil {
 		cim.im = im.(image.Image)
 	}
@@ -116,8 +235,11 @@ func (ic *ImageCache) PhotoIcon(key string) (image.Image, error) {
 	return cim.im, cim.err
 }
 
-func (ic *ImageCache) Thumbnail(key string) (io.ReadSeeker, time.Time, error) {
-	data, err := ic.thumbnail(key)
+// Thumbnail returns the thumbnail of key as JPEG, and the time it was
+// created. Reading the source stops when ctx is done and no other
+// callers wait for the thumbnail.
+func (ic *ImageCache) Thumbnail(ctx context.Context, key string) (io.ReadSeeker, time.Time, error) {
+	data, err := ic.thumbnail(ctx, key)
 	if err != nil {
 		return nil, time.Time{}, err
 	}
@@ -129,7 +251,7 @@ func (ic *ImageCache) Thumbnail(key string) (io.ReadSeeker, time.Time, error) {
 	return bytes.NewReader(data), mt, nil
 }
 
-func (ic *ImageCache) thumbnail(key string) ([]byte, error) {
+func (ic *ImageCache) thumbnail(ctx context.Context, key string) ([]byte, error) {
 	data, err := ic.db.Get([]byte(thumbPfx+key), nil)
 	if err == nil {
 		return data, nil
@@ -138,8 +260,8 @@ func (ic *ImageCache) thumbnail(key string) ([]byte, error) {
 		return nil, err
 	}
 
-	di, err := ic.thumbGen.Do(key, func() (interface{}, error) {
-		return ic.createThumb(key)
+	di, err := ic.thumbGen.Do(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return ic.createThumb(ctx, key)
 	})
 	if err != nil {
 		return nil, err
@@ -147,74 +269,234 @@ func (ic *ImageCache) thumbnail(key string) ([]byte, error) {
 	return di.([]byte), nil
 }
 
-func (ic *ImageCache) init() error {
-	for srcid, mt := range ic.src.ModTimes() {
+// initBatch is the number of images
+// whose info is requested at once by init.
+const initBatch = 64
+
+func (ic *ImageCache) init(ctx context.Context) error {
+	type item struct {
+		key, srcid string
+		mt         time.Time
+		ce         cacheEntry
+		fresh      bool
+	}
+	var (
+		batch []item
+		stale []string // source ids of batch items that are not fresh
+		n     int
+		last  = time.Now()
+	)
+	flush := func() error {
+		var infos []source.ImageInfo
+		var errs []error
+		if len(stale) != 0 {
+			infos, errs = ic.csrc.(source.InfoBatcher).InfoMany(ctx, stale)
+		}
+		for _, it := range batch {
+			if !it.fresh {
+				ii, err := infos[0], errs[0]
+				infos, errs = infos[1:], errs[1:]
+				if err == context.Canceled || err == context.DeadlineExceeded {
+					return err
+				}
+				var serr error
+				it.ce, serr = ic.storeCacheEntry(it.key, it.srcid, it.mt, ii, err)
+				if serr != nil {
+					return serr
+				}
+			}
+			h, err := ic.locHistory(it.key)
+			if err != nil {
+				return err
+			}
+			if ii, ok := ic.imageInfo(it.ce, h); ok {
+				ic.imageidx[it.key] = len(ic.images)
+				ic.images = append(ic.images, ii)
+			}
+		}
+		batch, stale = batch[:0], stale[:0]
+		return nil
+	}
+
+	err := ic.csrc.List(ctx, func(srcid string, mt time.Time) error {
 		key, err := ic.getKey(srcid)
 		if err != nil {
 			return err
 		}
 		ic.keysrcid[key] = srcid
-		ce, err := ic.loadFreshCacheEntry(key, srcid, mt)
+		ce, fresh, err := ic.cachedEntry(key, srcid, mt)
 		if err != nil {
 			return err
 		}
-		if !ce.IsErr {
-			ic.images = append(ic.images, ce.ImageInfo)
+		if !fresh {
+			stale = append(stale, srcid)
+		}
+		batch = append(batch, item{key, srcid, mt, ce, fresh})
+
+		if n++; time.Since(last) > 10*time.Second {
+			log.Printf("%d images listed", n)
+			last = time.Now()
+		}
+		if len(batch) < initBatch {
+			return nil
 		}
+		return flush()
+	})
+	if err != nil {
+		return err
 	}
+	return flush()
+}
 
-	return nil
+// imageInfo returns the ImageInfo to show for ce. It reports
+// false if the image has no location.
+func (ic *ImageCache) imageInfo(ce cacheEntry, h []locEdit) (ImageInfo, bool) {
+	ii, ok := ic.imageLocation(ce, h)
+	if ok && ic.gaz != nil {
+		if p, ok := ic.gaz.Nearest(ii.Lat, ii.Long, gazetteer.NearDist); ok {
+			ii.City, ii.Region, ii.Country = p.Name, p.Region, p.Country
+		}
+	}
+	return ii, ok
+}
+
+// imageLocation returns ce with its location
+// from the edit history h or ic.loc.
+func (ic *ImageCache) imageLocation(ce cacheEntry, h []locEdit) (ImageInfo, bool) {
+	if l, ok := ic.src.(source.Labeler); ok {
+		ce.Source = l.Label(ce.SrcId)
+	}
+	if c, ok := ic.src.(source.Collector); ok {
+		ce.Collections = c.Collections(ce.SrcId)
+	}
+	if n := len(h); n != 0 && (!ce.IsErr || ce.NoLoc) {
+		ii := ce.ImageInfo
+		ii.Lat, ii.Long = h[n-1].Lat, h[n-1].Long
+		ii.LocSource = LocSourceEdit
+		return ii, true
+	}
+	if !ce.IsErr {
+		return ce.ImageInfo, true
+	}
+	return ic.locate(ce)
+}
+
+// locate finds the location of an image without one using ic.loc.
+func (ic *ImageCache) locate(ce cacheEntry) (ImageInfo, bool) {
+	if !ce.NoLoc || ic.loc == nil {
+		return ImageInfo{}, false
+	}
+	t := ce.CreateTime
+	if ce.TimeSource == source.TimeLocal && ic.cameraZone != nil {
+		// same wall clock in the camera zone
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(),
+			t.Second(), t.Nanosecond(), ic.cameraZone)
+	}
+	fix, ok := ic.loc.Locate(t)
+	if !ok {
+		return ImageInfo{}, false
+	}
+	ii := ce.ImageInfo
+	ii.Lat, ii.Long = fix.Lat, fix.Long
+	ii.LocSource = fix.Source
+	return ii, true
 }
 
 const (
 	imageInfoPfx = "imageinfo|"
 	photoIconPfx = "photoicon|"
 	thumbPfx     = "thumb|"
+	locationPfx  = "location|"
+	keyPfx       = "key|"
 )
 
 // load cache entry and refresh if needed
-func (ic *ImageCache) loadFreshCacheEntry(key, srcid string, mt time.Time) (cacheEntry, error) {
-	k := []byte(imageInfoPfx + key)
-	data, err := ic.db.Get(k, nil)
-	if err == nil {
-		var ce cacheEntry
-		if err = json.Unmarshal(data, &ce); err != nil {
-			return cacheEntry{}, err
-		}
-		if ce.SrcId == srcid && ce.ModTime.Before(mt) {
-			// cache up to date
-			return ce, nil
-		}
-		delPfx := []string{photoIconPfx, thumbPfx}
-		for _, dp := range delPfx {
-			err = ic.db.Delete([]byte(dp+key), nil)
-			if err != nil && err != leveldb.ErrNotFound {
-				log.Printf("delete from cache %q/%q: %v", key, srcid, err)
-			}
+func (ic *ImageCache) loadFreshCacheEntry(ctx context.Context, key, srcid string, mt time.Time) (cacheEntry, error) {
+	ce, fresh, err := ic.cachedEntry(key, srcid, mt)
+	if err != nil || fresh {
+		return ce, err
+	}
+	ii, err := ic.csrc.Info(ctx, srcid)
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return cacheEntry{}, err
+	}
+	return ic.storeCacheEntry(key, srcid, mt, ii, err)
+}
+
+// cachedEntry returns the cache entry of key, and reports whether it is
+// up to date with srcid and mt. Cached images of stale entries are deleted.
+func (ic *ImageCache) cachedEntry(key, srcid string, mt time.Time) (ce cacheEntry, fresh bool, err error) {
+	data, err := ic.db.Get([]byte(imageInfoPfx+key), nil)
+	if err == leveldb.ErrNotFound {
+		return cacheEntry{}, false, nil
+	}
+	if err != nil {
+		return cacheEntry{}, false, err
+	}
+	if err = json.Unmarshal(data, &ce); err != nil {
+		return cacheEntry{}, false, err
+	}
+	if ce.Version == cacheVersion && ce.SrcId == srcid && ce.ModTime.Equal(mt) {
+		// cache up to date
+		return ce, true, nil
+	}
+	delPfx := []string{photoIconPfx, thumbPfx}
+	for _, dp := range delPfx {
+		err = ic.db.Delete([]byte(dp+key), nil)
+		if err != nil && err != leveldb.ErrNotFound {
+			log.Printf("delete from cache %q/%q: %v", key, srcid, err)
 		}
 	}
-	ii, err := ic.src.Info(srcid)
-	ce := cacheEntry{SrcId: srcid}
+	return ce, false, nil
+}
+
+// storeCacheEntry stores the cache entry of key
+// made from the result ii and err of Info.
+func (ic *ImageCache) storeCacheEntry(key, srcid string, mt time.Time, ii source.ImageInfo, err error) (cacheEntry, error) {
+	ce := cacheEntry{Version: cacheVersion, SrcId: srcid, ModTime: mt}
 	if err != nil {
 		ce.IsErr = true
-	} else {
+		ce.NoLoc = source.IsNoLoc(err)
+	}
+	if err == nil || ce.NoLoc {
 		ce.ImageInfo = ImageInfo{
 			Id:         key,
+			MediaType:  ii.MediaType,
 			CreateTime: ii.CreateTime,
+			LocalTime:  ii.CreateTime.Format(localTimeLayout),
+			UTC:        ii.CreateTime.UTC(),
+			TimeSource: ii.TimeSource,
+			Duration:   ii.Duration.Seconds(),
 			Width:      ii.Width,
 			Height:     ii.Height,
 			Lat:        ii.Lat,
 			Long:       ii.Long,
+			LocSource:  ii.LocSource,
+			Rating:     ii.Rating,
+			Direction:  ii.Direction,
+			Magnetic:   ii.Magnetic,
+		}
+		c := ii.Camera
+		ce.Details = Details{
+			Make:        c.Make,
+			Model:       c.Model,
+			Lens:        c.Lens,
+			FocalLength: c.FocalLength,
+			Aperture:    c.Aperture,
+			Exposure:    c.Exposure,
+			ISO:         c.ISO,
+			Altitude:    ii.Altitude,
+			Keywords:    ii.Keywords,
 		}
 	}
-	data, err = json.Marshal(ce)
+	data, err := json.Marshal(ce)
 	if err != nil {
 		panic("can't marshal cacheEntry")
 	}
-	return ce, ic.db.Put(k, data, nil)
+	return ce, ic.db.Put([]byte(imageInfoPfx+key), data, nil)
 }
 
-func (ic *ImageCache) createPhotoIcon(key string) (image.Image, error) {
+func (ic *ImageCache) createPhotoIcon(ctx context.Context, key string) (image.Image, error) {
 	im, err := ic.loadImage(photoIconPfx + key)
 	if err == nil {
 		return im, nil
@@ -224,20 +506,16 @@ func (ic *ImageCache) createPhotoIcon(key string) (image.Image, error) {
 		return nil, err
 	}
 
-	rc, err := ic.src.Open(ic.keysrcid[key])
-	if err != nil {
-		log.Printf("createPhotoIcon read %q: %v", key, err)
-		return nil, err
-	}
-	defer rc.Close()
-
-	im, err = source.LoadImage(rc)
+	im, video, err := ic.loadSourceImage(ctx, key, 20)
 	if err != nil {
-		log.Println(err)
+		log.Printf("createPhotoIcon %q: %v", key, err)
 		return nil, err
 	}
 
 	im = MakeScaler(20, 20).Scale(im)
+	if video {
+		im = PlayBadge(im)
+	}
 
 	// add frame
 	im = Frame(im, 2, color.RGBA{255, 255, 255, 255})
@@ -259,21 +537,17 @@ func (ic *ImageCache) createPhotoIcon(key string) (image.Image, error) {
 
 // generate thumb for key, store it in db, and return the new
 // image encoded as jpeg
-func (ic *ImageCache) createThumb(key string) ([]byte, error) {
-	rc, err := ic.src.Open(ic.keysrcid[key])
-	if err != nil {
-		log.Printf("source read %q: %v", key, err)
-		return nil, err
-	}
-	defer rc.Close()
-
-	im, err := source.LoadImage(rc)
+func (ic *ImageCache) createThumb(ctx context.Context, key string) ([]byte, error) {
+	im, video, err := ic.loadSourceImage(ctx, key, 100)
 	if err != nil {
-		log.Printf("source decode %q: %v", key, err)
+		log.Printf("createThumb %q: %v", key, err)
 		return nil, err
 	}
 
 	im = MakeScaler(100, 100).Scale(im)
+	if video {
+		im = PlayBadge(im)
+	}
 
 	mt := make([]byte, 8)
 	binary.BigEndian.PutUint64(mt, uint64(time.Now().Unix()))
@@ -292,16 +566,221 @@ func (ic *ImageCache) createThumb(key string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// loadSourceImage loads the image of key from the source to be
+// scaled to size, and reports whether it is a video. A thumbnail
+// from the source is used if available. A placeholder is returned
+// for videos without a poster image.
+func (ic *ImageCache) loadSourceImage(ctx context.Context, key string, size int) (im image.Image, video bool, err error) {
+	ce, err := ic.cacheEntry(key)
+	if err != nil {
+		return nil, false, err
+	}
+	video = ce.MediaType == source.MediaVideo
+
+	if im := ic.sourceThumbnail(ctx, key, size); im != nil {
+		return im, video, nil
+	}
+
+	rc, err := ic.csrc.Open(ctx, ic.srcId(key))
+	if err != nil {
+		return nil, video, err
+	}
+	defer rc.Close()
+
+	im, err = source.LoadImage(rc)
+	if video && err != nil && ctx.Err() == nil {
+		if err != source.ErrNoPoster {
+			log.Printf("load poster %q: %v", key, err)
+		}
+		im, err = videoPlaceholder(ce.Width, ce.Height, size), nil
+	}
+	return im, video, err
+}
+
+// videoPlaceholder returns a gray image having the aspect ratio
+// of a w×h video, or 16:9 if unknown, fitting size×size.
+func videoPlaceholder(w, h, size int) image.Image {
+	if w <= 0 || h <= 0 {
+		w, h = 16, 9
+	}
+	pw, ph := size, size
+	if w > h {
+		ph = h * size / w
+	} else {
+		pw = w * size / h
+	}
+	if pw < 1 {
+		pw = 1
+	}
+	if ph < 1 {
+		ph = 1
+	}
+	m := image.NewRGBA(image.Rect(0, 0, pw, ph))
+	draw.Draw(m, m.Bounds(), image.NewUniform(color.RGBA{48, 48, 48, 255}), image.Point{}, draw.Src)
+	return m
+}
+
+// sourceThumbnail returns the thumbnail of key from the source,
+// or nil if it is not available.
+func (ic *ImageCache) sourceThumbnail(ctx context.Context, key string, size int) image.Image {
+	t, ok := ic.src.(source.Thumbnailer)
+	if !ok {
+		return nil
+	}
+	rc, err := t.Thumbnail(ctx, ic.srcId(key), size)
+	if err != nil {
+		if err != source.ErrNotSupported && ctx.Err() == nil {
+			log.Printf("source thumbnail %q: %v", key, err)
+		}
+		return nil
+	}
+	defer rc.Close()
+	im, _, err := image.Decode(rc)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("decode source thumbnail %q: %v", key, err)
+		}
+		return nil
+	}
+	return im
+}
+
+// Photo returns the image of key shown on the map with its details.
+func (ic *ImageCache) Photo(key string) (Photo, error) {
+	ic.mtx.RLock()
+	i, ok := ic.imageidx[key]
+	var ii ImageInfo
+	if ok {
+		ii = ic.images[i]
+	}
+	ic.mtx.RUnlock()
+	if !ok {
+		return Photo{}, ErrNotFound
+	}
+	ce, err := ic.cacheEntry(key)
+	if err != nil {
+		return Photo{}, err
+	}
+	return Photo{ii, ce.Details}, nil
+}
+
+// Open opens the original media of key. The name of
+// the media in its source is returned for content type lookup.
+func (ic *ImageCache) Open(ctx context.Context, key string) (rc io.ReadCloser, name string, err error) {
+	srcid := ic.srcId(key)
+	if srcid == "" {
+		return nil, "", ErrNotFound
+	}
+	if n, ok := ic.src.(source.Namer); ok {
+		name = n.Name(srcid)
+	}
+	if name == "" {
+		name = path.Base(srcid)
+	}
+	rc, err = ic.csrc.Open(ctx, srcid)
+	return rc, name, err
+}
+
+// srcId returns the source id of key,
+// or the empty string if key is unknown.
+func (ic *ImageCache) srcId(key string) string {
+	ic.mtx.RLock()
+	defer ic.mtx.RUnlock()
+	return ic.keysrcid[key]
+}
+
+// getKey returns the key of srcid, and creates it if srcid is new.
+// Keys of aliases of srcid, if the source is a source.Aliaser, are
+// reused so that cached data and location edits are kept when source
+// ids change, such as when source labels are added or changed.
 func (ic *ImageCache) getKey(srcid string) (string, error) {
-	k := append([]byte("key|"), srcid...)
+	k := append([]byte(keyPfx), srcid...)
 	data, err := ic.db.Get(k, nil)
-	if err == nil {
+	if err == nil && !ic.keyInUse(string(data), srcid) {
 		return string(data), nil
 	}
-	if err != leveldb.ErrNotFound {
+	if err != nil && err != leveldb.ErrNotFound {
 		return "", err
 	}
 
+	var aliases []string
+	if a, ok := ic.src.(source.Aliaser); ok {
+		aliases = a.Aliases(srcid)
+	}
+	key, err := ic.aliasKey(srcid, aliases)
+	if err != nil {
+		return "", err
+	}
+	if key == "" {
+		if key, err = ic.newKey(srcid); err != nil {
+			return "", err
+		}
+	}
+	if err := ic.db.Put(k, []byte(key), nil); err != nil {
+		return "", err
+	}
+	// find key with aliases later, when srcid changes again
+	for _, a := range aliases {
+		ak := []byte(keyPfx + a)
+		if has, err := ic.db.Has(ak, nil); err != nil || has {
+			continue
+		}
+		if err := ic.db.Put(ak, []byte(key), nil); err != nil {
+			return "", err
+		}
+	}
+	return key, nil
+}
+
+// aliasKey returns the key of the first alias of srcid having one
+// that is not used by another image, or the empty string if there is
+// none. The source id of the cache entry of the key is set to srcid.
+func (ic *ImageCache) aliasKey(srcid string, aliases []string) (string, error) {
+	for _, a := range aliases {
+		data, err := ic.db.Get([]byte(keyPfx+a), nil)
+		if err == leveldb.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		key := string(data)
+		if ic.keyInUse(key, srcid) {
+			continue
+		}
+
+		// keep cached data of key fresh
+		ek := []byte(imageInfoPfx + key)
+		data, err = ic.db.Get(ek, nil)
+		if err == nil {
+			var ce cacheEntry
+			if json.Unmarshal(data, &ce) == nil && ce.SrcId == a {
+				ce.SrcId = srcid
+				if data, err = json.Marshal(ce); err == nil {
+					err = ic.db.Put(ek, data, nil)
+				}
+			}
+		}
+		if err != nil && err != leveldb.ErrNotFound {
+			return "", err
+		}
+		return key, nil
+	}
+	return "", nil
+}
+
+// keyInUse reports whether key is used by an image other than srcid.
+// The key of an alias may be stored for several source ids,
+// such as for an image in sources that have been relabelled.
+func (ic *ImageCache) keyInUse(key, srcid string) bool {
+	ic.mtx.RLock()
+	defer ic.mtx.RUnlock()
+	id, ok := ic.keysrcid[key]
+	return ok && id != srcid
+}
+
+// newKey returns a new key for srcid.
+func (ic *ImageCache) newKey(srcid string) (string, error) {
 	hash := sha1.Sum([]byte(srcid))
 	h := hash[:9]
 	enc := base64.RawURLEncoding
@@ -316,8 +795,7 @@ func (ic *ImageCache) getKey(srcid string) (string, error) {
 		}
 		if !has {
 			// key not in use yet
-			err = ic.db.Put(k, key[len(prefix):], nil)
-			return string(key[len(prefix):]), err
+			return string(key[len(prefix):]), nil
 		}
 		incByteArray(h)
 	}
@@ -354,10 +832,21 @@ func incByteArray(p []byte) {
 	}
 }
 
+// cacheVersion is increased when cacheEntry or the way it is
+// filled changes, so that entries of earlier versions are refreshed.
+const cacheVersion = 6
+
 type cacheEntry struct {
+	Version int `json:",omitempty"`
+
 	SrcId string
 
 	ModTime time.Time
 	ImageInfo
-	IsErr bool
+	Details Details
+	IsErr   bool
+
+	// NoLoc is set if the image is valid but has no location,
+	// ImageInfo then has everything except Lat and Long.
+	NoLoc bool
 }
@@ -0,0 +1,275 @@
+package imagecache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/tajtiattila/photomap/source"
+)
+
+// testSource is an image source of images with known infos.
+type testSource struct {
+	mu    sync.Mutex
+	infos map[string]source.ImageInfo
+	errs  map[string]error
+	mts   map[string]time.Time
+}
+
+func newTestSource() *testSource {
+	return &testSource{
+		infos: make(map[string]source.ImageInfo),
+		errs:  make(map[string]error),
+		mts:   make(map[string]time.Time),
+	}
+}
+
+// set adds or updates id with info ii and modtime mt.
+func (s *testSource) set(id string, ii source.ImageInfo, mt time.Time) {
+	s.setErr(id, ii, nil, mt)
+}
+
+// setErr adds or updates id with the result ii and err
+// of Info and modtime mt.
+func (s *testSource) setErr(id string, ii source.ImageInfo, err error, mt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.infos[id] = ii
+	s.errs[id] = err
+	s.mts[id] = mt
+}
+
+func (s *testSource) ModTimes() map[string]time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := make(map[string]time.Time, len(s.mts))
+	for id, mt := range s.mts {
+		m[id] = mt
+	}
+	return m
+}
+
+func (s *testSource) Info(id string) (source.ImageInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ii, ok := s.infos[id]
+	if !ok {
+		return source.ImageInfo{}, os.ErrNotExist
+	}
+	return ii, s.errs[id]
+}
+
+func (s *testSource) Open(id string) (io.ReadCloser, error) {
+	return nil, os.ErrNotExist
+}
+
+func (s *testSource) Close() error { return nil }
+
+func newTestDB(t *testing.T) *leveldb.DB {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestCache(t *testing.T, src source.ImageSource, db *leveldb.DB, opt ...Option) *ImageCache {
+	ic, err := newCache(src, db, opt...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ic
+}
+
+// imageKey returns the key of the image with srcid in ic.
+func imageKey(t *testing.T, ic *ImageCache, srcid string) string {
+	ic.mtx.RLock()
+	defer ic.mtx.RUnlock()
+	for key, id := range ic.keysrcid {
+		if id == srcid {
+			return key
+		}
+	}
+	t.Fatalf("%s has no key", srcid)
+	return ""
+}
+
+var testTime = time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestKeyAliases(t *testing.T) {
+	db := newTestDB(t)
+	ts := newTestSource()
+	ts.set("img/1", source.ImageInfo{Lat: 1, Long: 2}, testTime)
+
+	// ids without source labels, as before labels were added
+	ic := newTestCache(t, ts, db)
+	key := imageKey(t, ic, "img/1")
+	if _, err := ic.SetLocation(key, 3, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, label := range []string{"a", "b"} {
+		m := source.NewMulti()
+		if err := m.Add(label, ts); err != nil {
+			t.Fatal(err)
+		}
+		ic := newTestCache(t, m, db)
+		if k := imageKey(t, ic, label+":img/1"); k != key {
+			t.Errorf("label %s: key is %s, want %s", label, k, key)
+		}
+		ii := ic.Images()
+		if len(ii) != 1 || ii[0].Lat != 3 || ii[0].Long != 4 {
+			t.Errorf("label %s: location edit lost: %+v", label, ii)
+		}
+		ce, err := ic.cacheEntry(key)
+		if err != nil || ce.SrcId != label+":img/1" {
+			t.Errorf("label %s: cache entry %+v, %v", label, ce, err)
+		}
+	}
+
+	// an alias used by another image is not shared
+	m := source.NewMulti()
+	for _, label := range []string{"a", "b"} {
+		if err := m.Add(label, ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ic = newTestCache(t, m, db)
+	if ka, kb := imageKey(t, ic, "a:img/1"), imageKey(t, ic, "b:img/1"); ka == kb {
+		t.Errorf("images of two sources share key %s", ka)
+	}
+}
+
+func TestVideoPlaceholder(t *testing.T) {
+	tests := []struct {
+		w, h, size int
+		pw, ph     int
+	}{
+		{3840, 2160, 100, 100, 56},
+		{1080, 1920, 20, 11, 20},
+		{0, 0, 100, 100, 56},
+		{10000, 10, 20, 20, 1},
+	}
+	for _, tt := range tests {
+		b := videoPlaceholder(tt.w, tt.h, tt.size).Bounds()
+		if b.Dx() != tt.pw || b.Dy() != tt.ph {
+			t.Errorf("placeholder of %dx%d for size %d is %dx%d, want %dx%d",
+				tt.w, tt.h, tt.size, b.Dx(), b.Dy(), tt.pw, tt.ph)
+		}
+	}
+}
+
+// timeLocator locates images taken at t.
+type timeLocator struct {
+	t time.Time
+}
+
+func (l timeLocator) Locate(t time.Time) (source.Fix, bool) {
+	return source.Fix{Lat: 1, Long: 2, Source: "test"}, t.Equal(l.t)
+}
+
+func TestCameraZone(t *testing.T) {
+	ts := newTestSource()
+	wall := time.Date(2016, 5, 1, 12, 0, 0, 0, time.Local)
+	noloc := source.NoLoc(errors.New("no location"))
+	ts.setErr("local", source.ImageInfo{CreateTime: wall, TimeSource: source.TimeLocal}, noloc, testTime)
+	ts.setErr("utc", source.ImageInfo{CreateTime: testTime, TimeSource: source.TimeOffset}, noloc, testTime)
+
+	// local times in Japan, and an UTC time at the track point
+	zone := time.FixedZone("JST", 9*3600)
+	l := timeLocator{time.Date(2016, 5, 1, 3, 0, 0, 0, time.UTC)}
+	ic := newTestCache(t, ts, newTestDB(t), Locator(l), CameraZone(zone))
+	if _, _, ok := imageLoc(ic, imageKey(t, ic, "local")); !ok {
+		t.Error("local time not located in camera zone")
+	}
+
+	l = timeLocator{testTime}
+	ic = newTestCache(t, ts, newTestDB(t), Locator(l), CameraZone(zone))
+	if _, _, ok := imageLoc(ic, imageKey(t, ic, "utc")); !ok {
+		t.Error("time with offset not located")
+	}
+}
+
+// openSource is a testSource opening images
+// and thumbnails with open and thumb.
+type openSource struct {
+	*testSource
+	open  func(id string) (io.ReadCloser, error)
+	thumb func(id string, size int) (io.ReadCloser, error)
+}
+
+func (s *openSource) Open(id string) (io.ReadCloser, error) {
+	return s.open(id)
+}
+
+func (s *openSource) Thumbnail(ctx context.Context, id string, size int) (io.ReadCloser, error) {
+	return s.thumb(id, size)
+}
+
+// pngReader returns a w×h PNG image.
+func pngReader(t *testing.T, w, h int) io.ReadCloser {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return ioutil.NopCloser(buf)
+}
+
+func TestSourceThumbnail(t *testing.T) {
+	ts := newTestSource()
+	ts.set("img/1", source.ImageInfo{Lat: 1, Long: 2}, testTime)
+	ts.set("img/2", source.ImageInfo{Lat: 1, Long: 2}, testTime)
+
+	var opened, thumbed []string
+	src := &openSource{
+		testSource: ts,
+		open: func(id string) (io.ReadCloser, error) {
+			opened = append(opened, id)
+			return pngReader(t, 64, 48), nil
+		},
+		thumb: func(id string, size int) (io.ReadCloser, error) {
+			thumbed = append(thumbed, id)
+			if id == "img/2" {
+				return nil, source.ErrNotSupported
+			}
+			return pngReader(t, size, size*3/4), nil
+		},
+	}
+	ic := newTestCache(t, src, newTestDB(t))
+
+	tests := []struct {
+		id     string
+		w, h   int
+		opened bool
+	}{
+		{"img/1", 20, 15, false}, // thumbnail used
+		{"img/2", 64, 48, true},  // ErrNotSupported falls back to the image
+	}
+	for _, tt := range tests {
+		opened, thumbed = nil, nil
+		im, _, err := ic.loadSourceImage(context.Background(), imageKey(t, ic, tt.id), 20)
+		if err != nil {
+			t.Errorf("%s: %v", tt.id, err)
+			continue
+		}
+		if b := im.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("%s: loaded %dx%d image, want %dx%d", tt.id, b.Dx(), b.Dy(), tt.w, tt.h)
+		}
+		if len(thumbed) != 1 {
+			t.Errorf("%s: thumbnail requested %d times, want once", tt.id, len(thumbed))
+		}
+		if (len(opened) != 0) != tt.opened {
+			t.Errorf("%s: image opened %d times, want opened=%v", tt.id, len(opened), tt.opened)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package imagecache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/tajtiattila/photomap/source"
+)
+
+// LocSourceEdit is the ImageInfo.LocSource of images
+// with locations set using SetLocation.
+const LocSourceEdit = "edit"
+
+var (
+	ErrNotFound  = errors.New("imagecache: image not found")
+	ErrInvalid   = errors.New("imagecache: invalid image")
+	ErrNoHistory = errors.New("imagecache: no location edit to undo")
+)
+
+// locEdit is a location override set by the user.
+type locEdit struct {
+	Lat  float64
+	Long float64
+
+	Time time.Time // time of edit
+
+	// Prev is the location before the edit, or nil if there was none.
+	// It is written back to the source when the edit is undone, unless
+	// the edit is the first one. Then the location written is cleared,
+	// so that the source has its original location again.
+	Prev *source.Loc `json:",omitempty"`
+}
+
+// SetLocation overrides the location of the image with key. The image
+// source is left untouched, and earlier edits are kept so that they can
+// be reverted using UndoLocation.
+func (ic *ImageCache) SetLocation(key string, lat, long float64) (ImageInfo, error) {
+	return ic.editLocation(key, func(h []locEdit, cur *source.Loc) ([]locEdit, source.Edit, error) {
+		h = append(h, locEdit{Lat: lat, Long: long, Time: time.Now(), Prev: cur})
+		return h, source.Edit{Loc: &source.Loc{Lat: lat, Long: long}}, nil
+	})
+}
+
+// UndoLocation reverts the last location edit of the image with key.
+// The ImageInfo returned has a zero Lat and Long if the image no longer
+// has a location.
+func (ic *ImageCache) UndoLocation(key string) (ImageInfo, error) {
+	return ic.editLocation(key, func(h []locEdit, cur *source.Loc) ([]locEdit, source.Edit, error) {
+		n := len(h)
+		if n == 0 {
+			return nil, source.Edit{}, ErrNoHistory
+		}
+		if n == 1 {
+			return nil, source.Edit{ClearLoc: true}, nil
+		}
+		prev := h[n-1].Prev
+		return h[:n-1], source.Edit{Loc: prev, ClearLoc: prev == nil}, nil
+	})
+}
+
+// editLocation updates the location history of key using f. The function f
+// receives the history and the current location, and returns the new history
+// along with the edit to write back to the source.
+func (ic *ImageCache) editLocation(key string,
+	f func(h []locEdit, cur *source.Loc) ([]locEdit, source.Edit, error)) (ImageInfo, error) {
+
+	ic.editMtx.Lock()
+	defer ic.editMtx.Unlock()
+
+	srcid := ic.srcId(key)
+	if srcid == "" {
+		return ImageInfo{}, ErrNotFound
+	}
+	ce, err := ic.cacheEntry(key)
+	if err != nil {
+		return ImageInfo{}, err
+	}
+	if ce.IsErr && !ce.NoLoc {
+		return ImageInfo{}, ErrInvalid
+	}
+
+	h, err := ic.locHistory(key)
+	if err != nil {
+		return ImageInfo{}, err
+	}
+	var cur *source.Loc
+	if n := len(h); n != 0 {
+		cur = &source.Loc{Lat: h[n-1].Lat, Long: h[n-1].Long}
+	} else if !ce.IsErr {
+		cur = &source.Loc{Lat: ce.Lat, Long: ce.Long}
+	}
+	h, e, err := f(h, cur)
+	if err != nil {
+		return ImageInfo{}, err
+	}
+
+	if w, ok := ic.src.(source.Writer); ok && ic.writeBack {
+		mt, err := w.WriteInfo(srcid, e)
+		switch {
+		case err == source.ErrNotSupported:
+			// keep edit in cache only
+		case err != nil:
+			return ImageInfo{}, err
+		default:
+			// refresh cache entry with new data from the source
+			if ce, err = ic.loadFreshCacheEntry(context.Background(), key, srcid, mt); err != nil {
+				return ImageInfo{}, err
+			}
+		}
+	}
+
+	if err := ic.putLocHistory(key, h); err != nil {
+		return ImageInfo{}, err
+	}
+
+	ii, ok := ic.imageInfo(ce, h)
+	if !ok {
+		ii = ce.ImageInfo
+	}
+	ic.updateImage(key, ii, ok)
+	return ii, nil
+}
+
+// updateImage sets the image for key in ic.images,
+// or removes it if ok is false.
+func (ic *ImageCache) updateImage(key string, ii ImageInfo, ok bool) {
+	ic.mtx.Lock()
+	defer ic.mtx.Unlock()
+
+	idx, has := ic.imageidx[key]
+	switch {
+	case ok && has:
+		images := append([]ImageInfo(nil), ic.images...)
+		images[idx] = ii
+		ic.images = images
+	case ok:
+		ic.imageidx[key] = len(ic.images)
+		ic.images = append(ic.images[:len(ic.images):len(ic.images)], ii)
+	case has:
+		images := make([]ImageInfo, 0, len(ic.images)-1)
+		images = append(images, ic.images[:idx]...)
+		images = append(images, ic.images[idx+1:]...)
+		ic.images = images
+		delete(ic.imageidx, key)
+		for i := idx; i < len(images); i++ {
+			ic.imageidx[images[i].Id] = i
+		}
+	}
+}
+
+// cacheEntry returns the cached entry for key.
+func (ic *ImageCache) cacheEntry(key string) (cacheEntry, error) {
+	data, err := ic.db.Get([]byte(imageInfoPfx+key), nil)
+	if err == leveldb.ErrNotFound {
+		return cacheEntry{}, ErrNotFound
+	}
+	if err != nil {
+		return cacheEntry{}, err
+	}
+	var ce cacheEntry
+	err = json.Unmarshal(data, &ce)
+	return ce, err
+}
+
+// locHistory returns the location edits of key, the last one being current.
+func (ic *ImageCache) locHistory(key string) ([]locEdit, error) {
+	data, err := ic.db.Get([]byte(locationPfx+key), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var h []locEdit
+	err = json.Unmarshal(data, &h)
+	return h, err
+}
+
+func (ic *ImageCache) putLocHistory(key string, h []locEdit) error {
+	k := []byte(locationPfx + key)
+	if len(h) == 0 {
+		return ic.db.Delete(k, nil)
+	}
+	data, err := json.Marshal(h)
+	if err != nil {
+		panic("can't marshal location history")
+	}
+	return ic.db.Put(k, data, nil)
+}
@@ -0,0 +1,137 @@
+package imagecache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+// imageLoc returns the location of key in ic.Images,
+// and reports whether key was found.
+func imageLoc(ic *ImageCache, key string) (lat, long float64, ok bool) {
+	for _, ii := range ic.Images() {
+		if ii.Id == key {
+			return ii.Lat, ii.Long, true
+		}
+	}
+	return 0, 0, false
+}
+
+func TestLocationEdit(t *testing.T) {
+	ts := newTestSource()
+	ts.set("gps", source.ImageInfo{Lat: 1, Long: 2}, testTime)
+	ts.setErr("nogps", source.ImageInfo{}, source.NoLoc(errors.New("no location")), testTime)
+	ts.setErr("bad", source.ImageInfo{}, errors.New("invalid image"), testTime)
+
+	db := newTestDB(t)
+	ic := newTestCache(t, ts, db)
+	gps, nogps := imageKey(t, ic, "gps"), imageKey(t, ic, "nogps")
+
+	if _, _, ok := imageLoc(ic, nogps); ok {
+		t.Fatal("image without location shown")
+	}
+	if _, err := ic.UndoLocation(gps); err != ErrNoHistory {
+		t.Errorf("undo without edits: %v", err)
+	}
+	if _, err := ic.SetLocation(imageKey(t, ic, "bad"), 1, 1); err != ErrInvalid {
+		t.Errorf("edit of invalid image: %v", err)
+	}
+	if _, err := ic.SetLocation("nonexistent", 1, 1); err != ErrNotFound {
+		t.Errorf("edit of missing image: %v", err)
+	}
+
+	type step struct {
+		key       string
+		undo      bool
+		lat, long float64 // location afterwards
+		shown     bool
+	}
+	steps := []step{
+		{key: gps, lat: 3, long: 4, shown: true},
+		{key: gps, lat: 5, long: 6, shown: true},
+		{key: nogps, lat: 7, long: 8, shown: true},
+		{key: gps, undo: true, lat: 3, long: 4, shown: true},
+		{key: gps, undo: true, lat: 1, long: 2, shown: true},
+		{key: nogps, undo: true, shown: false},
+	}
+	for i, s := range steps {
+		var ii ImageInfo
+		var err error
+		if s.undo {
+			ii, err = ic.UndoLocation(s.key)
+		} else {
+			ii, err = ic.SetLocation(s.key, s.lat, s.long)
+		}
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if ii.Lat != s.lat || ii.Long != s.long {
+			t.Errorf("step %d: got location %v,%v, want %v,%v", i, ii.Lat, ii.Long, s.lat, s.long)
+		}
+		if s.shown && !s.undo && ii.LocSource != LocSourceEdit {
+			t.Errorf("step %d: location source is %q", i, ii.LocSource)
+		}
+		lat, long, ok := imageLoc(ic, s.key)
+		if ok != s.shown || lat != s.lat || long != s.long {
+			t.Errorf("step %d: images have %v,%v (%v), want %v,%v (%v)", i, lat, long, ok, s.lat, s.long, s.shown)
+		}
+	}
+
+	// edits are kept in the cache
+	if _, err := ic.SetLocation(gps, 9, 10); err != nil {
+		t.Fatal(err)
+	}
+	ic = newTestCache(t, ts, db)
+	if lat, long, _ := imageLoc(ic, gps); lat != 9 || long != 10 {
+		t.Errorf("reopened cache has %v,%v, want 9,10", lat, long)
+	}
+}
+
+// writeSource is a testSource that records
+// edits written back by the cache.
+type writeSource struct {
+	*testSource
+	edits []source.Edit
+}
+
+func (s *writeSource) WriteInfo(id string, e source.Edit) (time.Time, error) {
+	s.edits = append(s.edits, e)
+	return s.ModTimes()[id], nil
+}
+
+func TestLocationWriteBack(t *testing.T) {
+	ws := &writeSource{testSource: newTestSource()}
+	ws.set("gps", source.ImageInfo{Lat: 1, Long: 2}, testTime)
+	ic := newTestCache(t, ws, newTestDB(t), WriteBack())
+	key := imageKey(t, ic, "gps")
+
+	for _, l := range []source.Loc{{Lat: 3, Long: 4}, {Lat: 5, Long: 6}} {
+		if _, err := ic.SetLocation(key, l.Lat, l.Long); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := ic.UndoLocation(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []source.Edit{
+		{Loc: &source.Loc{Lat: 3, Long: 4}},
+		{Loc: &source.Loc{Lat: 5, Long: 6}},
+		{Loc: &source.Loc{Lat: 3, Long: 4}},
+		{ClearLoc: true}, // not the original location as an override
+	}
+	if len(ws.edits) != len(want) {
+		t.Fatalf("got %d edits, want %d", len(ws.edits), len(want))
+	}
+	for i, e := range ws.edits {
+		w := want[i]
+		if e.ClearLoc != w.ClearLoc || (e.Loc == nil) != (w.Loc == nil) ||
+			(e.Loc != nil && *e.Loc != *w.Loc) {
+			t.Errorf("edit %d is %+v, want %+v", i, e, w)
+		}
+	}
+}
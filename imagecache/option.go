@@ -0,0 +1,70 @@
+package imagecache
+
+import (
+	"time"
+
+	"github.com/tajtiattila/photomap/gazetteer"
+	"github.com/tajtiattila/photomap/source"
+)
+
+type Option interface {
+	set(ic *ImageCache)
+}
+
+// Locator sets the source.Locator used to find
+// the location of images having none.
+func Locator(l source.Locator) Option { return locatorOpt{l} }
+
+type locatorOpt struct {
+	l source.Locator
+}
+
+func (o locatorOpt) set(ic *ImageCache) {
+	ic.loc = o.l
+}
+
+// CameraZone sets the time zone of camera clocks. Creation times of
+// images without a time zone or UTC offset, such as EXIF times without
+// a location, are taken to be in loc when locating images using the
+// Locator. The default is time.Local.
+func CameraZone(loc *time.Location) Option { return cameraZoneOpt{loc} }
+
+type cameraZoneOpt struct {
+	loc *time.Location
+}
+
+func (o cameraZoneOpt) set(ic *ImageCache) {
+	ic.cameraZone = o.loc
+}
+
+// Gazetteer sets the gazetteer used to find
+// the nearest city, region and country of images.
+func Gazetteer(g *gazetteer.Gazetteer) Option { return gazetteerOpt{g} }
+
+type gazetteerOpt struct {
+	g *gazetteer.Gazetteer
+}
+
+func (o gazetteerOpt) set(ic *ImageCache) {
+	ic.gaz = o.g
+}
+
+// WriteBack enables writing location edits back to the
+// image source, if it implements source.Writer.
+func WriteBack() Option { return writeBackOpt{} }
+
+type writeBackOpt struct{}
+
+func (writeBackOpt) set(ic *ImageCache) {
+	ic.writeBack = true
+}
+
+// Watch enables updating the cache when images change,
+// if the image source implements source.Watcher.
+func Watch() Option { return watchOpt{} }
+
+type watchOpt struct{}
+
+func (watchOpt) set(ic *ImageCache) {
+	ic.watchSrc = true
+}
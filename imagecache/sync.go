@@ -1,25 +1,86 @@
 package imagecache
 
-import "go4.org/syncutil/singleflight"
+import (
+	"context"
+	"sync"
+)
 
+// parallelGroup runs functions for keys at most n at a time,
+// with concurrent calls for a key sharing a single run.
 type parallelGroup struct {
 	ch chan struct{}
-	g  singleflight.Group
+
+	mu sync.Mutex // protects m
+	m  map[string]*call
+}
+
+// call is a run of a function for a key.
+type call struct {
+	cancel  context.CancelFunc
+	waiters int // callers waiting for the result
+	done    chan struct{}
+
+	val interface{}
+	err error
 }
 
 func newParallelGroup(n int) *parallelGroup {
 	if n < 1 {
 		n = 1
 	}
-	return &parallelGroup{ch: make(chan struct{}, n)}
+	return &parallelGroup{ch: make(chan struct{}, n), m: make(map[string]*call)}
+}
+
+// Do runs fn for key unless it is already running, and returns its result.
+// The context passed to fn is cancelled when all callers waiting for
+// the result have returned because their ctx is done.
+func (p *parallelGroup) Do(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	p.mu.Lock()
+	c, ok := p.m[key]
+	if !ok {
+		cctx, cancel := context.WithCancel(context.Background())
+		c = &call{cancel: cancel, done: make(chan struct{})}
+		p.m[key] = c
+		go p.run(cctx, key, c, fn)
+	}
+	c.waiters++
+	p.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		p.mu.Lock()
+		if c.waiters--; c.waiters == 0 {
+			c.cancel()
+			if p.m[key] == c {
+				// later callers start a new run
+				delete(p.m, key)
+			}
+		}
+		p.mu.Unlock()
+		return nil, ctx.Err()
+	}
 }
 
-func (p *parallelGroup) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
-	return p.g.Do(key, func() (interface{}, error) {
-		p.ch <- struct{}{}
-		defer func() {
-			<-p.ch
-		}()
-		return fn()
-	})
+func (p *parallelGroup) run(ctx context.Context, key string, c *call, fn func(ctx context.Context) (interface{}, error)) {
+	defer func() {
+		c.cancel()
+		p.mu.Lock()
+		if p.m[key] == c {
+			delete(p.m, key)
+		}
+		p.mu.Unlock()
+		close(c.done)
+	}()
+	select {
+	case p.ch <- struct{}{}:
+	case <-ctx.Done():
+		c.err = ctx.Err()
+		return
+	}
+	defer func() {
+		<-p.ch
+	}()
+	c.val, c.err = fn(ctx)
 }
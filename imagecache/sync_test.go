@@ -0,0 +1,139 @@
+package imagecache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParallelGroupShared(t *testing.T) {
+	p := newParallelGroup(2)
+	var runs int32
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&runs, 1)
+		<-release
+		return "v", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := p.Do(context.Background(), "k", fn)
+			if v != "v" || err != nil {
+				t.Errorf("got %v, %v", v, err)
+			}
+		}()
+	}
+	waitWaiters(t, p, "k", 3)
+	close(release)
+	wg.Wait()
+	if runs != 1 {
+		t.Errorf("fn ran %d times, want 1", runs)
+	}
+}
+
+func TestParallelGroupCancel(t *testing.T) {
+	p := newParallelGroup(1)
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}
+
+	// the run continues while a caller waits for it
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() {
+		_, err := p.Do(ctx1, "k", fn)
+		errs <- err
+	}()
+	<-started
+	go func() {
+		_, err := p.Do(ctx2, "k", fn)
+		errs <- err
+	}()
+	waitWaiters(t, p, "k", 2)
+
+	cancel1()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("first caller got %v", err)
+	}
+	select {
+	case <-cancelled:
+		t.Fatal("run cancelled while a caller waits")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// and stops when no callers are left
+	cancel2()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("second caller got %v", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("run not cancelled")
+	}
+
+	// later callers start a new run
+	v, err := p.Do(context.Background(), "k", func(ctx context.Context) (interface{}, error) {
+		return "new", nil
+	})
+	if v != "new" || err != nil {
+		t.Errorf("new run got %v, %v", v, err)
+	}
+}
+
+func TestParallelGroupLimit(t *testing.T) {
+	const n = 2
+	p := newParallelGroup(n)
+	var running, max int32
+	fn := func(ctx context.Context) (interface{}, error) {
+		r := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if r <= m || atomic.CompareAndSwapInt32(&max, m, r) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil, nil
+	}
+	var wg sync.WaitGroup
+	for _, k := range []string{"a", "b", "c", "d", "e", "f"} {
+		wg.Add(1)
+		go func(k string) {
+			defer wg.Done()
+			p.Do(context.Background(), k, fn)
+		}(k)
+	}
+	wg.Wait()
+	if max > n {
+		t.Errorf("%d runs at a time, want at most %d", max, n)
+	}
+}
+
+// waitWaiters waits until the run for key has n waiters.
+func waitWaiters(t *testing.T, p *parallelGroup, key string, n int) {
+	for i := 0; i < 1000; i++ {
+		p.mu.Lock()
+		c := p.m[key]
+		ok := c != nil && c.waiters == n
+		p.mu.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%s has no %d waiters", key, n)
+}
@@ -89,6 +89,41 @@ func Frame(im image.Image, w int, col color.RGBA) image.Image {
 	return framed
 }
 
+// PlayBadge returns im with a play button drawn
+// over its center to mark videos.
+func PlayBadge(im image.Image) image.Image {
+	b := im.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), im, b.Min, draw.Src)
+
+	cx, cy := float64(b.Dx())/2, float64(b.Dy())/2
+	r := float64(b.Dx())
+	if dy := float64(b.Dy()); dy < r {
+		r = dy
+	}
+	r *= 0.35
+
+	disc := color.RGBA{0, 0, 0, 160}
+	white := color.RGBA{255, 255, 255, 255}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			px, py := float64(x)+0.5-cx, float64(y)+0.5-cy
+			if px*px+py*py > r*r {
+				continue
+			}
+			// triangle pointing right within the disc
+			tx, ty := px+r*0.2, py
+			if tx >= -r*0.4 && ty <= (r*0.5-tx)*0.6 && ty >= -(r*0.5-tx)*0.6 && tx <= r*0.5 {
+				dst.SetRGBA(x, y, white)
+			} else {
+				draw.Draw(dst, image.Rect(x, y, x+1, y+1),
+					image.NewUniform(disc), image.Point{}, draw.Over)
+			}
+		}
+	}
+	return dst
+}
+
 // Shadow can add shadow effect to images.
 type Shadow struct {
 	Color color.RGBA // shadow color
@@ -0,0 +1,115 @@
+package imagecache
+
+import (
+	"context"
+	"log"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+// OnChange sets f to be called after images has changed
+// because of changes in the image source.
+func (ic *ImageCache) OnChange(f func()) {
+	ic.mtx.Lock()
+	defer ic.mtx.Unlock()
+	ic.onChange = f
+}
+
+func (ic *ImageCache) startWatch() error {
+	w, ok := ic.src.(source.Watcher)
+	if !ok {
+		log.Println("image source can't be watched for changes")
+		return nil
+	}
+	ch, err := w.Watch()
+	if err == source.ErrNotSupported {
+		log.Println("image source can't be watched for changes")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ic.watchDone = make(chan struct{})
+	go ic.watch(ch)
+	return nil
+}
+
+// watch applies changes from ch until it is closed.
+func (ic *ImageCache) watch(ch <-chan source.Change) {
+	defer close(ic.watchDone)
+	changed := false
+	for c := range ch {
+		if err := ic.apply(c); err != nil {
+			log.Printf("update %q: %v", c.Id, err)
+		} else {
+			changed = true
+		}
+		if changed && len(ch) == 0 {
+			// notify only when no more changes are queued
+			ic.notify()
+			changed = false
+		}
+	}
+}
+
+func (ic *ImageCache) apply(c source.Change) error {
+	ic.editMtx.Lock()
+	defer ic.editMtx.Unlock()
+
+	key, err := ic.getKey(c.Id)
+	if err != nil {
+		return err
+	}
+	defer ic.dropPhotoIcon(key)
+
+	if c.Op == source.Removed {
+		ic.mtx.Lock()
+		delete(ic.keysrcid, key)
+		ic.mtx.Unlock()
+		ic.updateImage(key, ImageInfo{}, false)
+		return ic.deleteImage(key)
+	}
+
+	ic.mtx.Lock()
+	ic.keysrcid[key] = c.Id
+	ic.mtx.Unlock()
+
+	ce, err := ic.loadFreshCacheEntry(context.Background(), key, c.Id, c.ModTime)
+	if err != nil {
+		return err
+	}
+	h, err := ic.locHistory(key)
+	if err != nil {
+		return err
+	}
+	ii, ok := ic.imageInfo(ce, h)
+	ic.updateImage(key, ii, ok)
+	return nil
+}
+
+// deleteImage deletes the cached data of the removed image key.
+// Its key and location edits are kept, so that they are used again
+// if the image is restored.
+func (ic *ImageCache) deleteImage(key string) error {
+	for _, pfx := range []string{imageInfoPfx, photoIconPfx, thumbPfx} {
+		if err := ic.db.Delete([]byte(pfx+key), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ic *ImageCache) dropPhotoIcon(key string) {
+	ic.photoIconMtx.Lock()
+	delete(ic.photoIcon, key)
+	ic.photoIconMtx.Unlock()
+}
+
+func (ic *ImageCache) notify() {
+	ic.mtx.RLock()
+	f := ic.onChange
+	ic.mtx.RUnlock()
+	if f != nil {
+		f()
+	}
+}
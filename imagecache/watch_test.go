@@ -0,0 +1,72 @@
+package imagecache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/tajtiattila/photomap/source"
+)
+
+// watchSource is a testSource reporting changes sent on ch.
+type watchSource struct {
+	*testSource
+	ch chan source.Change
+}
+
+func (s *watchSource) Watch() (<-chan source.Change, error) {
+	return s.ch, nil
+}
+
+func TestWatch(t *testing.T) {
+	ws := &watchSource{testSource: newTestSource(), ch: make(chan source.Change)}
+	ws.set("a", source.ImageInfo{Lat: 1, Long: 2}, testTime)
+	ws.set("gone", source.ImageInfo{Lat: 3, Long: 4}, testTime)
+
+	db := newTestDB(t)
+	ic := newTestCache(t, ws, db, Watch())
+	defer ic.Close()
+	notified := make(chan struct{}, 10)
+	ic.OnChange(func() { notified <- struct{}{} })
+
+	gone := imageKey(t, ic, "gone")
+	if err := db.Put([]byte(thumbPfx+gone), []byte("thumb"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(c source.Change) {
+		ws.ch <- c
+		select {
+		case <-notified:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%+v not applied", c)
+		}
+	}
+
+	mt := testTime.Add(time.Hour)
+	ws.set("a", source.ImageInfo{Lat: 5, Long: 6}, mt)
+	send(source.Change{Op: source.Modified, Id: "a", ModTime: mt})
+	if lat, long, _ := imageLoc(ic, imageKey(t, ic, "a")); lat != 5 || long != 6 {
+		t.Errorf("modified image at %v,%v, want 5,6", lat, long)
+	}
+
+	ws.set("b", source.ImageInfo{Lat: 7, Long: 8}, testTime)
+	send(source.Change{Op: source.Added, Id: "b", ModTime: testTime})
+	if lat, long, _ := imageLoc(ic, imageKey(t, ic, "b")); lat != 7 || long != 8 {
+		t.Errorf("added image at %v,%v, want 7,8", lat, long)
+	}
+
+	send(source.Change{Op: source.Removed, Id: "gone"})
+	if _, _, ok := imageLoc(ic, gone); ok {
+		t.Error("removed image shown")
+	}
+	for _, pfx := range []string{imageInfoPfx, thumbPfx} {
+		if _, err := db.Get([]byte(pfx+gone), nil); err != leveldb.ErrNotFound {
+			t.Errorf("%s of removed image kept: %v", pfx, err)
+		}
+	}
+	if n := len(ic.Images()); n != 2 {
+		t.Errorf("got %d images, want 2", n)
+	}
+	close(ws.ch)
+}
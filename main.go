@@ -4,76 +4,143 @@ import (
 	"bytes"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"html/template"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/tajtiattila/photomap/gazetteer"
 	"github.com/tajtiattila/photomap/imagecache"
 	"github.com/tajtiattila/photomap/source"
+	_ "github.com/tajtiattila/photomap/source/archive"
 	_ "github.com/tajtiattila/photomap/source/camlistore"
-	_ "github.com/tajtiattila/photomap/source/filesystem"
+	fs "github.com/tajtiattila/photomap/source/filesystem"
+	"github.com/tajtiattila/photomap/source/gpx"
+	_ "github.com/tajtiattila/photomap/source/immich"
+	_ "github.com/tajtiattila/photomap/source/lightroom"
+	_ "github.com/tajtiattila/photomap/source/plugin"
+	_ "github.com/tajtiattila/photomap/source/s3"
+	_ "github.com/tajtiattila/photomap/source/takeout"
+	_ "github.com/tajtiattila/photomap/source/webdav"
 )
 
 func main() {
-	var addr, camsrc string
+	var addr, camsrc, gpxpaths, camzone, gazpath string
+	var srcspecs stringList
+	var gpxmaxgap time.Duration
+	var writeback, watch bool
 	flag.StringVar(&addr, "addr", ":6677", "listen address")
+	flag.Var(&srcspecs, "source", "add image source or locator `url` such as file:///photos?label=name, camli://host or gpx:///tracks (repeatable)")
 	flag.StringVar(&camsrc, "camli", "", "use camlistore server as source")
+	flag.StringVar(&gpxpaths, "gpx", "", "locate images without gps position using GPX file(s) or dir(s) in `path list`")
+	flag.DurationVar(&gpxmaxgap, "gpxmaxgap", gpx.DefaultMaxGap, "maximum time gap for locating images using GPX track points")
+	flag.StringVar(&camzone, "camerazone", "", "time `zone` of camera clocks such as Europe/Budapest or +02:00 for locating images, default is the local zone")
+	flag.StringVar(&gazpath, "gazetteer", "", "name places using GeoNames cities `file` such as cities15000.txt")
+	flag.BoolVar(&writeback, "writeback", false, "write location fixes back to the image source, eg. into XMP sidecars")
+	flag.BoolVar(&watch, "watch", false, "watch image source for changes")
+	var include, exclude string
+	exts := strings.Join(fs.DefaultExtensions, ",")
+	flag.StringVar(&include, "include", "", "use only files matching comma separated `patterns` in .gitignore syntax")
+	flag.StringVar(&exclude, "exclude", "", "skip files and dirs matching comma separated `patterns` in .gitignore syntax")
+	flag.StringVar(&exts, "ext", exts, "comma separated image file `extensions`, or empty for all files")
+	flag.BoolVar(&fs.DefaultOptions.Hidden, "hidden", false, "use hidden and system files and dirs")
+	flag.BoolVar(&fs.DefaultOptions.FollowSymlinks, "follow", false, "follow symlinks to directories")
 	flag.Parse()
 
+	fs.DefaultOptions.Include = splitList(include)
+	fs.DefaultOptions.Exclude = splitList(exclude)
+	fs.DefaultOptions.Extensions = splitList(exts)
+
 	gmapsapikey := os.Getenv("GOOGLEMAPS_APIKEY")
 	if gmapsapikey == "" {
 		log.Fatal("GOOGLEMAPS_APIKEY environment variable unset")
 	}
 
-	var is source.ImageSource
-	var err error
+	ms := source.NewMulti()
+	for _, spec := range srcspecs {
+		if err := ms.OpenURL(spec); err != nil {
+			log.Fatal(err)
+		}
+	}
 	if camsrc != "" {
-		is, err = source.Open("camlistore", camsrc)
+		is, err := source.Open("camlistore", camsrc)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := ms.Add("camli", is); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, p := range flag.Args() {
+		if err := ms.OpenURL(fileURL(p)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(ms.Labels()) == 0 {
+		log.Fatal("no image source specified")
+	}
+
+	var icopt []imagecache.Option
+	locs := ms.Locators()
+	if gpxpaths != "" {
+		tl, err := gpx.Load(filepath.SplitList(gpxpaths)...)
 		if err != nil {
 			log.Fatal(err)
 		}
-	} else {
-		if flag.NArg() == 0 {
-			log.Fatal("need path argument(s)")
+		tl.MaxGap = gpxmaxgap
+		log.Printf("Loaded %d track points\n", tl.Len())
+		locs = append(locs, tl)
+	}
+	if len(locs) != 0 {
+		icopt = append(icopt, imagecache.Locator(locs))
+	}
+	if camzone != "" {
+		loc, err := parseZone(camzone)
+		if err != nil {
+			log.Fatal(err)
 		}
+		icopt = append(icopt, imagecache.CameraZone(loc))
+	}
+
+	var gaz *gazetteer.Gazetteer
+	if gazpath != "" {
 		var err error
-		is, err = source.Open("filesystem", strings.Join(flag.Args(), string(os.PathSeparator)))
+		gaz, err = gazetteer.Open(gazpath)
 		if err != nil {
 			log.Fatal(err)
 		}
+		log.Printf("Loaded %d places\n", gaz.Len())
+		icopt = append(icopt, imagecache.Gazetteer(gaz))
 	}
 
-	if is == nil {
-		log.Fatal("no image source specified")
+	if writeback {
+		icopt = append(icopt, imagecache.WriteBack())
+	}
+	if watch {
+		icopt = append(icopt, imagecache.Watch())
 	}
 
 	log.Println("Caching new images")
-	ic, err := imagecache.New(is)
+	ic, err := imagecache.New(ms, icopt...)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer ic.Close()
 
-	if len(ic.Images()) == 0 {
+	if len(ic.Images()) == 0 && !watch {
 		log.Fatal("no geotagged images")
 	}
 	log.Printf("Found %d geotagged images\n", len(ic.Images()))
 
-	tm := NewTileMap(ic)
-
-	type img struct {
-		Lat  float64 `json:"lat"`
-		Long float64 `json:"lng"`
-	}
-	vim := make([]img, 0, len(ic.Images()))
-	for _, ii := range ic.Images() {
-		vim = append(vim, img{ii.Lat, ii.Long})
-	}
+	tm := NewTileMap(ic, gaz)
+	ic.OnChange(tm.Refresh)
 
 	ist := time.Now()
 
@@ -108,20 +175,83 @@ func main() {
 		http.ServeContent(w, r, "bounds.json", ist, bytes.NewReader(data))
 	})
 	http.HandleFunc("/photos.json", func(w http.ResponseWriter, r *http.Request) {
+		type img struct {
+			Lat  float64 `json:"lat"`
+			Long float64 `json:"lng"`
+			Src  string  `json:"src,omitempty"`
+		}
+		mt := tm.ModTime()
+		images := ic.Images()
+		vim := make([]img, 0, len(images))
+		for _, ii := range images {
+			vim = append(vim, img{ii.Lat, ii.Long, ii.Source})
+		}
 		buf := new(bytes.Buffer)
 		err := json.NewEncoder(buf).Encode(vim)
 		if err != nil {
 			log.Println(err)
 		}
-		http.ServeContent(w, r, "photos.json", ist, bytes.NewReader(buf.Bytes()))
+		http.ServeContent(w, r, "photos.json", mt, bytes.NewReader(buf.Bytes()))
 	})
 
-	handleWithPrefix("/tile/spot/", NewTileHandler(tm.SpotsTile))
-	handleWithPrefix("/tile/photo/", NewTileHandler(tm.PhotoTile))
+	http.HandleFunc("/sources.json", func(w http.ResponseWriter, r *http.Request) {
+		type src struct {
+			Label string `json:"label"`
+			Count int    `json:"count"`
+		}
+		count := make(map[string]int)
+		for _, ii := range ic.Images() {
+			count[ii.Source]++
+		}
+		var v []src
+		for _, l := range ms.Labels() {
+			v = append(v, src{l, count[l]})
+		}
+		buf := new(bytes.Buffer)
+		if err := json.NewEncoder(buf).Encode(v); err != nil {
+			log.Println(err)
+		}
+		http.ServeContent(w, r, "sources.json", tm.ModTime(), bytes.NewReader(buf.Bytes()))
+	})
+
+	http.HandleFunc("/collections.json", func(w http.ResponseWriter, r *http.Request) {
+		type coll struct {
+			Name  string `json:"name"`
+			Count int    `json:"count"`
+		}
+		count := make(map[string]int)
+		for _, ii := range ic.Images() {
+			for _, c := range ii.Collections {
+				count[c]++
+			}
+		}
+		names := make([]string, 0, len(count))
+		for c := range count {
+			names = append(names, c)
+		}
+		sort.Strings(names)
+		v := make([]coll, 0, len(names))
+		for _, c := range names {
+			v = append(v, coll{c, count[c]})
+		}
+		buf := new(bytes.Buffer)
+		if err := json.NewEncoder(buf).Encode(v); err != nil {
+			log.Println(err)
+		}
+		http.ServeContent(w, r, "collections.json", tm.ModTime(), bytes.NewReader(buf.Bytes()))
+	})
+
+	handleWithPrefix("/tile/spot/", NewTileHandler(tm.SpotsTile, tm.ModTime))
+	handleWithPrefix("/tile/photo/", NewTileHandler(tm.PhotoTile, tm.ModTime))
+	handleWithPrefix("/tile/heading/", NewTileHandler(tm.HeadingTile, tm.ModTime))
 	http.Handle("/viewport.json", NewViewportPlaceHandler(tm))
 	http.Handle("/gallery.json", NewGalleryHandler(tm))
+	http.Handle("/search/places", NewPlaceSearchHandler(tm))
+	http.Handle("/location", NewLocationHandler(ic, tm))
 
 	handleWithPrefix("/thumb/", NewThumbnailHandler(ic))
+	handleWithPrefix("/photo/", NewPhotoHandler(ic, tm))
+	handleWithPrefix("/media/", NewMediaHandler(ic))
 
 	log.Println("Listening on", addr)
 	log.Fatal(http.ListenAndServe(addr, nil))
@@ -177,3 +307,54 @@ func handleWithPrefix(pfx string, h http.Handler) {
 	}
 	http.Handle(pfx, http.StripPrefix(pfx[:n], h))
 }
+
+// splitList splits the comma separated list s,
+// and returns nil if s is empty.
+func splitList(s string) []string {
+	var v []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			v = append(v, e)
+		}
+	}
+	return v
+}
+
+// parseZone parses a time zone name such as Europe/Budapest,
+// or a UTC offset such as +02:00 or -0530.
+func parseZone(s string) (*time.Location, error) {
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		for _, layout := range []string{"-07:00", "-0700", "-07"} {
+			if t, err := time.Parse(layout, s); err == nil {
+				_, off := t.Zone()
+				return time.FixedZone(s, off), nil
+			}
+		}
+		return nil, fmt.Errorf("invalid UTC offset %q", s)
+	}
+	return time.LoadLocation(s)
+}
+
+// stringList is a flag.Value for repeatable string flags.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, " ") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// fileURL returns the file URL of the local path p.
+func fileURL(p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		abs = p
+	}
+	abs = filepath.ToSlash(abs)
+	if !strings.HasPrefix(abs, "/") {
+		abs = "/" + abs // windows drive
+	}
+	u := url.URL{Scheme: "file", Path: abs}
+	return u.String()
+}
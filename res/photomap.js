@@ -5,11 +5,113 @@ function lat2merc(lat) {
     return 180.0 / Math.PI * Math.log(Math.tan(Math.PI/4 + lat * Math.PI/180.0/2.0));
 }
 
-function PhotoMapControl(controlDiv, map, spotsOverlay, photosOverlay) {
+function placeName(p) {
+    if (!p || !p.name) {
+      return '';
+    }
+    return [p.name, p.region, p.country].filter(function(s, i, a) {
+      return s && a.indexOf(s) == i;
+    }).join(', ');
+}
+
+// PlaceSearchControl counts photos around places
+// with the query parameters returned by params.
+function PlaceSearchControl(controlDiv, map, params) {
+  controlDiv.className = "photomapcontrol";
+
+  var searchUI = document.createElement('div');
+  searchUI.className = "photomapui placesearch";
+  controlDiv.appendChild(searchUI);
+
+  var input = document.createElement('input');
+  input.type = 'search';
+  input.placeholder = 'Go to place';
+  searchUI.appendChild(input);
+
+  var results = document.createElement('div');
+  searchUI.appendChild(results);
+
+  var places = [];
+  function showResults(v) {
+    places = v;
+    results.innerHTML = '';
+    for (var i = 0; i < places.length; i++) {
+      var p = places[i];
+      var d = document.createElement('div');
+      d.className = p.photos ? "placeresult" : "placeresult nophotos";
+      d.textContent = placeName(p) + (p.photos ? ' (' + p.photos + ')' : '');
+      d.setAttribute('data-idx', i);
+      results.appendChild(d);
+    }
+  }
+  function goTo(p) {
+    map.fitBounds(p.bounds);
+    showResults([]);
+  }
+  results.addEventListener('click', function(e) {
+    var i = e.target.getAttribute('data-idx');
+    if (i !== null) {
+      goTo(places[i]);
+    }
+  });
+  input.addEventListener('keydown', function(e) {
+    if (e.keyCode == 13 && places.length != 0) {
+      goTo(places[0]);
+    }
+  });
+  input.addEventListener('input', function() {
+    var q = input.value.trim();
+    if (!q) {
+      showResults([]);
+      return;
+    }
+    getJSON('search/places?q=' + encodeURIComponent(q) + params(), function(v) {
+      if (input.value.trim() == q) {
+        showResults(v || []);
+      }
+    });
+  });
+}
+
+// CollectionControl lets the user select a collection of photos
+// to show, and calls onChange with its name, or '' for all photos.
+// It is hidden if the photos are not in collections.
+function CollectionControl(controlDiv, onChange) {
+  controlDiv.className = "photomapcontrol";
+  controlDiv.style.display = "none";
+
+  var sel = document.createElement('select');
+  sel.className = "photomapui collectionselect";
+  sel.title = 'Show the photos of a collection';
+  controlDiv.appendChild(sel);
+
+  function addOption(value, text) {
+    var o = document.createElement('option');
+    o.value = value;
+    o.textContent = text;
+    sel.appendChild(o);
+  }
+  getJSON('collections.json', function(v) {
+    if (!v || v.length == 0) {
+      return;
+    }
+    addOption('', 'All photos');
+    for (var i = 0; i < v.length; i++) {
+      addOption(v[i].name, v[i].name + ' (' + v[i].count + ')');
+    }
+    controlDiv.style.display = "block";
+  });
+  sel.addEventListener('change', function() {
+    onChange(sel.value);
+  });
+}
+
+function PhotoMapControl(controlDiv, map, spotsOverlay, photosOverlay, headingsOverlay) {
   var control = this;
 
   var photosShown = true;
   var spotsShown = true;
+  var headingsShown = false;
 
   controlDiv.className = "photomapcontrol";
 
@@ -28,6 +130,19 @@ function PhotoMapControl(controlDiv, map, spotsOverlay, photosOverlay) {
   spotsText.innerHTML = 'Spots';
   spotsUI.appendChild(spotsText);
 
+  var headingsUI = document.createElement('div');
+  headingsUI.className = "photomapui";
+  headingsUI.id = 'headingsUI';
+  headingsUI.title = 'Click to toggle camera headings';
+  controlDiv.appendChild(headingsUI);
+
+  var headingsText = document.createElement('div');
+  headingsText.style.fontWeight = "400";
+  headingsText.className = "photomapuitext";
+  headingsText.id = 'headingsText';
+  headingsText.innerHTML = 'Headings';
+  headingsUI.appendChild(headingsText);
+
   var photosUI = document.createElement('div');
   photosUI.style.borderTopRightRadius = "3px";
   photosUI.style.borderBottomRightRadius = "3px";
@@ -56,6 +171,25 @@ function PhotoMapControl(controlDiv, map, spotsOverlay, photosOverlay) {
     photosText.style.fontWeight = photosShown ? "500" : "400";
   });
 
+  headingsUI.addEventListener('click', function() {
+    if (headingsShown) {
+      var i = map.overlayMapTypes.indexOf(headingsOverlay);
+      if (i != -1) {
+        map.overlayMapTypes.removeAt(i);
+      }
+    } else {
+      // insert before photosOverlay, if shown
+      var i = map.overlayMapTypes.indexOf(photosOverlay);
+      if (i != -1) {
+        map.overlayMapTypes.insertAt(i, headingsOverlay);
+      } else {
+        map.overlayMapTypes.push(headingsOverlay);
+      }
+    }
+    headingsShown = !headingsShown;
+    headingsText.style.fontWeight = headingsShown ? "500" : "400";
+  });
+
   spotsUI.addEventListener('click', function() {
     if (spotsShown) {
       var i = map.overlayMapTypes.indexOf(spotsOverlay);
@@ -94,6 +228,13 @@ function initMap(mapElement, bounds) {
 
   var bounds, lastBounds;
   var markers = [];
+  var galleryLoc = null; // lat/lng of gallery shown
+  var selected = null; // id of photo selected in gallery
+  var tileVersion = 0;
+  var collection = ''; // collection shown, or '' for all photos
+  function collectionParam() {
+    return collection ? '&collection=' + encodeURIComponent(collection) : '';
+  }
   function clearMarkers(latLng) {
     for (var i = 0; i < markers.length; i++) {
       markers[i].setMap(null);
@@ -104,18 +245,123 @@ function initMap(mapElement, bounds) {
     var mapElem = document.getElementById('map');
     var sidebar = document.getElementById('sidebar');
     sidebar.style.visibility = "hidden";
+    galleryLoc = null;
+    selectPhoto(null);
     mapElem.style.left = "0%";
     mapElem.style.width = "100%";
     google.maps.event.trigger(map, 'resize');
   }
+  function selectPhoto(id) {
+    selected = id;
+    var thumbs = document.getElementById('thumbs').children;
+    for (var i = 0; i < thumbs.length; i++) {
+      var t = thumbs[i];
+      t.className = t.getAttribute('data-id') == id ? "selected" : "";
+    }
+    var ctl = document.getElementById('editctl');
+    ctl.style.display = id ? "block" : "none";
+    showPhotoInfo(id);
+  }
+  function showPhotoInfo(id) {
+    var info = document.getElementById('photoinfo');
+    if (!id) {
+      info.style.display = "none";
+      return;
+    }
+    getJSON('photo/' + encodeURIComponent(id) + '.json', function(p) {
+      if (id != selected) {
+        return;
+      }
+      var lines = [p.localTime.replace('T', ' ')];
+      if (p.timesrc != 'local' && p.timesrc != 'modtime') {
+        lines.push(p.utc.replace('T', ' ').replace('Z', ' UTC'));
+      }
+      var camera = [p.make, p.model].filter(Boolean).join(' ');
+      if (camera) {
+        lines.push(camera);
+      }
+      if (p.lens) {
+        lines.push(p.lens);
+      }
+      var exp = [];
+      if (p.focalLength) {
+        exp.push(+p.focalLength.toFixed(1) + ' mm');
+      }
+      if (p.aperture) {
+        exp.push('f/' + +p.aperture.toFixed(1));
+      }
+      if (p.exposure) {
+        exp.push(p.exposure < 1 ? '1/' + Math.round(1/p.exposure) + ' s' : +p.exposure.toFixed(1) + ' s');
+      }
+      if (p.iso) {
+        exp.push('ISO ' + p.iso);
+      }
+      if (exp.length) {
+        lines.push(exp.join(' '));
+      }
+      var pos = [p.lat.toFixed(5) + ', ' + p.long.toFixed(5)];
+      if (p.alt !== undefined) {
+        pos.push(Math.round(p.alt) + ' m');
+      }
+      if (p.dir !== undefined) {
+        pos.push(Math.round(p.dir) + '\u00b0' + (p.magnetic ? ' magnetic' : ''));
+      }
+      lines.push(pos.join(' '));
+      var place = placeName({name: p.city, region: p.region, country: p.country});
+      if (place) {
+        lines.push(place);
+      }
+      if (p.src) {
+        lines.push(p.src);
+      }
+      if (p.collections) {
+        lines.push(p.collections.join(', '));
+      }
+      if (p.keywords) {
+        lines.push(p.keywords.join(', '));
+      }
+      info.innerHTML = lines.map(function(s) {
+        var d = document.createElement('div');
+        d.textContent = s;
+        return d.outerHTML;
+      }).join('');
+      info.style.display = "block";
+    });
+  }
+  function setLocation(req) {
+    postJSON('location', req, function(ii) {
+      refreshMap();
+    });
+  }
+  function refreshMap() {
+    tileVersion++;
+    var ov = [spotOverlay, headingOverlay, photoOverlay];
+    for (var j = 0; j < ov.length; j++) {
+      var i = map.overlayMapTypes.indexOf(ov[j]);
+      if (i != -1) {
+        map.overlayMapTypes.removeAt(i);
+        map.overlayMapTypes.insertAt(i, ov[j]);
+      }
+    }
+    lastBounds = null;
+    google.maps.event.trigger(map, 'idle');
+    if (galleryLoc) {
+      showGallery(galleryLoc.lat, galleryLoc.lng);
+    }
+  }
   function showGallery(lat, lng) {
+    galleryLoc = {lat: lat, lng: lng};
     var u = ['gallery.json?la=', lat, '&lo=', lng,
-      '&zoom=', map.getZoom()].join('');
-    getJSON(u, function(gal) {
+      '&zoom=', map.getZoom(), collectionParam()].join('');
+    getJSON(u, function(res) {
+      var gal = res && res.ids;
       if (!gal || gal.length == 0) {
         hideGallery();
         return;
       }
+      var placeElem = document.getElementById('place');
+      placeElem.textContent = placeName(res.place);
+      placeElem.style.display = res.place ? "block" : "none";
       var mapElem = document.getElementById('map');
       var sidebar = document.getElementById('sidebar');
       sidebar.style.visibility = "visible";
@@ -124,12 +370,30 @@ function initMap(mapElement, bounds) {
       google.maps.event.trigger(map, 'resize');
       var thumbs = [];
       for (var i = 0; i < gal.length; i++) {
-        thumbs.push(['<img src="thumb/' + gal[i] + '"/>'].join(''));
+        thumbs.push(['<img src="thumb/', gal[i], '" data-id="', gal[i], '"/>'].join(''));
       }
       var thumbElem = document.getElementById('thumbs');
       thumbElem.innerHTML = thumbs.join('');
+      selectPhoto(selected);
     });
   }
+  document.getElementById('thumbs').addEventListener('click', function(e) {
+    var id = e.target.getAttribute('data-id');
+    if (id) {
+      selectPhoto(id == selected ? null : id);
+    }
+  });
+  document.getElementById('thumbs').addEventListener('dblclick', function(e) {
+    var id = e.target.getAttribute('data-id');
+    if (id) {
+      window.open('media/' + id, '_blank');
+    }
+  });
+  document.getElementById('undo').addEventListener('click', function() {
+    if (selected) {
+      setLocation({id: selected, undo: true});
+    }
+  });
   map.addListener("bounds_changed", function() {
     bounds = map.getBounds();
     clearMarkers();
@@ -144,7 +408,7 @@ function initMap(mapElement, bounds) {
       var lo1 = bounds.getNorthEast().lng();
       var z = map.getZoom();
       var u = ['viewport.json?la0=', la0, '&lo0=', lo0,
-        '&la1=', la1, '&lo1=', lo1, '&zoom=', z].join('');
+        '&la1=', la1, '&lo1=', lo1, '&zoom=', z, collectionParam()].join('');
       getJSON(u, function(vp) {
         if (!vp) return;
         var r = vp.radius;
@@ -175,13 +439,18 @@ function initMap(mapElement, bounds) {
   map.addListener("click", function(e) {
     hideGallery();
   });
+  map.addListener("rightclick", function(e) {
+    if (selected) {
+      setLocation({id: selected, lat: e.latLng.lat(), long: e.latLng.lng()});
+    }
+  });
   map.addListener("zoom_changed", function() {
   });
 
   // init overlays
   var spotOverlay = new google.maps.ImageMapType({
     getTileUrl: function(coord, zoom) {
-      return ['/tile/spot/', coord.x, '_', coord.y, '_', zoom].join('');
+      return ['/tile/spot/', coord.x, '_', coord.y, '_', zoom, '?v=', tileVersion, collectionParam()].join('');
     },
     opacity: 0.5,
     tileSize: google.maps.Size(256, 256)
@@ -189,11 +458,17 @@ function initMap(mapElement, bounds) {
   map.overlayMapTypes.push(spotOverlay);
   var photoOverlay = new google.maps.ImageMapType({
     getTileUrl: function(coord, zoom) {
-      return ['/tile/photo/', coord.x, '_', coord.y, '_', zoom].join('');
+      return ['/tile/photo/', coord.x, '_', coord.y, '_', zoom, '?v=', tileVersion, collectionParam()].join('');
     },
     tileSize: google.maps.Size(256, 256)
   });
   map.overlayMapTypes.push(photoOverlay);
+  var headingOverlay = new google.maps.ImageMapType({
+    getTileUrl: function(coord, zoom) {
+      return ['/tile/heading/', coord.x, '_', coord.y, '_', zoom, '?v=', tileVersion, collectionParam()].join('');
+    },
+    tileSize: google.maps.Size(256, 256)
+  });
 
   // coord map for debugging
   function CoordMapType(tileSize) {
@@ -213,11 +488,22 @@ function initMap(mapElement, bounds) {
   };
 
   var pmControlDiv = document.createElement('div');
-  var pmControl = new PhotoMapControl(pmControlDiv, map, spotOverlay, photoOverlay);
+  var pmControl = new PhotoMapControl(pmControlDiv, map, spotOverlay, photoOverlay, headingOverlay);
 
   pmControlDiv.index = 1;
   pmControlDiv.style['padding-top'] = '10px';
   map.controls[google.maps.ControlPosition.TOP_CENTER].push(pmControlDiv);
+
+  var searchControlDiv = document.createElement('div');
+  new PlaceSearchControl(searchControlDiv, map, collectionParam);
+  map.controls[google.maps.ControlPosition.TOP_LEFT].push(searchControlDiv);
+
+  var collControlDiv = document.createElement('div');
+  new CollectionControl(collControlDiv, function(c) {
+    collection = c;
+    refreshMap();
+  });
+  map.controls[google.maps.ControlPosition.TOP_LEFT].push(collControlDiv);
 }
 
 function init() {
@@ -259,3 +545,19 @@ function getJSON(url, success) {
     xhr.open("GET", url, true);
     xhr.send();
 }
+
+function postJSON(url, data, success) {
+    var xhr = new XMLHttpRequest();
+    xhr.onreadystatechange = function() {
+      if (xhr.readyState == 4) {
+        if (xhr.status == 200) {
+          success(JSON.parse(xhr.responseText));
+        } else {
+          console.error(xhr.statusText);
+        }
+      }
+    };
+    xhr.open("POST", url, true);
+    xhr.setRequestHeader("Content-Type", "application/json");
+    xhr.send(JSON.stringify(data));
+}
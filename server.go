@@ -2,19 +2,28 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"mime"
 	"net/http"
+	"path"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/tajtiattila/photomap/gazetteer"
 	"github.com/tajtiattila/photomap/imagecache"
 )
 
-func NewTileHandler(f func(x, y, zoom int) []byte) http.Handler {
-	starttime := time.Now()
+// NewTileHandler returns a handler serving tiles from f,
+// showing the photos of the collection in the query, if any.
+// The context of the request is passed to f, which returns nil
+// if it is done. The tiles are reported to be last modified at mt().
+func NewTileHandler(f func(ctx context.Context, x, y, zoom int, coll string) []byte, mt func() time.Time) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		s := req.URL.Path
 		if len(s) == 0 || s[0] != '/' {
@@ -43,13 +52,16 @@ func NewTileHandler(f func(x, y, zoom int) []byte) http.Handler {
 		}
 		xmask := (1 << uint(zoom)) - 1
 		x = x & xmask
-		data := f(x, y, zoom)
-		http.ServeContent(w, req, "tile.png", starttime, bytes.NewReader(data))
+		data := f(req.Context(), x, y, zoom, req.URL.Query().Get("collection"))
+		if data == nil {
+			// request cancelled
+			return
+		}
+		http.ServeContent(w, req, "tile.png", mt(), bytes.NewReader(data))
 	})
 }
 
 func NewViewportPlaceHandler(tm *TileMap) http.Handler {
-	starttime := time.Now()
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		v := req.URL.Query()
 		var eh errh
@@ -59,23 +71,27 @@ func NewViewportPlaceHandler(tm *TileMap) http.Handler {
 		if eh.handleError(w, "bounds/zoom invalid") {
 			return
 		}
-		places, dist := tm.PhotoPlaces(la0, lo0, la1, lo1, zoom)
+		places, dist := tm.PhotoPlaces(la0, lo0, la1, lo1, zoom, v.Get("collection"))
 		coords := make([]json.Number, 0, len(places)*2)
+		names := make([]*gazetteer.Place, 0, len(places))
 		for _, p := range places {
 			coords = append(coords,
 				json.Number(fmt.Sprintf("%.6f", p.Lat)),
 				json.Number(fmt.Sprintf("%.6f", p.Long)))
+			names = append(names, p.Place)
 		}
 		type viewPortResponse struct {
 			Radius float64       `json:"radius"`
 			Coords []json.Number `json:"coords"`
+
+			// places of coords, or nulls if unknown
+			Places []*gazetteer.Place `json:"places"`
 		}
-		serveJson(w, req, viewPortResponse{dist, coords}, starttime)
+		serveJson(w, req, viewPortResponse{dist, coords, names}, tm.ModTime())
 	})
 }
 
 func NewGalleryHandler(tm *TileMap) http.Handler {
-	starttime := time.Now()
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		v := req.URL.Query()
 		var eh errh
@@ -84,12 +100,34 @@ func NewGalleryHandler(tm *TileMap) http.Handler {
 		if eh.handleError(w, "loc/zoom invalid") {
 			return
 		}
-		res := tm.Gallery(lat, long, zoom)
-		if len(res) == 0 {
+		mt := tm.ModTime()
+		ids, place := tm.Gallery(lat, long, zoom, v.Get("collection"))
+		if len(ids) == 0 {
 			http.NotFound(w, req)
 			return
 		}
-		serveJson(w, req, res, starttime)
+		type galleryResponse struct {
+			Ids   []string         `json:"ids"`
+			Place *gazetteer.Place `json:"place,omitempty"`
+		}
+		serveJson(w, req, galleryResponse{ids, place}, mt)
+	})
+}
+
+// maxPlaceResults is the number of places returned by place search.
+const maxPlaceResults = 10
+
+// NewPlaceSearchHandler returns a handler
+// searching the gazetteer of tm for places.
+func NewPlaceSearchHandler(tm *TileMap) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		v := req.URL.Query()
+		q := strings.TrimSpace(v.Get("q"))
+		if q == "" {
+			http.Error(w, "query missing", http.StatusBadRequest)
+			return
+		}
+		serveJson(w, req, tm.SearchPlaces(q, maxPlaceResults, v.Get("collection")), tm.ModTime())
 	})
 }
 
@@ -100,7 +138,7 @@ func NewThumbnailHandler(ic *imagecache.ImageCache) http.Handler {
 			http.Error(w, "invalid thumb path", http.StatusBadRequest)
 			return
 		}
-		r, mt, err := ic.Thumbnail(key[1:])
+		r, mt, err := ic.Thumbnail(req.Context(), key[1:])
 		if err != nil {
 			log.Println(err)
 			http.NotFound(w, req)
@@ -110,6 +148,123 @@ func NewThumbnailHandler(ic *imagecache.ImageCache) http.Handler {
 	})
 }
 
+// NewPhotoHandler returns a handler serving
+// the details of photos as /{key}.json.
+func NewPhotoHandler(ic *imagecache.ImageCache, tm *TileMap) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := req.URL.Path
+		if len(key) == 0 || key[0] != '/' || !strings.HasSuffix(key, ".json") {
+			http.Error(w, "invalid photo path", http.StatusBadRequest)
+			return
+		}
+		p, err := ic.Photo(strings.TrimSuffix(key[1:], ".json"))
+		if err != nil {
+			if err != imagecache.ErrNotFound {
+				log.Println(err)
+			}
+			http.NotFound(w, req)
+			return
+		}
+		serveJson(w, req, p, tm.ModTime())
+	})
+}
+
+// NewMediaHandler returns a handler serving the original photos and videos.
+// Range requests are supported if the source provides seekable readers.
+func NewMediaHandler(ic *imagecache.ImageCache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := req.URL.Path
+		if len(key) == 0 || key[0] != '/' {
+			http.Error(w, "invalid media path", http.StatusBadRequest)
+			return
+		}
+		rc, name, err := ic.Open(req.Context(), key[1:])
+		if err != nil {
+			log.Println(err)
+			http.NotFound(w, req)
+			return
+		}
+		defer rc.Close()
+
+		if ct := mediaType(name); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		if rs, ok := rc.(io.ReadSeeker); ok {
+			http.ServeContent(w, req, name, time.Time{}, rs)
+			return
+		}
+		io.Copy(w, rc)
+	})
+}
+
+// mediaType returns the content type of the media file name,
+// or the empty string if it is unknown.
+func mediaType(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".mp4":
+		return "video/mp4"
+	case ".mov":
+		return "video/quicktime"
+	}
+	return mime.TypeByExtension(path.Ext(name))
+}
+
+// NewLocationHandler returns a handler to edit photo locations.
+// It accepts POST requests with a JSON object having the photo
+// id and either lat and long, or undo set to true.
+func NewLocationHandler(ic *imagecache.ImageCache, tm *TileMap) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var lr struct {
+			Id   string   `json:"id"`
+			Lat  *float64 `json:"lat"`
+			Long *float64 `json:"long"`
+			Undo bool     `json:"undo"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&lr); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var ii imagecache.ImageInfo
+		var err error
+		switch {
+		case lr.Undo:
+			ii, err = ic.UndoLocation(lr.Id)
+		case lr.Lat != nil && lr.Long != nil:
+			if math.Abs(*lr.Lat) > 90 || math.Abs(*lr.Long) > 180 {
+				http.Error(w, "lat/long out of range", http.StatusBadRequest)
+				return
+			}
+			ii, err = ic.SetLocation(lr.Id, *lr.Lat, *lr.Long)
+		default:
+			http.Error(w, "need lat and long, or undo", http.StatusBadRequest)
+			return
+		}
+		switch err {
+		case nil:
+		case imagecache.ErrNotFound:
+			http.NotFound(w, req)
+			return
+		case imagecache.ErrInvalid, imagecache.ErrNoHistory:
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		default:
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tm.Refresh()
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(ii); err != nil {
+			log.Println(err)
+		}
+	})
+}
+
 type errh struct {
 	err error
 }
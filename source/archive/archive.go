@@ -0,0 +1,354 @@
+// Package archive reads images from zip and tar archives
+// without unpacking them.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrFormat is returned by Open for files that are not zip or tar archives.
+var ErrFormat = errors.New("archive: unknown format")
+
+// IsArchive reports whether name has the extension of a supported archive.
+func IsArchive(name string) bool {
+	n := strings.ToLower(name)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(n, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Archive is an open zip or tar archive.
+type Archive struct {
+	Path    string
+	ModTime time.Time
+
+	f     *os.File
+	files []*File
+	names map[string]*File
+
+	gz *gzPool // nil unless compressed tar
+}
+
+// File is a regular file within an Archive.
+type File struct {
+	Name    string // slash separated name within the archive
+	ModTime time.Time
+	Size    int64
+
+	a   *Archive
+	zf  *zip.File // zip member
+	off int64     // tar member data offset
+}
+
+// Open opens the archive at path, and builds the index of its files.
+func Open(path string) (*Archive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	a := &Archive{
+		Path:    path,
+		ModTime: fi.ModTime(),
+		f:       f,
+		names:   make(map[string]*File),
+	}
+	if err := a.index(fi.Size()); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("archive: %s: %v", path, err)
+	}
+	for _, af := range a.files {
+		a.names[af.Name] = af
+	}
+	return a, nil
+}
+
+func (a *Archive) index(size int64) error {
+	var magic [4]byte
+	n, _ := a.f.ReadAt(magic[:], 0)
+	switch {
+	case n >= 4 && string(magic[:4]) == "PK\x03\x04", n >= 4 && string(magic[:4]) == "PK\x05\x06":
+		return a.indexZip(size)
+	case n >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		zr, err := gzip.NewReader(bufio.NewReader(io.NewSectionReader(a.f, 0, size)))
+		if err != nil {
+			return err
+		}
+		a.gz = &gzPool{f: a.f, size: size}
+		return a.indexTar(zr)
+	}
+	if !strings.HasSuffix(strings.ToLower(a.Path), ".tar") {
+		return ErrFormat
+	}
+	return a.indexTar(io.NewSectionReader(a.f, 0, size))
+}
+
+func (a *Archive) indexZip(size int64) error {
+	zr, err := zip.NewReader(a.f, size)
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+		a.files = append(a.files, &File{
+			Name:    cleanName(zf.Name),
+			ModTime: zf.Modified,
+			Size:    int64(zf.UncompressedSize64),
+			a:       a,
+			zf:      zf,
+		})
+	}
+	return nil
+}
+
+// indexTar records the offsets of file data within the uncompressed
+// tar stream r. The tar reader reads headers in whole blocks, so after
+// Next the position in r is the start of the file data.
+func (a *Archive) indexTar(r io.Reader) error {
+	cr := &countingReader{r: r}
+	tr := tar.NewReader(cr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg && h.Typeflag != '\x00' {
+			continue
+		}
+		a.files = append(a.files, &File{
+			Name:    cleanName(h.Name),
+			ModTime: h.ModTime,
+			Size:    h.Size,
+			a:       a,
+			off:     cr.n,
+		})
+	}
+}
+
+// Files returns the regular files in a in archive order.
+func (a *Archive) Files() []*File {
+	return a.files
+}
+
+// File returns the file with name in a, or nil if there is none.
+func (a *Archive) File(name string) *File {
+	return a.names[name]
+}
+
+// Close closes a. Files of a may not be opened after Close.
+func (a *Archive) Close() error {
+	if a.gz != nil {
+		a.gz.close()
+	}
+	return a.f.Close()
+}
+
+// Open opens the contents of f. Files of uncompressed tar
+// archives are io.ReadSeekers and io.ReaderAts.
+//
+// Files of compressed tar archives are read by decompressing the
+// archive up to the file. Only the offsets of files are indexed, not
+// the state of the decompressor, so there is no seeking within the
+// compressed data. Decompressors are reused after the files are
+// closed, so opening files in archive order is much faster than
+// random access, which may decompress the archive from its start.
+func (f *File) Open() (io.ReadCloser, error) {
+	switch {
+	case f.zf != nil:
+		return f.zf.Open()
+	case f.a.gz != nil:
+		return f.a.gz.open(f.off, f.Size)
+	}
+	return sectionFile{io.NewSectionReader(f.a.f, f.off, f.Size)}, nil
+}
+
+// sectionFile is a file of an uncompressed tar archive.
+type sectionFile struct {
+	*io.SectionReader
+}
+
+func (sectionFile) Close() error { return nil }
+
+func cleanName(n string) string {
+	return strings.TrimPrefix(path.Clean("/"+n), "/")
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// maxGzReaders is the maximum number of decompressors
+// kept for a compressed tar archive.
+const maxGzReaders = 4
+
+// gzPool keeps decompressors of a gzip file
+// at different positions of the uncompressed data.
+// Decompressors can only move forward, or restart
+// from the beginning of the file.
+type gzPool struct {
+	f    *os.File
+	size int64
+
+	mtx  sync.Mutex
+	idle []*gzReader
+	n    int // number of decompressors, including busy ones
+}
+
+type gzReader struct {
+	zr  *gzip.Reader
+	pos int64 // position in uncompressed data
+}
+
+// open returns a reader of n bytes of the uncompressed data at off.
+// The decompressor used is returned to p when the reader is closed.
+func (p *gzPool) open(off, n int64) (io.ReadCloser, error) {
+	r, err := p.get(off)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.CopyN(ioutil.Discard, r.zr, off-r.pos); err != nil {
+		p.discard()
+		return nil, err
+	}
+	r.pos = off
+	return &gzFile{p: p, r: r, n: n}, nil
+}
+
+// gzFile reads a file of a compressed tar archive.
+type gzFile struct {
+	p *gzPool
+	r *gzReader // nil after Close or a read error
+	n int64     // bytes remaining
+}
+
+func (f *gzFile) Read(p []byte) (int, error) {
+	if f.r == nil {
+		return 0, errors.New("archive: read after close or error")
+	}
+	if f.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > f.n {
+		p = p[:f.n]
+	}
+	n, err := f.r.zr.Read(p)
+	f.r.pos += int64(n)
+	f.n -= int64(n)
+	switch {
+	case err == io.EOF && f.n > 0:
+		err = io.ErrUnexpectedEOF
+		fallthrough
+	case err != nil && err != io.EOF:
+		f.r = nil
+		f.p.discard()
+	}
+	return n, err
+}
+
+// Close returns the decompressor of f to the pool. Data
+// not read yet is skipped when the decompressor is reused.
+func (f *gzFile) Close() error {
+	if f.r != nil {
+		f.p.put(f.r)
+		f.r = nil
+	}
+	return nil
+}
+
+// get returns the idle decompressor positioned closest before off,
+// or one positioned at the start of the data.
+func (p *gzPool) get(off int64) (*gzReader, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	best := -1
+	for i, r := range p.idle {
+		if r.pos <= off && (best < 0 || r.pos > p.idle[best].pos) {
+			best = i
+		}
+	}
+	if best < 0 && p.n >= maxGzReaders && len(p.idle) != 0 {
+		// rewind the least advanced one
+		best = 0
+		for i, r := range p.idle {
+			if r.pos < p.idle[best].pos {
+				best = i
+			}
+		}
+		r := p.idle[best]
+		if err := r.zr.Reset(p.reader()); err != nil {
+			return nil, err
+		}
+		r.pos = 0
+	}
+	if best >= 0 {
+		r := p.idle[best]
+		p.idle = append(p.idle[:best], p.idle[best+1:]...)
+		return r, nil
+	}
+	zr, err := gzip.NewReader(p.reader())
+	if err != nil {
+		return nil, err
+	}
+	p.n++
+	return &gzReader{zr: zr}, nil
+}
+
+func (p *gzPool) reader() io.Reader {
+	return bufio.NewReader(io.NewSectionReader(p.f, 0, p.size))
+}
+
+func (p *gzPool) put(r *gzReader) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if len(p.idle) >= maxGzReaders {
+		p.n--
+		return
+	}
+	p.idle = append(p.idle, r)
+}
+
+func (p *gzPool) discard() {
+	p.mtx.Lock()
+	p.n--
+	p.mtx.Unlock()
+}
+
+func (p *gzPool) close() {
+	p.mtx.Lock()
+	for _, r := range p.idle {
+		r.zr.Close()
+	}
+	p.idle = nil
+	p.mtx.Unlock()
+}
@@ -0,0 +1,142 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testFiles = []struct {
+	name, body string
+}{
+	{"a.jpg", "first"},
+	{"dir/b.jpg", "second file"},
+	{"dir/b.jpg.xmp", "<x:xmpmeta/>"},
+	{"c.txt", "not an image"},
+}
+
+func TestArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "photomap-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mt := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, name := range []string{"x.zip", "x.tar", "x.tar.gz"} {
+		p := filepath.Join(dir, name)
+		if err := writeArchive(p, mt); err != nil {
+			t.Fatal(err)
+		}
+		a, err := Open(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(a.Files()); n != len(testFiles) {
+			t.Errorf("%s: got %d files, want %d", name, n, len(testFiles))
+		}
+		// read in reverse order to exercise rewinding
+		for i := len(testFiles) - 1; i >= 0; i-- {
+			tf := testFiles[i]
+			f := a.File(tf.name)
+			if f == nil {
+				t.Errorf("%s: %s missing", name, tf.name)
+				continue
+			}
+			if !f.ModTime.Equal(mt) {
+				t.Errorf("%s: %s modtime is %v, want %v", name, tf.name, f.ModTime, mt)
+			}
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name == "x.tar" {
+				// for RAW previews and range requests
+				_, rs := rc.(io.ReadSeeker)
+				_, ra := rc.(io.ReaderAt)
+				if !rs || !ra {
+					t.Errorf("%s: %s is %T, want an io.ReadSeeker and io.ReaderAt", name, tf.name, rc)
+				}
+			}
+			data, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil || string(data) != tf.body {
+				t.Errorf("%s: %s is %q, %v; want %q", name, tf.name, data, err, tf.body)
+			}
+		}
+		// read the start of files in order, skipping the rest
+		for _, tf := range testFiles {
+			rc, err := a.File(tf.name).Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 3)
+			_, err = io.ReadFull(rc, buf)
+			rc.Close()
+			if err != nil || string(buf) != tf.body[:3] {
+				t.Errorf("%s: %s starts with %q, %v; want %q", name, tf.name, buf, err, tf.body[:3])
+			}
+		}
+		a.Close()
+	}
+
+	s, err := NewSource(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if n := len(s.ModTimes()); n != 6 {
+		t.Errorf("source has %d images, want 6", n)
+	}
+}
+
+func writeArchive(path string, mt time.Time) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if filepath.Ext(path) == ".zip" {
+		zw := zip.NewWriter(f)
+		for _, tf := range testFiles {
+			w, err := zw.CreateHeader(&zip.FileHeader{Name: tf.name, Method: zip.Deflate, Modified: mt})
+			if err != nil {
+				return err
+			}
+			io.WriteString(w, tf.body)
+		}
+		return zw.Close()
+	}
+
+	var w io.Writer = f
+	if filepath.Ext(path) == ".gz" {
+		zw := gzip.NewWriter(f)
+		defer zw.Close()
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	for _, tf := range testFiles {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     tf.name,
+			Mode:     0644,
+			Size:     int64(len(tf.body)),
+			ModTime:  mt,
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(tw, tf.body); err != nil {
+			return fmt.Errorf("write %s: %v", tf.name, err)
+		}
+	}
+	return tw.Close()
+}
@@ -0,0 +1,188 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+	"github.com/tajtiattila/photomap/source/xmp"
+)
+
+func init() {
+	source.Register("archive", func(paths string) (source.ImageSource, error) {
+		return NewSource(filepath.SplitList(paths)...)
+	})
+	source.RegisterScheme("archive", func(u *url.URL) (source.ImageSource, error) {
+		return NewSource(source.FilePath(u))
+	})
+}
+
+const archprefix = "archive:"
+
+// Source is an image source using the images within archives.
+// Images are listed in archive order, which is the fastest
+// order of reading them from compressed tar archives.
+type Source struct {
+	archives []*Archive
+	prefixes []string // id prefixes of archives
+}
+
+// NewSource returns a new Source for the archives in paths.
+// Directories in paths are searched recursively for archives.
+func NewSource(paths ...string) (*Source, error) {
+	s := new(Source)
+	for _, p := range paths {
+		err := filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || (path != p && !IsArchive(path)) {
+				return nil
+			}
+			return s.add(path)
+		})
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Source) add(p string) error {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return err
+	}
+	a, err := Open(abs)
+	if err != nil {
+		return err
+	}
+	s.archives = append(s.archives, a)
+	s.prefixes = append(s.prefixes, archprefix+filepath.ToSlash(abs)+"!")
+	return nil
+}
+
+// modTime returns the modtime of the image f. A new archive
+// invalidates all of its images, a newer member or sidecar only f.
+func modTime(f *File) time.Time {
+	mt := f.a.ModTime
+	if f.ModTime.After(mt) {
+		mt = f.ModTime
+	}
+	if sc := sidecar(f); sc != nil && sc.ModTime.After(mt) {
+		mt = sc.ModTime
+	}
+	return mt
+}
+
+func (s *Source) ModTimes() map[string]time.Time {
+	m := make(map[string]time.Time)
+	s.List(context.Background(), func(id string, mt time.Time) error {
+		m[id] = mt
+		return nil
+	})
+	return m
+}
+
+// List implements source.Lister, listing images in archive order.
+func (s *Source) List(ctx context.Context, fn func(id string, modTime time.Time) error) error {
+	for i, a := range s.archives {
+		for _, f := range a.Files() {
+			if !source.HasImageExt(f.Name) || a.File(f.Name) != f {
+				// not an image, or replaced by a later member
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(s.prefixes[i]+f.Name, modTime(f)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// file returns the image file with id.
+func (s *Source) file(id string) (*File, bool) {
+	for i, pfx := range s.prefixes {
+		if strings.HasPrefix(id, pfx) {
+			f := s.archives[i].File(id[len(pfx):])
+			if f != nil && source.HasImageExt(f.Name) {
+				return f, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (s *Source) Info(id string) (source.ImageInfo, error) {
+	f, ok := s.file(id)
+	if !ok {
+		return source.ImageInfo{}, os.ErrNotExist
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return source.ImageInfo{}, err
+	}
+	defer rc.Close()
+
+	var hooks []source.InfoHook
+	if sc := sidecar(f); sc != nil {
+		if m, err := loadSidecar(sc); err != nil {
+			log.Println(err)
+		} else {
+			hooks = append(hooks, m.Hook())
+		}
+	}
+	return source.InfoFromReader(modTime(f), rc, hooks...)
+}
+
+func (s *Source) Open(id string) (io.ReadCloser, error) {
+	f, ok := s.file(id)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return f.Open()
+}
+
+func (s *Source) Close() error {
+	var err error
+	for _, a := range s.archives {
+		if e := a.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// sidecar returns the XMP sidecar of f, or nil if it has none.
+func sidecar(f *File) *File {
+	for _, n := range xmp.SidecarNames(f.Name) {
+		if sc := f.a.File(n); sc != nil {
+			return sc
+		}
+	}
+	return nil
+}
+
+func loadSidecar(sc *File) (*xmp.Meta, error) {
+	rc, err := sc.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	m, err := xmp.Parse(rc)
+	if err != nil {
+		return nil, fmt.Errorf("xmp: %s!%s: %v", sc.a.Path, sc.Name, err)
+	}
+	return m, nil
+}
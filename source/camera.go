@@ -0,0 +1,97 @@
+package source
+
+import (
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// Camera is the metadata of the camera and exposure of an image.
+// Fields are zero if unknown.
+type Camera struct {
+	Make  string
+	Model string
+	Lens  string
+
+	FocalLength float64 // in mm
+	Aperture    float64 // f-number
+	Exposure    float64 // exposure time in seconds
+	ISO         int
+}
+
+// exifCamera returns the camera metadata in x.
+func exifCamera(x *exif.Exif) Camera {
+	c := Camera{
+		Make:  exifString(x, exif.Make),
+		Model: exifString(x, exif.Model),
+		Lens:  exifString(x, exif.LensModel),
+	}
+	c.FocalLength, _ = exifFloat(x, exif.FocalLength)
+	c.Aperture, _ = exifFloat(x, exif.FNumber)
+	c.Exposure, _ = exifFloat(x, exif.ExposureTime)
+	if iso, ok := exifFloat(x, exif.ISOSpeedRatings); ok {
+		c.ISO = int(iso)
+	}
+	return c
+}
+
+// exifAltitude returns the GPS altitude in x in meters, or nil if it is missing.
+func exifAltitude(x *exif.Exif) *float64 {
+	alt, ok := exifFloat(x, exif.GPSAltitude)
+	if !ok {
+		return nil
+	}
+	if ref, ok := exifFloat(x, exif.GPSAltitudeRef); ok && ref == 1 {
+		// below sea level
+		alt = -alt
+	}
+	return &alt
+}
+
+// exifDirection returns the GPS image direction in x in degrees,
+// or nil if it is missing, and reports whether it is from magnetic
+// north rather than true north.
+func exifDirection(x *exif.Exif) (dir *float64, magnetic bool) {
+	d, ok := exifFloat(x, exif.GPSImgDirection)
+	if !ok || d < 0 || d > 360 {
+		return nil, false
+	}
+	return &d, exifString(x, exif.GPSImgDirectionRef) == "M"
+}
+
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	s, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(s)
+}
+
+// exifFloat returns the first value of
+// the rational or integer field name of x.
+func exifFloat(x *exif.Exif, name exif.FieldName) (float64, bool) {
+	tag, err := x.Get(name)
+	if err != nil || tag.Count == 0 {
+		return 0, false
+	}
+	switch tag.Format() {
+	case tiff.RatVal:
+		n, d, _ := tag.Rat2(0)
+		if d == 0 {
+			return 0, false
+		}
+		return float64(n) / float64(d), true
+	case tiff.IntVal:
+		v, _ := tag.Int64(0)
+		return float64(v), true
+	case tiff.FloatVal:
+		v, _ := tag.Float(0)
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,124 @@
+package source
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// tiffField is a field of testTIFF. Values are strings,
+// uint8 (BYTE), uint16 (SHORT), uint32 (LONG) or [2]uint32 (RATIONAL),
+// or [][2]uint32 for several RATIONALs.
+type tiffField struct {
+	tag uint16
+	val interface{}
+}
+
+// testTIFF returns a little endian TIFF with IFD0 having ifd0, and
+// pointers to an Exif and a GPS IFD having exifIFD and gpsIFD.
+func testTIFF(ifd0, exifIFD, gpsIFD []tiffField) []byte {
+	le := binary.LittleEndian
+	buf := new(bytes.Buffer)
+	buf.Write([]byte{'I', 'I', 42, 0, 8, 0, 0, 0})
+
+	ifdLen := func(n int) int { return 2 + n*12 + 4 }
+	off0 := 8
+	offExif := off0 + ifdLen(len(ifd0)+2)
+	offGPS := offExif + ifdLen(len(exifIFD))
+	data := offGPS + ifdLen(len(gpsIFD))
+
+	var extra []byte
+	write := func(fields []tiffField) {
+		binary.Write(buf, le, uint16(len(fields)))
+		for _, f := range fields {
+			var typ uint16
+			var count uint32
+			var v []byte
+			switch x := f.val.(type) {
+			case string:
+				typ, count, v = 2, uint32(len(x)+1), append([]byte(x), 0)
+			case uint8:
+				typ, count, v = 1, 1, []byte{x}
+			case uint16:
+				typ, count, v = 3, 1, make([]byte, 2)
+				le.PutUint16(v, x)
+			case uint32:
+				typ, count, v = 4, 1, make([]byte, 4)
+				le.PutUint32(v, x)
+			case [2]uint32:
+				typ, count, v = 5, 1, make([]byte, 8)
+				le.PutUint32(v, x[0])
+				le.PutUint32(v[4:], x[1])
+			case [][2]uint32:
+				typ, count, v = 5, uint32(len(x)), make([]byte, 8*len(x))
+				for i, r := range x {
+					le.PutUint32(v[8*i:], r[0])
+					le.PutUint32(v[8*i+4:], r[1])
+				}
+			}
+			binary.Write(buf, le, f.tag)
+			binary.Write(buf, le, typ)
+			binary.Write(buf, le, count)
+			if len(v) <= 4 {
+				buf.Write(append(v, make([]byte, 4-len(v))...))
+			} else {
+				binary.Write(buf, le, uint32(data+len(extra)))
+				extra = append(extra, v...)
+			}
+		}
+		binary.Write(buf, le, uint32(0))
+	}
+	write(append(ifd0,
+		tiffField{0x8769, uint32(offExif)},
+		tiffField{0x8825, uint32(offGPS)}))
+	write(exifIFD)
+	write(gpsIFD)
+	buf.Write(extra)
+	return buf.Bytes()
+}
+
+func TestExifCamera(t *testing.T) {
+	data := testTIFF(
+		[]tiffField{
+			{0x010F, "Nikon"},
+			{0x0110, "D750"},
+		},
+		[]tiffField{
+			{0x829A, [2]uint32{1, 250}},  // ExposureTime
+			{0x829D, [2]uint32{18, 10}},  // FNumber
+			{0x8827, uint16(400)},        // ISOSpeedRatings
+			{0x920A, [2]uint32{500, 10}}, // FocalLength
+			{0xA434, "50mm f/1.8"},       // LensModel
+		},
+		[]tiffField{
+			{0x0005, uint8(1)},            // GPSAltitudeRef, below sea level
+			{0x0006, [2]uint32{125, 10}},  // GPSAltitude
+			{0x0010, "M"},                 // GPSImgDirectionRef, magnetic north
+			{0x0011, [2]uint32{2705, 10}}, // GPSImgDirection
+		},
+	)
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Camera{
+		Make:        "Nikon",
+		Model:       "D750",
+		Lens:        "50mm f/1.8",
+		FocalLength: 50,
+		Aperture:    1.8,
+		Exposure:    0.004,
+		ISO:         400,
+	}
+	if got := exifCamera(x); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if alt := exifAltitude(x); alt == nil || *alt != -12.5 {
+		t.Errorf("altitude is %v, want -12.5", alt)
+	}
+	if dir, mag := exifDirection(x); dir == nil || *dir != 270.5 || !mag {
+		t.Errorf("direction is %v, magnetic %v, want 270.5 magnetic", dir, mag)
+	}
+}
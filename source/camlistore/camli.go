@@ -3,9 +3,11 @@ package camlistore
 import (
 	"io"
 	"log"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"camlistore.org/pkg/blob"
@@ -21,19 +23,69 @@ func init() {
 	source.Register("camlistore", func(cn string) (source.ImageSource, error) {
 		return NewCamliImageSource(cn)
 	})
+	// camli://host[:port] or camli:// for the default server,
+	// eg. camli://host?q=is:image&poll=5m
+	source.RegisterScheme("camli", func(u *url.URL) (source.ImageSource, error) {
+		opt := DefaultOptions
+		q := u.Query()
+		if v := q.Get("q"); v != "" {
+			opt.Expression = v
+		}
+		if v := q.Get("poll"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, err
+			}
+			opt.PollInterval = d
+		}
+		return NewWithOptions(u.Host+strings.TrimRight(u.Path, "/"), opt)
+	})
 }
 
-type CamliImageSource struct {
-	c *client.Client
+// Options are the options of CamliImageSource.
+type Options struct {
+	// Expression is the search expression selecting the permanodes
+	// used, eg. "has:location" or "is:image" to have images with
+	// locations only in their EXIF data.
+	Expression string
 
-	m map[string]time.Time
+	// PollInterval is the time between searches
+	// for changes when the source is watched.
+	PollInterval time.Duration
+}
+
+// DefaultOptions are the options used by NewCamliImageSource.
+var DefaultOptions = Options{
+	Expression:   "has:location",
+	PollInterval: time.Minute,
+}
+
+// fullSyncEvery is the number of polls after which all permanodes are
+// searched to find the removed ones. Other polls search only for
+// permanodes modified since the last poll.
+const fullSyncEvery = 10
+
+type CamliImageSource struct {
+	c   *client.Client
+	opt Options
 
+	mtx sync.RWMutex // protects m, cam, lastMod and w
+	m   map[string]time.Time
 	cam map[string]camliInfo
+
+	lastMod time.Time // last permanode modtime seen
+
+	w *poller // non-nil if watching
+
+	thumbMtx  sync.Mutex // protects thumbRoot and thumbFail
+	thumbRoot string     // thumbnail handler URL, empty if unknown
+	thumbFail time.Time  // time of the last failed thumbRoot discovery
 }
 
 type camliInfo struct {
 	mt      time.Time
-	ploc    *latlng // location from permanode attrs, if any
+	ploc    *latlng   // location from permanode attrs, if any
+	ptime   time.Time // creation time from permanode attrs, if any
 	content blob.Ref
 }
 
@@ -44,21 +96,51 @@ type latlng struct {
 const camliprefix = "camli:"
 
 func NewCamliImageSource(cn string) (*CamliImageSource, error) {
+	return NewWithOptions(cn, DefaultOptions)
+}
+
+// NewWithOptions returns a new CamliImageSource for the
+// server cn using the permanodes selected by opt.
+func NewWithOptions(cn string, opt Options) (*CamliImageSource, error) {
+	if opt.Expression == "" {
+		opt.Expression = DefaultOptions.Expression
+	}
+	if opt.PollInterval <= 0 {
+		opt.PollInterval = DefaultOptions.PollInterval
+	}
 	c := client.New(cn)
 	err := c.SetupAuth()
 	if err != nil {
 		return nil, err
 	}
-	is := &CamliImageSource{c: c}
+	is := &CamliImageSource{
+		c:   c,
+		opt: opt,
+		m:   make(map[string]time.Time),
+		cam: make(map[string]camliInfo),
+	}
 	return is, is.init()
 }
 
 func (is *CamliImageSource) Close() error {
+	is.mtx.Lock()
+	w := is.w
+	is.w = nil
+	is.mtx.Unlock()
+	if w != nil {
+		w.close()
+	}
 	return is.c.Close()
 }
 
 func (is *CamliImageSource) ModTimes() map[string]time.Time {
-	return is.m
+	is.mtx.RLock()
+	defer is.mtx.RUnlock()
+	m := make(map[string]time.Time, len(is.m))
+	for id, mt := range is.m {
+		m[id] = mt
+	}
+	return m
 }
 
 func (is *CamliImageSource) Info(id string) (ii source.ImageInfo, err error) {
@@ -69,20 +151,22 @@ func (is *CamliImageSource) Info(id string) (ii source.ImageInfo, err error) {
 	}
 	defer rc.Close()
 
+	is.mtx.RLock()
 	ci := is.cam[sref]
+	is.mtx.RUnlock()
 
-	ii, err = source.InfoFromReader(ci.mt, rc)
-
-	ok := err == nil || (source.IsNoLoc(err) && ci.ploc != nil)
-	if !ok {
-		return source.ImageInfo{}, err
-	}
-
-	if ci.ploc != nil {
-		// set/overwrite lat/long with those in permanode
-		ii.Lat, ii.Long = ci.ploc.lat, ci.ploc.lng
-	}
-	return ii, nil
+	// image info is kept on errors so that a source.Locator may use it
+	return source.InfoFromReader(ci.mt, rc, func(ii *source.ImageInfo) bool {
+		if !ci.ptime.IsZero() {
+			ii.CreateTime = ci.ptime
+		}
+		if ci.ploc != nil {
+			// set/overwrite lat/long with those in permanode
+			ii.Lat, ii.Long = ci.ploc.lat, ci.ploc.lng
+			return true
+		}
+		return false
+	})
 }
 
 func (is *CamliImageSource) Open(id string) (io.ReadCloser, error) {
@@ -90,7 +174,9 @@ func (is *CamliImageSource) Open(id string) (io.ReadCloser, error) {
 }
 
 func (is *CamliImageSource) open(id string) (io.ReadCloser, error) {
+	is.mtx.RLock()
 	ci, ok := is.cam[strings.TrimPrefix(id, camliprefix)]
+	is.mtx.RUnlock()
 	if !ok {
 		log.Printf("missing %q", id)
 		return nil, os.ErrNotExist
@@ -104,37 +190,59 @@ func (is *CamliImageSource) open(id string) (io.ReadCloser, error) {
 }
 
 func (is *CamliImageSource) init() error {
+	cam, err := is.search(time.Time{})
+	if err != nil {
+		return err
+	}
+	for id, ci := range cam {
+		is.m[camliprefix+id] = ci.mt
+		is.cam[id] = ci
+		if ci.mt.After(is.lastMod) {
+			is.lastMod = ci.mt
+		}
+	}
+	return nil
+}
+
+// search returns the permanodes matching the search expression,
+// or only those modified after since if it is not zero.
+func (is *CamliImageSource) search(since time.Time) (map[string]camliInfo, error) {
+	cam := make(map[string]camliInfo)
 	start := true
 	var cont string
 	for start || cont != "" {
 		start = false
 		sq := search.SearchQuery{
-			Expression: "has:location",
+			Expression: is.opt.Expression,
 			Describe: &search.DescribeRequest{
 				Rules: []*search.DescribeRule{
 					{
-						Attrs: []string{nodeattr.Latitude, nodeattr.Longitude},
+						Attrs: []string{nodeattr.Latitude, nodeattr.Longitude, nodeattr.DateCreated},
 					},
 				},
 			},
 			Continue: cont,
 		}
+		if !since.IsZero() {
+			sq.Sort = search.LastModifiedDesc
+		}
 		sr, err := is.c.Query(&sq)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		cont = sr.Continue
-		if is.m == nil {
-			is.m = make(map[string]time.Time)
-		}
-		if is.cam == nil {
-			is.cam = make(map[string]camliInfo)
-		}
 		for _, srb := range sr.Blobs {
 			id := srb.Blob.String()
 			db := sr.Describe.Meta[id]
+			if db == nil || db.Permanode == nil {
+				continue
+			}
+			if !since.IsZero() && !db.Permanode.ModTime.After(since) {
+				// the rest are older
+				return cam, nil
+			}
 			contentRef, ok := db.ContentRef()
-			if !ok || db.Permanode == nil {
+			if !ok {
 				continue
 			}
 			pna := db.Permanode.Attr
@@ -144,13 +252,14 @@ func (is *CamliImageSource) init() error {
 			if err1 == nil && err2 == nil {
 				ll = &latlng{lat, lng}
 			}
-			is.m[camliprefix+id] = db.Permanode.ModTime
-			is.cam[id] = camliInfo{
+			ct, _ := time.Parse(time.RFC3339, pna.Get(nodeattr.DateCreated))
+			cam[id] = camliInfo{
 				mt:      db.Permanode.ModTime,
 				ploc:    ll,
+				ptime:   ct,
 				content: contentRef,
 			}
 		}
 	}
-	return nil
+	return cam, nil
 }
@@ -0,0 +1,105 @@
+package camlistore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+// Thumbnail implements source.Thumbnailer using
+// the image resizing handler of the server UI.
+func (is *CamliImageSource) Thumbnail(ctx context.Context, id string, size int) (io.ReadCloser, error) {
+	is.mtx.RLock()
+	ci, ok := is.cam[strings.TrimPrefix(id, camliprefix)]
+	is.mtx.RUnlock()
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	root := is.thumbnailRoot()
+	if root == "" {
+		return nil, source.ErrNotSupported
+	}
+
+	u := fmt.Sprintf("%s%s/thumb.jpg?mw=%d&mh=%d", root, ci.content, size, size)
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := is.c.DoWithAuth(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		// not an image, eg. a video
+		return nil, source.ErrNotSupported
+	}
+	return resp.Body, nil
+}
+
+// thumbRetry is the time after which a failed
+// discovery of the thumbnail handler is retried.
+const thumbRetry = time.Minute
+
+// thumbnailRoot returns the URL of the thumbnail handler, or an empty
+// string if it is unavailable. The URL is discovered on first use,
+// and again at most every thumbRetry while discovery fails.
+func (is *CamliImageSource) thumbnailRoot() string {
+	is.thumbMtx.Lock()
+	defer is.thumbMtx.Unlock()
+	if is.thumbRoot == "" && time.Since(is.thumbFail) >= thumbRetry {
+		is.thumbRoot = is.discoverThumbRoot()
+		if is.thumbRoot == "" {
+			is.thumbFail = time.Now()
+		}
+	}
+	return is.thumbRoot
+}
+
+// discoverThumbRoot returns the URL of the thumbnail handler using
+// the UI root in the discovery document of the server, or an empty
+// string if it is not found.
+func (is *CamliImageSource) discoverThumbRoot() string {
+	br, err := is.c.BlobRoot()
+	if err != nil {
+		return ""
+	}
+	base, err := url.Parse(br)
+	if err != nil {
+		return ""
+	}
+	base.Path, base.RawQuery = "/", ""
+
+	req, err := http.NewRequest("GET", base.String(), nil)
+	if err != nil {
+		return ""
+	}
+	req.Header.Set("Accept", "text/x-camli-configuration")
+	resp, err := is.c.DoWithAuth(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	var disco struct {
+		UIRoot string `json:"uiRoot"`
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil || json.Unmarshal(data, &disco) != nil || disco.UIRoot == "" {
+		return ""
+	}
+	ui, err := base.Parse(disco.UIRoot)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(ui.String(), "/") + "/thumbnail/"
+}
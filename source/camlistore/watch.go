@@ -0,0 +1,120 @@
+package camlistore
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+// poller polls the server for changed permanodes.
+type poller struct {
+	is *CamliImageSource
+
+	ch   chan source.Change
+	quit chan struct{}
+	done chan struct{}
+}
+
+// Watch implements source.Watcher by searching for permanodes
+// modified since the last search every Options.PollInterval.
+// Removed permanodes are found by a full search every fullSyncEvery polls.
+func (is *CamliImageSource) Watch() (<-chan source.Change, error) {
+	is.mtx.Lock()
+	defer is.mtx.Unlock()
+	if is.w != nil {
+		return nil, errors.New("camlistore: already watching")
+	}
+	w := &poller{
+		is:   is,
+		ch:   make(chan source.Change, 64),
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	is.w = w
+	go w.run()
+	return w.ch, nil
+}
+
+func (w *poller) close() {
+	close(w.quit)
+	<-w.done
+}
+
+func (w *poller) run() {
+	defer close(w.done)
+	defer close(w.ch)
+
+	t := time.NewTicker(w.is.opt.PollInterval)
+	defer t.Stop()
+	for n := 1; ; n++ {
+		select {
+		case <-t.C:
+		case <-w.quit:
+			return
+		}
+		var err error
+		if n%fullSyncEvery == 0 {
+			err = w.poll(true)
+		} else {
+			err = w.poll(false)
+		}
+		if err != nil {
+			log.Println("camlistore poll:", err)
+		}
+	}
+}
+
+// poll searches for changes and reports them. Permanodes missing
+// from the search results are reported as removed if full is true.
+func (w *poller) poll(full bool) error {
+	is := w.is
+	is.mtx.RLock()
+	since := is.lastMod
+	is.mtx.RUnlock()
+	if full {
+		since = time.Time{}
+	}
+
+	cam, err := is.search(since)
+	if err != nil {
+		return err
+	}
+
+	var changes []source.Change
+	is.mtx.Lock()
+	for id, ci := range cam {
+		op := source.Modified
+		if old, ok := is.cam[id]; !ok {
+			op = source.Added
+		} else if !ci.mt.After(old.mt) {
+			continue
+		}
+		is.m[camliprefix+id] = ci.mt
+		is.cam[id] = ci
+		if ci.mt.After(is.lastMod) {
+			is.lastMod = ci.mt
+		}
+		changes = append(changes, source.Change{Op: op, Id: camliprefix + id, ModTime: ci.mt})
+	}
+	if full {
+		for id := range is.cam {
+			if _, ok := cam[id]; !ok {
+				delete(is.cam, id)
+				delete(is.m, camliprefix+id)
+				changes = append(changes, source.Change{Op: source.Removed, Id: camliprefix + id})
+			}
+		}
+	}
+	is.mtx.Unlock()
+
+	for _, c := range changes {
+		select {
+		case w.ch <- c:
+		case <-w.quit:
+			return nil
+		}
+	}
+	return nil
+}
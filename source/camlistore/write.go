@@ -0,0 +1,91 @@
+package camlistore
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/schema"
+	"camlistore.org/pkg/schema/nodeattr"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+// WriteInfo implements source.Writer by setting the location and
+// creation time attributes of the permanode of id with signed claims.
+// The image itself is left intact, therefore a location cleared in
+// the permanode is still used if it is present in the image.
+func (is *CamliImageSource) WriteInfo(id string, e source.Edit) (time.Time, error) {
+	sref := strings.TrimPrefix(id, camliprefix)
+	is.mtx.RLock()
+	ci, ok := is.cam[sref]
+	is.mtx.RUnlock()
+	if !ok {
+		return time.Time{}, os.ErrNotExist
+	}
+	pn, ok := blob.Parse(sref)
+	if !ok {
+		return time.Time{}, os.ErrNotExist
+	}
+
+	var claims []*schema.Builder
+	switch {
+	case e.Loc != nil:
+		claims = append(claims,
+			schema.NewSetAttributeClaim(pn, nodeattr.Latitude, formatCoord(e.Loc.Lat)),
+			schema.NewSetAttributeClaim(pn, nodeattr.Longitude, formatCoord(e.Loc.Long)))
+	case e.ClearLoc:
+		claims = append(claims,
+			schema.NewDelAttributeClaim(pn, nodeattr.Latitude, ""),
+			schema.NewDelAttributeClaim(pn, nodeattr.Longitude, ""))
+	}
+	if !e.CreateTime.IsZero() {
+		claims = append(claims, schema.NewSetAttributeClaim(pn,
+			nodeattr.DateCreated, e.CreateTime.Format(time.RFC3339)))
+	}
+	if len(claims) == 0 {
+		return ci.mt, nil
+	}
+
+	// the modtime of the permanode is the date of its last claim
+	now := time.Now().UTC()
+	for _, c := range claims {
+		if _, err := is.c.UploadAndSignBlob(c.SetClaimDate(now)); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	// Update the permanode as seen by the poller meanwhile, if any.
+	// The poller sees no change because the modtime is updated, but
+	// lastMod is left to the poller so that it searches for changes
+	// made by others since its last search.
+	is.mtx.Lock()
+	defer is.mtx.Unlock()
+	ci, ok = is.cam[sref]
+	if !ok {
+		return time.Time{}, os.ErrNotExist
+	}
+	if ci.mt.After(now) {
+		// poller has seen these claims and later ones
+		return ci.mt, nil
+	}
+	switch {
+	case e.Loc != nil:
+		ci.ploc = &latlng{e.Loc.Lat, e.Loc.Long}
+	case e.ClearLoc:
+		ci.ploc = nil
+	}
+	if !e.CreateTime.IsZero() {
+		ci.ptime = e.CreateTime
+	}
+	ci.mt = now
+	is.cam[sref] = ci
+	is.m[id] = now
+	return now, nil
+}
+
+func formatCoord(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,212 @@
+package source
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// Time sources in ImageInfo.TimeSource, from the most to the least reliable.
+const (
+	// TimeOffset is the EXIF DateTimeOriginal with its OffsetTimeOriginal.
+	TimeOffset = "offset"
+
+	// TimeGPS is the UTC time in EXIF GPSDateStamp and GPSTimeStamp,
+	// shown in the time zone at the location if it is known.
+	TimeGPS = "gps"
+
+	// TimeUTC is a UTC time in metadata, such as in MP4 files.
+	TimeUTC = "utc"
+
+	// TimeZone is the EXIF DateTimeOriginal in the time zone at the location.
+	TimeZone = "zone"
+
+	// TimeMeta is a time set by the source from its metadata,
+	// such as sidecar files or catalogs.
+	TimeMeta = "meta"
+
+	// TimeLocal is the EXIF DateTimeOriginal
+	// assumed to be in the local time zone.
+	TimeLocal = "local"
+
+	// TimeModTime is the modification time of the file.
+	TimeModTime = "modtime"
+)
+
+// EXIF 2.31 offset fields, not known by goexif.
+const (
+	offsetTime         exif.FieldName = "OffsetTime"
+	offsetTimeOriginal exif.FieldName = "OffsetTimeOriginal"
+)
+
+func init() {
+	exif.RegisterParsers(offsetParser{})
+}
+
+// offsetParser loads the offset fields from the Exif sub-IFD.
+type offsetParser struct{}
+
+func (offsetParser) Parse(x *exif.Exif) error {
+	tag, err := x.Get(exif.ExifIFDPointer)
+	if err != nil {
+		return nil
+	}
+	off, err := tag.Int64(0)
+	if err != nil {
+		return nil
+	}
+	r := bytes.NewReader(x.Raw)
+	if _, err := r.Seek(off, 0); err != nil {
+		return nil
+	}
+	d, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return nil
+	}
+	x.LoadTags(d, map[uint16]exif.FieldName{
+		0x9010: offsetTime,
+		0x9011: offsetTimeOriginal,
+	}, false)
+	return nil
+}
+
+// captureTime returns the capture time in x along with its time source.
+// The location lat, long is used for the time zone if hasLoc is true.
+//
+// The instant is taken from the offset fields or the GPS time stamps if
+// present, because DateTimeOriginal is the local wall clock time of the
+// camera. The time zone for showing the time is that of the offset field,
+// or the zone at the location, or else the offset between the wall clock
+// and GPS times.
+func captureTime(x *exif.Exif, hasLoc bool, lat, long float64) (time.Time, string, error) {
+	wall, werr := exifWallTime(x)
+	if werr == nil {
+		for _, n := range []exif.FieldName{offsetTimeOriginal, offsetTime} {
+			if off, ok := exifOffset(x, n); ok {
+				return inLocation(wall, time.FixedZone("", off)), TimeOffset, nil
+			}
+		}
+	}
+
+	var zone *time.Location
+	if hasLoc {
+		zone = LookupLocation(lat, long)
+	}
+
+	if utc, ok := exifGPSTime(x); ok {
+		switch {
+		case zone != nil:
+			return utc.In(zone), TimeGPS, nil
+		case werr == nil:
+			if off, ok := wallOffset(wall, utc); ok {
+				return utc.In(time.FixedZone("", off)), TimeGPS, nil
+			}
+		}
+		return utc, TimeGPS, nil
+	}
+
+	if werr != nil {
+		return time.Time{}, "", werr
+	}
+	if zone != nil {
+		return inLocation(wall, zone), TimeZone, nil
+	}
+	return inLocation(wall, time.Local), TimeLocal, nil
+}
+
+// inLocation returns the wall clock time of t in loc.
+func inLocation(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(),
+		t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// exifWallTime returns DateTimeOriginal, or DateTime if it is missing,
+// in UTC. The result is the wall clock time of the camera, not an instant.
+func exifWallTime(x *exif.Exif) (time.Time, error) {
+	s := exifString(x, exif.DateTimeOriginal)
+	if s == "" {
+		s = exifString(x, exif.DateTime)
+	}
+	if s == "" {
+		return time.Time{}, errors.New("exif: no DateTimeOriginal or DateTime")
+	}
+	t, err := time.Parse(exifTimeLayout, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if sub := exifString(x, exif.SubSecTimeOriginal); sub != "" {
+		if f, err := strconv.ParseFloat("0."+sub, 64); err == nil {
+			t = t.Add(time.Duration(f * float64(time.Second)))
+		}
+	}
+	return t, nil
+}
+
+// exifOffset returns the offset in seconds of the
+// offset field name of x, such as "+02:00".
+func exifOffset(x *exif.Exif, name exif.FieldName) (int, bool) {
+	s := exifString(x, name)
+	if len(s) != 6 || (s[0] != '+' && s[0] != '-') || s[3] != ':' {
+		return 0, false
+	}
+	h, err1 := strconv.Atoi(s[1:3])
+	m, err2 := strconv.Atoi(s[4:6])
+	if err1 != nil || err2 != nil || h > 14 || m > 59 {
+		return 0, false
+	}
+	off := h*3600 + m*60
+	if s[0] == '-' {
+		off = -off
+	}
+	return off, true
+}
+
+// exifGPSTime returns the UTC time in GPSDateStamp and GPSTimeStamp.
+func exifGPSTime(x *exif.Exif) (time.Time, bool) {
+	d, err := time.Parse("2006:01:02", strings.TrimSpace(exifString(x, exif.GPSDateStamp)))
+	if err != nil {
+		return time.Time{}, false
+	}
+	tag, err := x.Get(exif.GPSTimeStamp)
+	if err != nil || tag.Count != 3 || tag.Format() != tiff.RatVal {
+		return time.Time{}, false
+	}
+	var hms [3]float64
+	for i := range hms {
+		n, den, _ := tag.Rat2(i)
+		if den == 0 {
+			return time.Time{}, false
+		}
+		hms[i] = float64(n) / float64(den)
+	}
+	sec := hms[0]*3600 + hms[1]*60 + hms[2]
+	if sec < 0 || sec >= 86400+1 {
+		return time.Time{}, false
+	}
+	return d.Add(time.Duration(sec * float64(time.Second))), true
+}
+
+// wallOffset returns the offset in seconds of the zone of the wall clock
+// time wall at the instant utc, rounded to 15 minutes to allow for GPS
+// time stamps recorded a bit before or after the photo was taken.
+func wallOffset(wall, utc time.Time) (int, bool) {
+	const q = 15 * 60
+	d := int(wall.Sub(utc) / time.Second)
+	var off int
+	if d >= 0 {
+		off = (d + q/2) / q * q
+	} else {
+		off = -((-d + q/2) / q * q)
+	}
+	if off < -12*3600 || off > 14*3600 {
+		return 0, false
+	}
+	return off, true
+}
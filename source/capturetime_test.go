@@ -0,0 +1,93 @@
+package source
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+func TestCaptureTime(t *testing.T) {
+	gpsTime := [][2]uint32{{10, 1}, {30, 1}, {0, 1}}
+	tests := []struct {
+		exif, gps []tiffField
+		src       string
+		utc       string
+		local     string
+	}{
+		{
+			exif: []tiffField{
+				{0x9003, "2016:07:01 12:30:00"}, // DateTimeOriginal
+				{0x9011, "+02:00"},              // OffsetTimeOriginal
+			},
+			// GPS is ignored when the offset is known
+			gps: []tiffField{
+				{0x001D, "2016:07:01"},
+				{0x0007, [][2]uint32{{11, 1}, {0, 1}, {0, 1}}},
+			},
+			src:   TimeOffset,
+			utc:   "2016-07-01 10:30:00",
+			local: "2016-07-01 12:30:00",
+		},
+		{
+			exif: []tiffField{
+				{0x9003, "2016:07:01 12:31:10"},
+			},
+			gps: []tiffField{
+				{0x001D, "2016:07:01"}, // GPSDateStamp
+				{0x0007, gpsTime},      // GPSTimeStamp
+			},
+			src:   TimeGPS,
+			utc:   "2016-07-01 10:30:00",
+			local: "2016-07-01 12:30:00",
+		},
+		{
+			gps: []tiffField{
+				{0x001D, "2016:06:30"},
+				{0x0007, gpsTime},
+			},
+			src:   TimeGPS,
+			utc:   "2016-06-30 10:30:00",
+			local: "2016-06-30 10:30:00",
+		},
+		{
+			exif: []tiffField{
+				{0x9003, "2016:07:01 12:30:00"},
+				{0x9291, "5"}, // SubSecTimeOriginal
+			},
+			src:   TimeLocal,
+			local: "2016-07-01 12:30:00.5",
+		},
+	}
+	const layout = "2006-01-02 15:04:05.999"
+	for i, tt := range tests {
+		x, err := exif.Decode(bytes.NewReader(testTIFF(nil, tt.exif, tt.gps)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct, src, err := captureTime(x, false, 0, 0)
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if src != tt.src {
+			t.Errorf("%d: source is %q, want %q", i, src, tt.src)
+		}
+		if tt.utc != "" {
+			if got := ct.UTC().Format(layout); got != tt.utc {
+				t.Errorf("%d: utc is %s, want %s", i, got, tt.utc)
+			}
+		}
+		if got := ct.Format(layout); got != tt.local {
+			t.Errorf("%d: local time is %s, want %s", i, got, tt.local)
+		}
+	}
+
+	x, err := exif.Decode(bytes.NewReader(testTIFF(nil, []tiffField{{0x9000, "0230"}}, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := captureTime(x, false, 0, 0); err == nil {
+		t.Error("got time without time fields")
+	}
+}
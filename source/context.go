@@ -0,0 +1,177 @@
+package source
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Source is the streaming, context aware version of ImageSource.
+// Images are listed one at a time, so that sources of millions of
+// images need not be collected into a single map, and listing as
+// well as reading images can be cancelled.
+//
+// ImageSources are used as Sources with Adapt.
+type Source interface {
+	// List calls f with the id and modtime of each image.
+	// Listing stops at the first error returned by f or
+	// when ctx is done, and the error is returned by List.
+	List(ctx context.Context, f func(id string, modTime time.Time) error) error
+
+	// Info returns the image info for the specified id,
+	// as ImageSource.Info does.
+	Info(ctx context.Context, id string) (ImageInfo, error)
+
+	// Open returns a io.ReadCloser for the image with id. Reading
+	// fails with the error of ctx after ctx is done.
+	Open(ctx context.Context, id string) (io.ReadCloser, error)
+
+	// Close closes this source.
+	Close() error
+}
+
+// InfoBatcher is implemented by sources
+// able to get the info of several images at once.
+type InfoBatcher interface {
+	// InfoMany returns the info of the images with ids. The
+	// info and error of ids[i] are in infos[i] and errs[i].
+	InfoMany(ctx context.Context, ids []string) (infos []ImageInfo, errs []error)
+}
+
+// Lister is implemented by ImageSources that can
+// list their images without collecting them into a map.
+type Lister interface {
+	// List lists images as Source.List does.
+	List(ctx context.Context, f func(id string, modTime time.Time) error) error
+}
+
+// ContextSource is implemented by ImageSources that
+// can cancel requests using contexts, such as remote sources.
+type ContextSource interface {
+	// InfoContext is Info using ctx.
+	InfoContext(ctx context.Context, id string) (ImageInfo, error)
+
+	// OpenContext is Open using ctx.
+	OpenContext(ctx context.Context, id string) (io.ReadCloser, error)
+}
+
+// Adapt returns src as a Source. The Lister, ContextSource and InfoBatcher
+// interfaces of src are used if implemented. Otherwise ctx is checked
+// before calling src, and readers returned by src.Open are wrapped
+// to stop reading once ctx is done.
+//
+// Optional interfaces of src such as Writer or Watcher are not
+// implemented by the Source returned, they should be used through src.
+func Adapt(src ImageSource) Source {
+	return adapter{src}
+}
+
+type adapter struct {
+	src ImageSource
+}
+
+func (a adapter) List(ctx context.Context, f func(id string, modTime time.Time) error) error {
+	if l, ok := a.src.(Lister); ok {
+		return l.List(ctx, f)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for id, mt := range a.src.ModTimes() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := f(id, mt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a adapter) Info(ctx context.Context, id string) (ImageInfo, error) {
+	if c, ok := a.src.(ContextSource); ok {
+		return c.InfoContext(ctx, id)
+	}
+	if err := ctx.Err(); err != nil {
+		return ImageInfo{}, err
+	}
+	return a.src.Info(id)
+}
+
+// InfoMany implements InfoBatcher, calling Info
+// for each id if src is not an InfoBatcher.
+func (a adapter) InfoMany(ctx context.Context, ids []string) ([]ImageInfo, []error) {
+	if b, ok := a.src.(InfoBatcher); ok {
+		return b.InfoMany(ctx, ids)
+	}
+	infos := make([]ImageInfo, len(ids))
+	errs := make([]error, len(ids))
+	for i, id := range ids {
+		infos[i], errs[i] = a.Info(ctx, id)
+	}
+	return infos, errs
+}
+
+func (a adapter) Open(ctx context.Context, id string) (io.ReadCloser, error) {
+	if c, ok := a.src.(ContextSource); ok {
+		return c.OpenContext(ctx, id)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rc, err := a.src.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	r := &ctxReader{ctx, rc}
+	s, ok := rc.(io.Seeker)
+	if !ok {
+		return r, nil
+	}
+	if ra, ok := rc.(io.ReaderAt); ok {
+		return ctxReadSeekerAt{ctxReadSeeker{r, s}, ra}, nil
+	}
+	return ctxReadSeeker{r, s}, nil
+}
+
+func (a adapter) Close() error {
+	return a.src.Close()
+}
+
+// ctxReader fails reading after ctx is done.
+type ctxReader struct {
+	ctx context.Context
+	rc  io.ReadCloser
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.rc.Read(p)
+}
+
+func (r *ctxReader) Close() error {
+	return r.rc.Close()
+}
+
+// ctxReadSeeker is a ctxReader of a seekable reader,
+// so that it can be used with http.ServeContent.
+type ctxReadSeeker struct {
+	*ctxReader
+	io.Seeker
+}
+
+// ctxReadSeekerAt is a ctxReadSeeker that also reads at offsets,
+// so that RAW files can be read without loading them into memory.
+type ctxReadSeekerAt struct {
+	ctxReadSeeker
+	ra io.ReaderAt
+}
+
+func (r ctxReadSeekerAt) ReadAt(p []byte, off int64) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ra.ReadAt(p, off)
+}
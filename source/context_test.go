@@ -0,0 +1,93 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+type readerSource struct {
+	testSource
+}
+
+func (s readerSource) Open(id string) (io.ReadCloser, error) {
+	if _, ok := s.testSource[id]; !ok {
+		return nil, os.ErrNotExist
+	}
+	return bytesFile{bytes.NewReader([]byte(id))}, nil
+}
+
+type bytesFile struct {
+	*bytes.Reader
+}
+
+func (bytesFile) Close() error { return nil }
+
+func TestAdapt(t *testing.T) {
+	m := NewMulti()
+	m.Add("a", readerSource{testSource{"1": time.Unix(1, 0), "2": time.Unix(2, 0)}})
+	m.Add("b", testSource{"3": time.Unix(3, 0)})
+	s := Adapt(m)
+
+	var ids []string
+	err := s.List(context.Background(), func(id string, mt time.Time) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(ids)
+	if len(ids) != 3 || ids[0] != "a:1" || ids[2] != "b:3" {
+		t.Errorf("listed %v", ids)
+	}
+
+	infos, errs := s.(InfoBatcher).InfoMany(context.Background(), []string{"b:3", "x:1", "a:2"})
+	if errs[0] != nil || errs[1] == nil || errs[2] != nil || infos[2].Lat != 1 {
+		t.Errorf("got %v %v", infos, errs)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rc, err := s.Open(ctx, "a:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if _, ok := rc.(io.Seeker); !ok {
+		t.Error("reader of seekable source is not seekable")
+	}
+	cancel()
+	if _, err := rc.Read(make([]byte, 1)); err != context.Canceled {
+		t.Errorf("read after cancel: %v", err)
+	}
+	if _, err := s.Info(ctx, "a:1"); err != context.Canceled {
+		t.Errorf("info after cancel: %v", err)
+	}
+}
+
+func TestAdaptReaderAt(t *testing.T) {
+	s := Adapt(readerSource{testSource{"1": time.Unix(1, 0)}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rc, err := s.Open(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	ra, ok := rc.(readSeekerAt)
+	if !ok {
+		t.Fatal("reader of file is not a readSeekerAt")
+	}
+	p := make([]byte, 1)
+	if _, err := ra.ReadAt(p, 0); err != nil || p[0] != '1' {
+		t.Errorf("ReadAt = %q, %v", p, err)
+	}
+	cancel()
+	if _, err := ra.ReadAt(p, 0); err != context.Canceled {
+		t.Errorf("ReadAt after cancel: %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+package source
+
+import (
+	"path"
+	"strings"
+)
+
+// Extensions are the file name extensions of photos, RAW files and
+// videos that sources listing files by name use as images.
+var Extensions = []string{
+	".jpg", ".jpeg", ".png",
+	".dng", ".cr2", ".nef", ".arw",
+	".mp4", ".mov",
+}
+
+// HasImageExt reports whether name has one of Extensions,
+// ignoring case. Name may be a slash separated path.
+func HasImageExt(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	for _, e := range Extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,259 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+// Options control which files are used by a FileSystemImageSource.
+type Options struct {
+	// Include and Exclude are lists of patterns in .gitignore syntax
+	// matched against paths relative to the source root.
+	// Files are used if they match any of the Include patterns,
+	// or Include is empty. Files and directories matching
+	// Exclude are skipped.
+	Include []string
+	Exclude []string
+
+	// Extensions lists the file name extensions of images, eg. ".jpg".
+	// Extensions are matched case insensitively. Files
+	// with any extension are used if Extensions is empty.
+	Extensions []string
+
+	// Hidden makes the source use hidden files and directories,
+	// as well as system files such as Synology @eaDir thumbnails.
+	Hidden bool
+
+	// FollowSymlinks makes the source follow symbolic links.
+	// Links pointing to their parent directories are skipped.
+	FollowSymlinks bool
+}
+
+// DefaultExtensions are the extensions used by DefaultOptions.
+var DefaultExtensions = source.Extensions
+
+// DefaultOptions are the options used by NewFileSystemImageSource.
+var DefaultOptions = Options{
+	Extensions: DefaultExtensions,
+}
+
+// IgnoreFile is the name of files having patterns of paths to skip
+// within the directory of the file in .gitignore syntax.
+const IgnoreFile = ".photomapignore"
+
+// systemNames are names of files and directories
+// created by operating systems and NAS devices.
+var systemNames = map[string]bool{
+	"@eaDir":                    true, // Synology
+	"@__thumb":                  true, // QNAP
+	"#recycle":                  true,
+	"#snapshot":                 true,
+	"$RECYCLE.BIN":              true,
+	"System Volume Information": true,
+	"lost+found":                true,
+	"Thumbs.db":                 true,
+	"desktop.ini":               true,
+}
+
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".") || systemNames[name]
+}
+
+// filter decides which files are used.
+type filter struct {
+	opt     Options
+	include rules
+	exclude rules
+	exts    map[string]bool
+}
+
+func newFilter(opt Options) (*filter, error) {
+	f := &filter{opt: opt}
+	var err error
+	if f.include, err = parseRules(opt.Include, ""); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = parseRules(opt.Exclude, ""); err != nil {
+		return nil, err
+	}
+	if len(opt.Extensions) != 0 {
+		f.exts = make(map[string]bool)
+		for _, e := range opt.Extensions {
+			if !strings.HasPrefix(e, ".") {
+				e = "." + e
+			}
+			f.exts[strings.ToLower(e)] = true
+		}
+	}
+	return f, nil
+}
+
+// skip reports whether the file or directory at rel should be skipped
+// because of ign or the options of f. Files not skipped may be sidecars.
+func (f *filter) skip(rel string, isDir bool, ign rules) bool {
+	if !f.opt.Hidden && isHidden(path.Base(rel)) {
+		return true
+	}
+	return f.exclude.match(rel, isDir) || ign.match(rel, isDir)
+}
+
+// isImage reports whether the file at rel that is not skipped is an image.
+func (f *filter) isImage(rel string) bool {
+	if path.Base(rel) == IgnoreFile {
+		return false
+	}
+	if f.exts != nil && !f.exts[strings.ToLower(path.Ext(rel))] {
+		return false
+	}
+	return len(f.include) == 0 || f.include.match(rel, false)
+}
+
+// loadIgnore returns ign with the rules in the ignore file of dir
+// appended. The path of dir relative to the source root is rel.
+func loadIgnore(dir, rel string, ign rules) rules {
+	f, err := os.Open(filepath.Join(dir, IgnoreFile))
+	if err != nil {
+		return ign
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	r, err := parseRules(lines, rel)
+	if err != nil {
+		log.Printf("%s: %v", f.Name(), err)
+		return ign
+	}
+	return append(ign[:len(ign):len(ign)], r...)
+}
+
+// rules is a list of patterns, the last matching one wins.
+type rules []pattern
+
+// match reports whether rel is matched by r.
+func (r rules) match(rel string, isDir bool) bool {
+	for i := len(r) - 1; i >= 0; i-- {
+		if r[i].match(rel, isDir) {
+			return !r[i].negate
+		}
+	}
+	return false
+}
+
+func parseRules(lines []string, base string) (rules, error) {
+	var r rules
+	for _, l := range lines {
+		p, ok, err := parsePattern(l, base)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			r = append(r, p)
+		}
+	}
+	return r, nil
+}
+
+// pattern is a pattern in .gitignore syntax.
+type pattern struct {
+	rx       *regexp.Regexp
+	base     string // slash separated directory of pattern relative to root
+	negate   bool   // pattern started with '!'
+	dirOnly  bool   // pattern ended with '/'
+	anchored bool   // pattern had a '/' in it, so match full path instead of name
+}
+
+func parsePattern(s, base string) (p pattern, ok bool, err error) {
+	s = strings.TrimRight(s, " \t\r")
+	if s == "" || s[0] == '#' {
+		return pattern{}, false, nil
+	}
+	p.base = base
+	switch {
+	case s[0] == '!':
+		p.negate = true
+		s = s[1:]
+	case strings.HasPrefix(s, `\!`) || strings.HasPrefix(s, `\#`):
+		s = s[1:]
+	}
+	if strings.HasSuffix(s, "/") {
+		p.dirOnly = true
+		s = strings.TrimRight(s, "/")
+	}
+	if strings.Contains(s, "/") {
+		p.anchored = true
+		s = strings.TrimPrefix(s, "/")
+	}
+	if s == "" {
+		return pattern{}, false, nil
+	}
+	p.rx, err = regexp.Compile("^" + globRegexp(s) + "$")
+	return p, err == nil, err
+}
+
+func (p pattern) match(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.base != "" {
+		if !strings.HasPrefix(rel, p.base+"/") {
+			return false
+		}
+		rel = rel[len(p.base)+1:]
+	}
+	if !p.anchored {
+		rel = path.Base(rel)
+	}
+	return p.rx.MatchString(rel)
+}
+
+// globRegexp converts the glob pattern s to a regular expression.
+func globRegexp(s string) string {
+	b := new(bytes.Buffer)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case strings.HasPrefix(s[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(s[i:], "/**") && i+3 == len(s):
+			b.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(s[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			j := strings.IndexByte(s[i+1:], ']')
+			if j < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := s[i+1 : i+1+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += j + 1
+		case c == '\\' && i+1 < len(s):
+			i++
+			b.WriteString(regexp.QuoteMeta(s[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(s[i : i+1]))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,112 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPattern(t *testing.T) {
+	tests := []struct {
+		pattern, base string
+		rel           string
+		isDir         bool
+		want          bool
+	}{
+		{"*.jpg", "", "a.jpg", false, true},
+		{"*.jpg", "", "x/y/a.jpg", false, true},
+		{"*.jpg", "", "a.png", false, false},
+		{"/a.jpg", "", "x/a.jpg", false, false},
+		{"x/*.jpg", "", "x/a.jpg", false, true},
+		{"x/*.jpg", "", "x/y/a.jpg", false, false},
+		{"x/**/*.jpg", "", "x/y/z/a.jpg", false, true},
+		{"**/tmp", "", "x/y/tmp", true, true},
+		{"tmp/", "", "x/tmp", false, false},
+		{"tmp/", "", "x/tmp", true, true},
+		{"IMG_00[0-4]?.jpg", "", "IMG_0042.jpg", false, true},
+		{"IMG_00[!0-4]?.jpg", "", "IMG_0042.jpg", false, false},
+		{"/a.jpg", "x", "x/a.jpg", false, true},
+		{"/a.jpg", "x", "a.jpg", false, false},
+	}
+	for _, tt := range tests {
+		p, ok, err := parsePattern(tt.pattern, tt.base)
+		if !ok || err != nil {
+			t.Errorf("parse %q: %v %v", tt.pattern, ok, err)
+			continue
+		}
+		if got := p.match(tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("%q (base %q) match %q = %v, want %v",
+				tt.pattern, tt.base, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "photomap-fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.jpg":                 "",
+		"b.JPG":                 "",
+		"c.txt":                 "",
+		".hidden/d.jpg":         "",
+		"@eaDir/e.jpg":          "",
+		"x/f.jpg":               "",
+		"x/skip/g.jpg":          "",
+		"x/h.jpg":               "",
+		"x/" + IgnoreFile:       "skip/\nh.jpg\n",
+		"y/i.jpg":               "",
+		"y/raw/j.jpg":           "",
+		"y/" + IgnoreFile + "x": "",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(dir, filepath.Join(dir, "y", "loop")); err != nil {
+		t.Skip("symlinks unsupported:", err)
+	}
+
+	f, err := newFilter(Options{
+		Extensions:     DefaultExtensions,
+		Exclude:        []string{"/y/raw"},
+		FollowSymlinks: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	f.walk(dir, "", func(path, rel string, fi os.FileInfo) error {
+		if !fi.IsDir() && f.isImage(rel) {
+			got = append(got, rel)
+		}
+		return nil
+	})
+	sort.Strings(got)
+	want := "a.jpg b.JPG x/f.jpg y/i.jpg"
+	if s := strings.Join(got, " "); s != want {
+		t.Errorf("got %s, want %s", s, want)
+	}
+
+	for _, rel := range got {
+		if !f.accept(dir, rel) {
+			t.Errorf("%s not accepted", rel)
+		}
+	}
+	for _, rel := range []string{"x/h.jpg", "x/skip/g.jpg", "@eaDir/e.jpg", "y/raw/j.jpg"} {
+		if f.accept(dir, rel) {
+			t.Errorf("%s accepted", rel)
+		}
+	}
+}
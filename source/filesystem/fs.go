@@ -1,32 +1,89 @@
 package fs
 
 import (
+	"context"
 	"io"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/tajtiattila/photomap/source"
+	"github.com/tajtiattila/photomap/source/xmp"
 )
 
 func init() {
 	source.Register("filesystem", func(paths string) (source.ImageSource, error) {
-		return NewFileSystemImageSource(strings.Split(paths, string(os.PathSeparator))...)
+		return NewWithOptions(DefaultOptions, filepath.SplitList(paths)...)
+	})
+	source.RegisterScheme("file", func(u *url.URL) (source.ImageSource, error) {
+		return NewWithOptions(urlOptions(u.Query()), source.FilePath(u))
 	})
 }
 
+// urlOptions returns DefaultOptions overridden by the query
+// parameters include, exclude, ext, hidden and follow in v.
+// List parameters are comma separated or repeated.
+func urlOptions(v url.Values) Options {
+	opt := DefaultOptions
+	list := func(key string) []string {
+		var r []string
+		for _, s := range v[key] {
+			for _, e := range strings.Split(s, ",") {
+				if e != "" {
+					r = append(r, e)
+				}
+			}
+		}
+		return r
+	}
+	if _, ok := v["include"]; ok {
+		opt.Include = list("include")
+	}
+	if _, ok := v["exclude"]; ok {
+		opt.Exclude = list("exclude")
+	}
+	if _, ok := v["ext"]; ok {
+		opt.Extensions = list("ext")
+	}
+	if _, ok := v["hidden"]; ok {
+		opt.Hidden = source.QueryBool(v, "hidden")
+	}
+	if _, ok := v["follow"]; ok {
+		opt.FollowSymlinks = source.QueryBool(v, "follow")
+	}
+	return opt
+}
+
 type FileSystemImageSource struct {
-	modTimes map[string]time.Time
+	roots []string // absolute paths
+	f     *filter
+
+	mtx      sync.Mutex           // protects listed, modTimes and w
+	listed   time.Time            // start of the last listing
+	modTimes map[string]time.Time // images known by w, nil unless watching
+	w        *watcher             // nil unless watching
 }
 
 const fsprefix = "file://"
 
+// NewFileSystemImageSource returns a source
+// using the images within paths with DefaultOptions.
 func NewFileSystemImageSource(paths ...string) (*FileSystemImageSource, error) {
-	is := &FileSystemImageSource{
-		modTimes: make(map[string]time.Time),
+	return NewWithOptions(DefaultOptions, paths...)
+}
+
+// NewWithOptions returns a source using the images within paths
+// selected by opt.
+func NewWithOptions(opt Options, paths ...string) (*FileSystemImageSource, error) {
+	f, err := newFilter(opt)
+	if err != nil {
+		return nil, err
 	}
+	is := &FileSystemImageSource{f: f}
 	for _, p := range paths {
 		err := is.prepare(p)
 		if err != nil {
@@ -37,7 +94,36 @@ func NewFileSystemImageSource(paths ...string) (*FileSystemImageSource, error) {
 }
 
 func (is *FileSystemImageSource) ModTimes() map[string]time.Time {
-	return is.modTimes
+	m := make(map[string]time.Time)
+	err := is.List(context.Background(), func(id string, mt time.Time) error {
+		m[id] = mt
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+	}
+	return m
+}
+
+// List implements source.Lister by walking the roots of is,
+// without keeping the images listed in memory.
+func (is *FileSystemImageSource) List(ctx context.Context, f func(id string, modTime time.Time) error) error {
+	start := time.Now()
+	for _, root := range is.roots {
+		err := is.scan(root, func(id string, mt time.Time) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return f(id, mt)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	is.mtx.Lock()
+	is.listed = start
+	is.mtx.Unlock()
+	return nil
 }
 
 func (is *FileSystemImageSource) Info(id string) (ii source.ImageInfo, err error) {
@@ -51,7 +137,51 @@ func (is *FileSystemImageSource) Info(id string) (ii source.ImageInfo, err error
 		return source.ImageInfo{}, err
 	}
 
-	return source.InfoFromReader(fi.ModTime(), f)
+	var hooks []source.InfoHook
+	m, err := xmp.LoadSidecar(f.Name())
+	if err != nil {
+		log.Println(err)
+	} else if m != nil {
+		hooks = append(hooks, m.Hook())
+	}
+
+	return source.InfoFromReader(fi.ModTime(), f, hooks...)
+}
+
+// WriteInfo implements source.Writer by
+// writing e into the XMP sidecar of id.
+func (is *FileSystemImageSource) WriteInfo(id string, e source.Edit) (time.Time, error) {
+	f, err := is.open(id)
+	if err != nil {
+		return time.Time{}, err
+	}
+	fi, err := f.Stat()
+	f.Close()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	sp, err := xmp.WriteSidecar(f.Name(), e)
+	if err != nil {
+		return time.Time{}, err
+	}
+	sfi, err := os.Stat(sp)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	mt := fi.ModTime()
+	if sfi.ModTime().After(mt) {
+		mt = sfi.ModTime()
+	}
+	// the watcher, if any, sees no change
+	// because modTimes is already updated
+	is.mtx.Lock()
+	if is.modTimes != nil {
+		is.modTimes[id] = mt
+	}
+	is.mtx.Unlock()
+	return mt, nil
 }
 
 func (is *FileSystemImageSource) Open(id string) (io.ReadCloser, error) {
@@ -66,6 +196,13 @@ func (is *FileSystemImageSource) open(id string) (*os.File, error) {
 }
 
 func (is *FileSystemImageSource) Close() error {
+	is.mtx.Lock()
+	w := is.w
+	is.w = nil
+	is.mtx.Unlock()
+	if w != nil {
+		return w.close()
+	}
 	return nil
 }
 
@@ -74,16 +211,78 @@ func (is *FileSystemImageSource) prepare(root string) error {
 	if err != nil {
 		return err
 	}
-	return filepath.Walk(absroot, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			log.Println(err)
-			return nil
+	if _, err := os.Stat(absroot); err != nil {
+		return err
+	}
+	is.roots = append(is.roots, absroot)
+	return nil
+}
+
+// scan calls fn with the id and modtime of the images within root.
+// The files of a single directory are kept in memory to find sidecars.
+func (is *FileSystemImageSource) scan(root string, fn func(id string, mt time.Time) error) error {
+	files := make(map[string]time.Time) // files in the current directory
+	var images []string
+	flush := func() error {
+		for _, path := range images {
+			mt := files[path]
+			// use sidecar modtime if newer so that
+			// sidecar edits invalidate cached info
+			for _, sp := range xmp.SidecarNames(path) {
+				if smt, ok := files[sp]; ok {
+					if smt.After(mt) {
+						mt = smt
+					}
+					break
+				}
+			}
+			if err := fn(pathId(path), mt); err != nil {
+				return err
+			}
+		}
+		files = make(map[string]time.Time)
+		images = images[:0]
+		return nil
+	}
+	err := is.f.walk(root, "", func(path, rel string, fi os.FileInfo) error {
+		if fi.IsDir() {
+			// files of the previous directory are complete
+			return flush()
 		}
-		if info.IsDir() {
-			return nil
+		files[path] = fi.ModTime()
+		if !xmp.IsSidecar(path) && is.f.isImage(rel) {
+			images = append(images, path)
 		}
-		fp := filepath.ToSlash(path)
-		is.modTimes[fsprefix+fp] = info.ModTime()
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// modTime returns the modtime of the image at path
+// including its sidecar, if any. It reports false if
+// path is not a regular file or is not an image used by is.
+func (is *FileSystemImageSource) modTime(path string) (time.Time, bool) {
+	if !is.accept(path) {
+		return time.Time{}, false
+	}
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return time.Time{}, false
+	}
+	mt := fi.ModTime()
+	if _, sfi, ok := xmp.FindSidecar(path); ok && sfi.ModTime().After(mt) {
+		mt = sfi.ModTime()
+	}
+	return mt, true
+}
+
+func pathId(path string) string {
+	return fsprefix + filepath.ToSlash(path)
+}
+
+func idPath(id string) string {
+	return filepath.FromSlash(strings.TrimPrefix(id, fsprefix))
 }
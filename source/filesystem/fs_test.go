@@ -0,0 +1,91 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFiles creates the files in dir with their modtimes.
+func writeFiles(t *testing.T, dir string, files map[string]time.Time) {
+	for name, mt := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mt, mt); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "photomap-fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t0 := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Hour)
+	writeFiles(t, dir, map[string]time.Time{
+		"a.jpg":         t0,
+		"a.jpg.xmp":     t1,
+		"x/b.jpg":       t0,
+		"x/b.xmp":       t1,
+		"x/y/c.jpg":     t0,
+		"x/y/notes.txt": t1,
+	})
+
+	is, err := NewWithOptions(Options{Extensions: DefaultExtensions}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer is.Close()
+
+	got := make(map[string]time.Time)
+	err = is.List(context.Background(), func(id string, mt time.Time) error {
+		got[idPath(id)] = mt
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]time.Time{
+		"a.jpg":     t1, // sidecar is newer
+		"x/b.jpg":   t1,
+		"x/y/c.jpg": t0,
+	}
+	if len(got) != len(want) {
+		t.Errorf("listed %v, want %v", got, want)
+	}
+	for name, mt := range want {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if !got[p].Equal(mt) {
+			t.Errorf("%s modtime is %v, want %v", name, got[p], mt)
+		}
+	}
+
+	errStop := errors.New("stop")
+	n := 0
+	err = is.List(context.Background(), func(id string, mt time.Time) error {
+		n++
+		return errStop
+	})
+	if err != errStop || n != 1 {
+		t.Errorf("List returned %v after %d images, want %v after 1", err, n, errStop)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := is.List(ctx, func(string, time.Time) error { return nil }); err != context.Canceled {
+		t.Errorf("List with cancelled context returned %v", err)
+	}
+}
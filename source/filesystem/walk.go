@@ -0,0 +1,170 @@
+package fs
+
+import (
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/tajtiattila/photomap/source/xmp"
+)
+
+// walkFunc is called by walk for files and directories.
+// The slash separated path relative to the walk root is rel.
+// Walking stops if it returns an error.
+type walkFunc func(path, rel string, fi os.FileInfo) error
+
+type walker struct {
+	f     *filter
+	fn    walkFunc
+	stack []os.FileInfo // directories being walked
+}
+
+// walk calls fn for root and the regular files and directories
+// within it that are not skipped by f. The path of root relative
+// to the source root is rel. Symbolic links to directories
+// are followed if f.opt.FollowSymlinks is set, except ones that would
+// create loops. Symbolic links to files are always used.
+//
+// The files of a directory are visited right after the directory
+// itself, before its subdirectories. The first error returned
+// by fn is returned by walk.
+func (f *filter) walk(root, rel string, fn walkFunc) error {
+	fi, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	w := &walker{f: f, fn: fn}
+	if fi.IsDir() {
+		return w.dir(root, rel, fi, nil)
+	} else if fi.Mode().IsRegular() {
+		return fn(root, rel, fi)
+	}
+	return nil
+}
+
+func (w *walker) dir(dir, rel string, fi os.FileInfo, ign rules) error {
+	for _, a := range w.stack {
+		if os.SameFile(a, fi) {
+			log.Printf("%s: symlink loop", dir)
+			return nil
+		}
+	}
+	w.stack = append(w.stack, fi)
+	defer func() { w.stack = w.stack[:len(w.stack)-1] }()
+
+	if err := w.fn(dir, rel, fi); err != nil {
+		return err
+	}
+
+	ign = loadIgnore(dir, rel, ign)
+	f, err := os.Open(dir)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	list, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		log.Println(err)
+	}
+	type subdir struct {
+		p, r string
+		fi   os.FileInfo
+	}
+	var dirs []subdir
+	for _, e := range list {
+		p := filepath.Join(dir, e.Name())
+		r := path.Join(rel, e.Name())
+		if e.Mode()&os.ModeSymlink != 0 {
+			if e, err = os.Stat(p); err != nil {
+				log.Println(err)
+				continue
+			}
+			if e.IsDir() && !w.f.opt.FollowSymlinks {
+				continue
+			}
+		}
+		if w.f.skip(r, e.IsDir(), ign) {
+			continue
+		}
+		switch {
+		case e.IsDir():
+			dirs = append(dirs, subdir{p, r, e})
+		case e.Mode().IsRegular():
+			if err := w.fn(p, r, e); err != nil {
+				return err
+			}
+		}
+	}
+	for _, d := range dirs {
+		if err := w.dir(d.p, d.r, d.fi, ign); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accept reports whether the file at path
+// is an image within one of the roots of is.
+func (is *FileSystemImageSource) accept(p string) bool {
+	if xmp.IsSidecar(p) {
+		return false
+	}
+	root, rel, ok := is.rel(p)
+	return ok && is.f.accept(root, rel) && is.f.isImage(rel)
+}
+
+// rel returns the root of is having p, and
+// the slash separated path of p relative to it.
+func (is *FileSystemImageSource) rel(p string) (root, rel string, ok bool) {
+	for _, root := range is.roots {
+		if rel, ok := relPath(root, p); ok {
+			return root, rel, true
+		}
+	}
+	return "", "", false
+}
+
+// accept reports whether the file at rel within root is walked by f.
+func (f *filter) accept(root, rel string) bool {
+	var ign rules
+	dir := root
+	drel := ""
+	elems := strings.Split(rel, "/")
+	for i, name := range elems {
+		ign = loadIgnore(dir, drel, ign)
+		p := filepath.Join(dir, name)
+		r := path.Join(drel, name)
+		fi, err := os.Lstat(p)
+		if err != nil {
+			return false
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if fi, err = os.Stat(p); err != nil {
+				return false
+			}
+			if fi.IsDir() && !f.opt.FollowSymlinks {
+				return false
+			}
+		}
+		last := i == len(elems)-1
+		if fi.IsDir() == last || f.skip(r, fi.IsDir(), ign) {
+			return false
+		}
+		dir, drel = p, r
+	}
+	return true
+}
+
+// relPath returns the slash separated path of p relative to root,
+// and reports whether p is within root.
+func relPath(root, p string) (string, bool) {
+	rel, err := filepath.Rel(root, p)
+	if err != nil || rel == "." || rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
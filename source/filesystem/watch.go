@@ -0,0 +1,278 @@
+package fs
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/tajtiattila/photomap/source"
+	"github.com/tajtiattila/photomap/source/xmp"
+)
+
+// settleTime is the time to wait after the last event
+// before files are checked for changes, so that files
+// being copied are not reported in bursts. Files are
+// checked after maxSettleTime even if events keep coming,
+// such as when a large directory is copied.
+var (
+	settleTime    = 2 * time.Second
+	maxSettleTime = 30 * time.Second
+)
+
+type watcher struct {
+	is *FileSystemImageSource
+	fw *fsnotify.Watcher
+
+	ch   chan source.Change
+	done chan struct{}
+
+	// ignore files changed, rescan everything
+	needRescan bool
+
+	// images changed since the last listing
+	// before watching started, checked first
+	initial []string
+}
+
+// Watch implements source.Watcher using fsnotify.
+func (is *FileSystemImageSource) Watch() (<-chan source.Change, error) {
+	is.mtx.Lock()
+	defer is.mtx.Unlock()
+	if is.w != nil {
+		return nil, errors.New("fs: already watching")
+	}
+
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &watcher{
+		is:   is,
+		fw:   fw,
+		ch:   make(chan source.Change, 64),
+		done: make(chan struct{}),
+	}
+	for _, root := range is.roots {
+		if err := w.addDir(root); err != nil {
+			fw.Close()
+			return nil, err
+		}
+	}
+
+	// Images modified after the last listing started are checked first
+	// so that changes made before the watches were added are reported.
+	// Images removed meanwhile are only noticed on the next rescan.
+	known := make(map[string]time.Time)
+	for _, root := range is.roots {
+		err := is.scan(root, func(id string, mt time.Time) error {
+			if !mt.Before(is.listed) {
+				w.initial = append(w.initial, idPath(id))
+				mt = time.Time{}
+			}
+			known[id] = mt
+			return nil
+		})
+		if err != nil {
+			fw.Close()
+			return nil, err
+		}
+	}
+	is.modTimes = known
+	is.w = w
+	go w.run()
+	return w.ch, nil
+}
+
+func (w *watcher) close() error {
+	err := w.fw.Close()
+	<-w.done
+	return err
+}
+
+// addDir adds watches for dir and its subdirectories.
+// Directories skipped by the filter of the source are not watched.
+func (w *watcher) addDir(dir string) error {
+	_, rel, _ := w.is.rel(dir)
+	return w.is.f.walk(dir, rel, func(path, rel string, fi os.FileInfo) error {
+		if fi.IsDir() {
+			return w.fw.Add(path)
+		}
+		return nil
+	})
+}
+
+func (w *watcher) run() {
+	defer close(w.done)
+	defer close(w.ch)
+
+	for _, path := range w.initial {
+		w.check(path)
+	}
+	w.initial = nil
+
+	pending := make(map[string]struct{})
+	var first time.Time // time of first event not handled yet
+	timer := time.NewTimer(settleTime)
+	timer.Stop()
+	for {
+		select {
+		case ev, ok := <-w.fw.Events:
+			if !ok {
+				return
+			}
+			w.event(ev, pending)
+			if first.IsZero() {
+				first = time.Now()
+			}
+			d := settleTime
+			if left := maxSettleTime - time.Since(first); left < d {
+				d = left
+			}
+			timer.Reset(d)
+		case err, ok := <-w.fw.Errors:
+			if !ok {
+				return
+			}
+			log.Println("fs watch:", err)
+		case <-timer.C:
+			if w.needRescan {
+				for _, root := range w.is.roots {
+					if err := w.addDir(root); err != nil {
+						log.Println("fs watch:", err)
+					}
+				}
+				w.rescan()
+				w.needRescan = false
+			} else {
+				for path := range pending {
+					w.check(path)
+				}
+			}
+			pending = make(map[string]struct{})
+			first = time.Time{}
+		}
+	}
+}
+
+// event adds the paths to check because of ev to pending.
+func (w *watcher) event(ev fsnotify.Event, pending map[string]struct{}) {
+	path := ev.Name
+	if filepath.Base(path) == IgnoreFile {
+		w.needRescan = true
+		return
+	}
+	if ev.Op&fsnotify.Create != 0 {
+		if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+			// files may have been added before the watch
+			if err := w.addDir(path); err != nil {
+				log.Println("fs watch:", err)
+			}
+			_, rel, _ := w.is.rel(path)
+			w.is.f.walk(path, rel, func(p, rel string, fi os.FileInfo) error {
+				if !fi.IsDir() {
+					pending[p] = struct{}{}
+				}
+				return nil
+			})
+			return
+		}
+	}
+	if ev.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		// path may be a directory
+		pfx := pathId(path) + "/"
+		w.is.mtx.Lock()
+		for id := range w.is.modTimes {
+			if strings.HasPrefix(id, pfx) {
+				pending[idPath(id)] = struct{}{}
+			}
+		}
+		w.is.mtx.Unlock()
+	}
+	if xmp.IsSidecar(path) {
+		for _, p := range sidecarImages(path) {
+			pending[p] = struct{}{}
+		}
+		return
+	}
+	pending[path] = struct{}{}
+}
+
+// check updates the modtime of path, and reports the change if any.
+func (w *watcher) check(path string) {
+	id := pathId(path)
+	mt, ok := w.is.modTime(path)
+
+	w.is.mtx.Lock()
+	oldmt, had := w.is.modTimes[id]
+	var c source.Change
+	switch {
+	case ok && !had:
+		c = source.Change{Op: source.Added, Id: id, ModTime: mt}
+	case ok && !mt.Equal(oldmt):
+		c = source.Change{Op: source.Modified, Id: id, ModTime: mt}
+	case !ok && had:
+		c = source.Change{Op: source.Removed, Id: id}
+	default:
+		w.is.mtx.Unlock()
+		return
+	}
+	if ok {
+		w.is.modTimes[id] = mt
+	} else {
+		delete(w.is.modTimes, id)
+	}
+	w.is.mtx.Unlock()
+
+	w.ch <- c
+}
+
+// rescan checks all files within the roots, and the ones
+// known from the last scan.
+func (w *watcher) rescan() {
+	m := make(map[string]time.Time)
+	for _, root := range w.is.roots {
+		err := w.is.scan(root, func(id string, mt time.Time) error {
+			m[id] = mt
+			return nil
+		})
+		if err != nil {
+			log.Println("fs watch:", err)
+		}
+	}
+	w.is.mtx.Lock()
+	for id := range w.is.modTimes {
+		m[id] = time.Time{}
+	}
+	w.is.mtx.Unlock()
+	for id := range m {
+		w.check(idPath(id))
+	}
+}
+
+// sidecarImages returns the paths of images that may
+// use the sidecar at path.
+func sidecarImages(path string) []string {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+
+	// <file>.xmp
+	r := []string{base}
+
+	// <basename>.xmp
+	dir, name := filepath.Split(base)
+	f, err := os.Open(dir)
+	if err != nil {
+		return r
+	}
+	names, _ := f.Readdirnames(-1)
+	f.Close()
+	for _, n := range names {
+		if strings.TrimSuffix(n, filepath.Ext(n)) == name && !xmp.IsSidecar(n) {
+			r = append(r, filepath.Join(dir, n))
+		}
+	}
+	return r
+}
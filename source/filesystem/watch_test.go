@@ -0,0 +1,91 @@
+package fs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+func TestWatch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping watch test in short mode")
+	}
+	defer func(st, mst time.Duration) {
+		settleTime, maxSettleTime = st, mst
+	}(settleTime, maxSettleTime)
+	settleTime, maxSettleTime = 200*time.Millisecond, time.Second
+
+	dir, err := ioutil.TempDir("", "photomap-fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t0 := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	writeFiles(t, dir, map[string]time.Time{"a.jpg": t0, "x/b.jpg": t0})
+
+	is, err := NewWithOptions(Options{Extensions: DefaultExtensions}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer is.Close()
+	if err := is.List(context.Background(), func(string, time.Time) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	ch, err := is.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := func(name string) string {
+		return filepath.Join(dir, filepath.FromSlash(name))
+	}
+	expect := func(op source.ChangeOp, name string, within time.Duration) {
+		select {
+		case c := <-ch:
+			if c.Op != op || idPath(c.Id) != path(name) {
+				t.Errorf("got change %v of %s, want %v of %s", c.Op, idPath(c.Id), op, name)
+			}
+		case <-time.After(within):
+			t.Fatalf("no change %v of %s", op, name)
+		}
+	}
+
+	t1 := t0.Add(time.Hour)
+	writeFiles(t, dir, map[string]time.Time{"x/c.jpg": t0})
+	expect(source.Added, "x/c.jpg", 5*time.Second)
+
+	if err := os.Chtimes(path("a.jpg"), t1, t1); err != nil {
+		t.Fatal(err)
+	}
+	expect(source.Modified, "a.jpg", 5*time.Second)
+
+	if err := os.Remove(path("x/b.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	expect(source.Removed, "x/b.jpg", 5*time.Second)
+
+	// changes are reported after maxSettleTime
+	// even if events keep coming
+	stop := make(chan struct{})
+	defer close(stop)
+	tick := settleTime / 4
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(tick):
+			}
+			ioutil.WriteFile(path("busy.txt"), []byte{byte(i)}, 0644)
+		}
+	}()
+	time.Sleep(settleTime / 2)
+	writeFiles(t, dir, map[string]time.Time{"d.jpg": t0})
+	expect(source.Added, "d.jpg", 3*maxSettleTime)
+}
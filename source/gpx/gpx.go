@@ -0,0 +1,223 @@
+// Package gpx implements a track log based source.Locator
+// using GPX files.
+package gpx
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+// DefaultMaxGap is the default maximum time gap for TrackLog.
+const DefaultMaxGap = 10 * time.Minute
+
+// TrackLog is a source.Locator that interpolates
+// locations from track points in GPX files.
+type TrackLog struct {
+	// MaxGap is the maximum time difference between two
+	// consecutive track points, or a track point and the
+	// time to locate, for a fix to be reported.
+	MaxGap time.Duration
+
+	segs []*segment // sorted by start time
+}
+
+type segment struct {
+	name string // file#track.segment
+	pts  []point
+}
+
+type point struct {
+	t         time.Time
+	lat, long float64
+}
+
+func (s *segment) start() time.Time { return s.pts[0].t }
+func (s *segment) end() time.Time   { return s.pts[len(s.pts)-1].t }
+
+func init() {
+	// gpx:///path/to/tracks?maxgap=15m
+	source.RegisterLocatorScheme("gpx", func(u *url.URL) (source.Locator, error) {
+		tl, err := Load(source.FilePath(u))
+		if err != nil {
+			return nil, err
+		}
+		if s := u.Query().Get("maxgap"); s != "" {
+			if tl.MaxGap, err = time.ParseDuration(s); err != nil {
+				return nil, fmt.Errorf("gpx: invalid maxgap %q", s)
+			}
+		}
+		return tl, nil
+	})
+}
+
+// Load loads the GPX files in paths. Directories in paths are searched
+// recursively for files having the .gpx extension.
+func Load(paths ...string) (*TrackLog, error) {
+	tl := &TrackLog{MaxGap: DefaultMaxGap}
+	for _, p := range paths {
+		err := filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || (path != p && !strings.EqualFold(filepath.Ext(path), ".gpx")) {
+				return nil
+			}
+			return tl.loadFile(path)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Sort(segsByStart(tl.segs))
+	return tl, nil
+}
+
+// Len returns the number of track points in tl.
+func (tl *TrackLog) Len() int {
+	n := 0
+	for _, s := range tl.segs {
+		n += len(s.pts)
+	}
+	return n
+}
+
+// Locate implements source.Locator.
+func (tl *TrackLog) Locate(t time.Time) (source.Fix, bool) {
+	if t.IsZero() {
+		return source.Fix{}, false
+	}
+	var (
+		best     source.Fix
+		bestdist time.Duration
+		found    bool
+	)
+	for _, s := range tl.segs {
+		if t.Before(s.start().Add(-tl.MaxGap)) {
+			// segments are sorted by start time
+			break
+		}
+		if t.After(s.end().Add(tl.MaxGap)) {
+			continue
+		}
+		fix, dist, ok := s.locate(t, tl.MaxGap)
+		if ok && (!found || dist < bestdist) {
+			best, bestdist, found = fix, dist, true
+		}
+	}
+	return best, found
+}
+
+// locate returns the fix for t within s, along with the
+// time distance to the track point(s) used.
+func (s *segment) locate(t time.Time, maxgap time.Duration) (source.Fix, time.Duration, bool) {
+	// index of first point after t
+	i := sort.Search(len(s.pts), func(i int) bool {
+		return s.pts[i].t.After(t)
+	})
+
+	if i > 0 && i < len(s.pts) {
+		p0, p1 := s.pts[i-1], s.pts[i]
+		if gap := p1.t.Sub(p0.t); gap <= maxgap {
+			f := float64(t.Sub(p0.t)) / float64(gap)
+			return source.Fix{
+				Lat:    p0.lat + f*(p1.lat-p0.lat),
+				Long:   p0.long + f*(p1.long-p0.long),
+				Source: s.name,
+			}, 0, true
+		}
+	}
+
+	// use nearest point
+	var (
+		p    point
+		dist time.Duration
+		ok   bool
+	)
+	if i > 0 {
+		p, dist, ok = s.pts[i-1], t.Sub(s.pts[i-1].t), true
+	}
+	if i < len(s.pts) {
+		if d := s.pts[i].t.Sub(t); !ok || d < dist {
+			p, dist, ok = s.pts[i], d, true
+		}
+	}
+	if !ok || dist > maxgap {
+		return source.Fix{}, 0, false
+	}
+	return source.Fix{Lat: p.lat, Long: p.long, Source: s.name}, dist, true
+}
+
+func (tl *TrackLog) loadFile(fn string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := tl.load(filepath.Base(fn), f); err != nil {
+		return fmt.Errorf("gpx: %s: %v", fn, err)
+	}
+	return nil
+}
+
+func (tl *TrackLog) load(name string, r io.Reader) error {
+	var g gpxFile
+	if err := xml.NewDecoder(r).Decode(&g); err != nil {
+		return err
+	}
+	nskip := 0
+	for it, trk := range g.Tracks {
+		for is, seg := range trk.Segments {
+			s := &segment{name: fmt.Sprintf("%s#%d.%d", name, it, is)}
+			for _, p := range seg.Points {
+				if p.Time.IsZero() {
+					nskip++
+					continue
+				}
+				s.pts = append(s.pts, point{p.Time, p.Lat, p.Long})
+			}
+			if len(s.pts) == 0 {
+				continue
+			}
+			sort.Sort(ptsByTime(s.pts))
+			tl.segs = append(tl.segs, s)
+		}
+	}
+	if nskip != 0 {
+		log.Printf("gpx: %s: skipped %d track points without time", name, nskip)
+	}
+	return nil
+}
+
+type gpxFile struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []struct {
+				Lat  float64   `xml:"lat,attr"`
+				Long float64   `xml:"lon,attr"`
+				Time time.Time `xml:"time"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+type segsByStart []*segment
+
+func (s segsByStart) Len() int           { return len(s) }
+func (s segsByStart) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s segsByStart) Less(i, j int) bool { return s[i].start().Before(s[j].start()) }
+
+type ptsByTime []point
+
+func (s ptsByTime) Len() int           { return len(s) }
+func (s ptsByTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s ptsByTime) Less(i, j int) bool { return s[i].t.Before(s[j].t) }
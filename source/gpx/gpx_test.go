@@ -0,0 +1,66 @@
+package gpx
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+ <trk>
+  <trkseg>
+   <trkpt lat="47.0" lon="19.0"><time>2016-05-01T10:00:00Z</time></trkpt>
+   <trkpt lat="47.1" lon="19.2"><time>2016-05-01T10:01:00Z</time></trkpt>
+   <trkpt lat="47.2" lon="19.4"><time>2016-05-01T11:00:00Z</time></trkpt>
+  </trkseg>
+  <trkseg>
+   <trkpt lat="48.0" lon="20.0"><time>2016-05-02T10:00:00Z</time></trkpt>
+  </trkseg>
+ </trk>
+</gpx>`
+
+func TestLocate(t *testing.T) {
+	tl := &TrackLog{MaxGap: 5 * time.Minute}
+	if err := tl.load("test.gpx", strings.NewReader(testGPX)); err != nil {
+		t.Fatal(err)
+	}
+	if tl.Len() != 4 {
+		t.Fatalf("got %d track points, want 4", tl.Len())
+	}
+
+	tests := []struct {
+		t         string
+		ok        bool
+		lat, long float64
+		src       string
+	}{
+		{"2016-05-01T10:00:30Z", true, 47.05, 19.1, "test.gpx#0.0"},
+		{"2016-05-01T09:58:00Z", true, 47.0, 19.0, "test.gpx#0.0"},
+		{"2016-05-01T10:03:00Z", true, 47.1, 19.2, "test.gpx#0.0"},
+		{"2016-05-01T10:30:00Z", false, 0, 0, ""},
+		{"2016-05-02T10:04:00Z", true, 48.0, 20.0, "test.gpx#0.1"},
+		{"2016-05-03T10:00:00Z", false, 0, 0, ""},
+	}
+	for _, tt := range tests {
+		ts, err := time.Parse(time.RFC3339, tt.t)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fix, ok := tl.Locate(ts)
+		if ok != tt.ok {
+			t.Errorf("Locate(%s) ok = %v, want %v", tt.t, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if math.Abs(fix.Lat-tt.lat) > 1e-9 || math.Abs(fix.Long-tt.long) > 1e-9 {
+			t.Errorf("Locate(%s) = %v,%v, want %v,%v", tt.t, fix.Lat, fix.Long, tt.lat, tt.long)
+		}
+		if fix.Source != tt.src {
+			t.Errorf("Locate(%s) source = %q, want %q", tt.t, fix.Source, tt.src)
+		}
+	}
+}
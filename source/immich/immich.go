@@ -0,0 +1,337 @@
+// Package immich implements an image source for the
+// assets of an Immich server using its REST API.
+package immich
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+func init() {
+	// http://host:2283
+	source.Register("immich", func(spec string) (source.ImageSource, error) {
+		return NewSource(spec, os.Getenv("IMMICH_API_KEY"))
+	})
+	// immich://host:2283 over http, and immichs://host over https
+	for scheme, web := range map[string]string{"immich": "http", "immichs": "https"} {
+		web := web
+		source.RegisterScheme(scheme, func(u *url.URL) (source.ImageSource, error) {
+			return NewSource(web+"://"+u.Host+strings.TrimRight(u.Path, "/"), os.Getenv("IMMICH_API_KEY"))
+		})
+	}
+}
+
+var errNoLoc = errors.New("immich: asset has no location")
+
+// pageSize is the number of assets requested at once.
+const pageSize = 1000
+
+const immichprefix = "immich:"
+
+// Source is an image source for the assets of an Immich server.
+// The info of assets is taken from the metadata in Immich. Open
+// returns the preview size images made by the server for photos,
+// and the original files of videos. It implements source.Thumbnailer
+// using the previews, and source.Namer to report the file names.
+type Source struct {
+	base   string // server url
+	apiKey string
+	hc     *http.Client
+
+	assets   map[string]*asset
+	modTimes map[string]time.Time
+}
+
+// asset is an asset in search results.
+type asset struct {
+	Id               string    `json:"id"`
+	Type             string    `json:"type"` // IMAGE or VIDEO
+	OriginalFileName string    `json:"originalFileName"`
+	FileCreatedAt    time.Time `json:"fileCreatedAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+	Duration         string    `json:"duration"` // eg. 0:00:12.345000
+	ExifInfo         *struct {
+		Latitude         *float64    `json:"latitude"`
+		Longitude        *float64    `json:"longitude"`
+		ExifImageWidth   int         `json:"exifImageWidth"`
+		ExifImageHeight  int         `json:"exifImageHeight"`
+		Orientation      json.Number `json:"orientation"`
+		DateTimeOriginal *time.Time  `json:"dateTimeOriginal"`
+		TimeZone         string      `json:"timeZone"`
+		Rating           *int        `json:"rating"`
+
+		Make         string   `json:"make"`
+		Model        string   `json:"model"`
+		LensModel    string   `json:"lensModel"`
+		FocalLength  *float64 `json:"focalLength"`
+		FNumber      *float64 `json:"fNumber"`
+		ExposureTime string   `json:"exposureTime"` // eg. 1/250
+		ISO          *float64 `json:"iso"`
+	} `json:"exifInfo"`
+}
+
+// NewSource returns a new Source for the Immich server at
+// base, such as http://host:2283, using apiKey.
+func NewSource(base, apiKey string) (*Source, error) {
+	if apiKey == "" {
+		return nil, errors.New("immich: missing api key, set IMMICH_API_KEY")
+	}
+	s := &Source{
+		base:     strings.TrimRight(base, "/"),
+		apiKey:   apiKey,
+		hc:       http.DefaultClient,
+		assets:   make(map[string]*asset),
+		modTimes: make(map[string]time.Time),
+	}
+	return s, s.init()
+}
+
+func (s *Source) init() error {
+	page := "1"
+	for page != "" {
+		req := map[string]interface{}{
+			"page":     page,
+			"size":     pageSize,
+			"withExif": true,
+		}
+		var resp struct {
+			Assets struct {
+				Items    []*asset `json:"items"`
+				NextPage *string  `json:"nextPage"`
+			} `json:"assets"`
+		}
+		if err := s.call("POST", "/api/search/metadata", req, &resp); err != nil {
+			return err
+		}
+		for _, a := range resp.Assets.Items {
+			id := immichprefix + a.Id
+			s.assets[id] = a
+			s.modTimes[id] = a.UpdatedAt
+		}
+		page = ""
+		if resp.Assets.NextPage != nil {
+			page = *resp.Assets.NextPage
+		}
+	}
+	return nil
+}
+
+func (s *Source) ModTimes() map[string]time.Time {
+	return s.modTimes
+}
+
+// Info returns the info of the asset id from the metadata in Immich.
+func (s *Source) Info(id string) (source.ImageInfo, error) {
+	a, ok := s.assets[id]
+	if !ok {
+		return source.ImageInfo{}, os.ErrNotExist
+	}
+	ii := source.ImageInfo{CreateTime: a.FileCreatedAt, TimeSource: source.TimeModTime}
+	if a.Type == "VIDEO" {
+		ii.MediaType = source.MediaVideo
+		ii.Duration = parseDuration(a.Duration)
+	}
+	x := a.ExifInfo
+	if x == nil {
+		return ii, source.NoLoc(errNoLoc)
+	}
+	if x.DateTimeOriginal != nil {
+		ii.CreateTime, ii.TimeSource = *x.DateTimeOriginal, source.TimeMeta
+	}
+	ii.Width, ii.Height = x.ExifImageWidth, x.ExifImageHeight
+	if o, err := x.Orientation.Int64(); err == nil && o >= 5 && o <= 8 {
+		// rotated by 90 or 270 degrees
+		ii.Width, ii.Height = ii.Height, ii.Width
+	}
+	if x.Rating != nil {
+		ii.Rating = *x.Rating
+	}
+	ii.Camera = source.Camera{
+		Make:     x.Make,
+		Model:    x.Model,
+		Lens:     x.LensModel,
+		Exposure: parseExposure(x.ExposureTime),
+	}
+	if x.FocalLength != nil {
+		ii.Camera.FocalLength = *x.FocalLength
+	}
+	if x.FNumber != nil {
+		ii.Camera.Aperture = *x.FNumber
+	}
+	if x.ISO != nil {
+		ii.Camera.ISO = int(*x.ISO)
+	}
+	if loc, err := time.LoadLocation(x.TimeZone); x.TimeZone != "" && err == nil {
+		ii.CreateTime = ii.CreateTime.In(loc)
+	}
+	if x.Latitude == nil || x.Longitude == nil {
+		return ii, source.NoLoc(errNoLoc)
+	}
+	ii.Lat, ii.Long = *x.Latitude, *x.Longitude
+	if x.TimeZone == "" {
+		if loc := source.LookupLocation(ii.Lat, ii.Long); loc != nil {
+			ii.CreateTime = ii.CreateTime.In(loc)
+		}
+	}
+	return ii, nil
+}
+
+// InfoContext implements source.ContextSource. It makes
+// no requests, the metadata of assets is loaded by NewSource.
+func (s *Source) InfoContext(ctx context.Context, id string) (source.ImageInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return source.ImageInfo{}, err
+	}
+	return s.Info(id)
+}
+
+// Open returns the preview size image of the photo id,
+// or the original file of the video id.
+func (s *Source) Open(id string) (io.ReadCloser, error) {
+	return s.OpenContext(context.Background(), id)
+}
+
+// OpenContext implements source.ContextSource.
+func (s *Source) OpenContext(ctx context.Context, id string) (io.ReadCloser, error) {
+	a, ok := s.assets[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	if a.Type == "VIDEO" {
+		return s.get(ctx, a, "/original")
+	}
+	return s.get(ctx, a, "/thumbnail?size=preview")
+}
+
+// Thumbnail implements source.Thumbnailer using the preview size
+// JPEG images of the server regardless of size. Previews of videos
+// are frames of them.
+func (s *Source) Thumbnail(ctx context.Context, id string, size int) (io.ReadCloser, error) {
+	a, ok := s.assets[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return s.get(ctx, a, "/thumbnail?size=preview")
+}
+
+// Name implements source.Namer. It returns the original file name of
+// videos, and that of photos with the .jpg extension of previews.
+func (s *Source) Name(id string) string {
+	a, ok := s.assets[id]
+	if !ok || a.OriginalFileName == "" {
+		return ""
+	}
+	n := path.Base(a.OriginalFileName)
+	if a.Type == "VIDEO" {
+		return n
+	}
+	return strings.TrimSuffix(n, path.Ext(n)) + ".jpg"
+}
+
+// get returns the response body of the asset endpoint p of a.
+func (s *Source) get(ctx context.Context, a *asset, p string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, "GET", "/api/assets/"+url.PathEscape(a.Id)+p, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *Source) Close() error {
+	return nil
+}
+
+// call performs an API request with the JSON body req,
+// and decodes the JSON response into resp.
+func (s *Source) call(method, path string, req, resp interface{}) error {
+	var body io.Reader
+	if req != nil {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	r, err := s.do(context.Background(), method, path, body)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		return fmt.Errorf("immich: %s %s: %v", method, path, err)
+	}
+	return nil
+}
+
+func (s *Source) do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("x-api-key", s.apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		var e struct {
+			Message json.RawMessage `json:"message"`
+		}
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if json.Unmarshal(data, &e) == nil && len(e.Message) != 0 {
+			return nil, fmt.Errorf("immich: %s %s: %s: %s", method, path, resp.Status, e.Message)
+		}
+		return nil, fmt.Errorf("immich: %s %s: %s", method, path, resp.Status)
+	}
+	return resp, nil
+}
+
+// parseExposure parses exposure times in seconds
+// such as 1/250 or 0.5, and returns 0 if s is invalid.
+func parseExposure(s string) float64 {
+	n, d := s, "1"
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		n, d = s[:i], s[i+1:]
+	}
+	nv, err1 := strconv.ParseFloat(strings.TrimSpace(n), 64)
+	dv, err2 := strconv.ParseFloat(strings.TrimSpace(d), 64)
+	if err1 != nil || err2 != nil || dv == 0 {
+		return 0
+	}
+	return nv / dv
+}
+
+// parseDuration parses video durations such as 0:01:02.500000.
+func parseDuration(s string) time.Duration {
+	f := strings.Split(s, ":")
+	if len(f) != 3 {
+		return 0
+	}
+	h, err1 := strconv.Atoi(f[0])
+	m, err2 := strconv.Atoi(f[1])
+	sec, err3 := strconv.ParseFloat(f[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute +
+		time.Duration(sec*float64(time.Second))
+}
@@ -0,0 +1,143 @@
+package immich
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+func TestSource(t *testing.T) {
+	pages := map[string]string{
+		"1": `{"assets":{"items":[
+			{"id":"a1","type":"IMAGE","originalFileName":"IMG_0001.HEIC",
+			 "fileCreatedAt":"2016-07-01T08:00:00.000Z","updatedAt":"2016-07-02T08:00:00.000Z",
+			 "exifInfo":{"latitude":47.4979,"longitude":19.0402,"exifImageWidth":4000,"exifImageHeight":3000,
+			 "orientation":"6","dateTimeOriginal":"2016-07-01T07:59:00.000Z","timeZone":"Europe/Budapest","rating":4,
+			 "make":"Apple","model":"iPhone 7","lensModel":"iPhone 7 back camera 3.99mm f/1.8",
+			 "focalLength":3.99,"fNumber":1.8,"exposureTime":"1/250","iso":25}}
+		],"nextPage":"2"}}`,
+		"2": `{"assets":{"items":[
+			{"id":"v1","type":"VIDEO","originalFileName":"IMG_0002.MOV","fileCreatedAt":"2016-07-03T08:00:00.000Z","updatedAt":"2016-07-03T08:00:00.000Z",
+			 "duration":"0:01:02.500000","exifInfo":{"latitude":null,"longitude":null}}
+		],"nextPage":null}}`,
+	}
+	var previews int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("x-api-key") != "secret" {
+			http.Error(w, `{"message":"Invalid API key"}`, http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case req.Method == "POST" && req.URL.Path == "/api/search/metadata":
+			var q struct {
+				Page     string `json:"page"`
+				WithExif bool   `json:"withExif"`
+			}
+			json.NewDecoder(req.Body).Decode(&q)
+			if !q.WithExif {
+				t.Error("search without exif")
+			}
+			w.Write([]byte(pages[q.Page]))
+		case req.URL.Path == "/api/assets/a1/thumbnail" && req.URL.Query().Get("size") == "preview":
+			previews++
+			w.Write([]byte("preview"))
+		case req.URL.Path == "/api/assets/v1/thumbnail" && req.URL.Query().Get("size") == "preview":
+			w.Write([]byte("poster"))
+		case req.URL.Path == "/api/assets/v1/original":
+			w.Write([]byte("video"))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+
+	if _, err := NewSource(srv.URL, "wrong"); err == nil {
+		t.Error("invalid api key accepted")
+	}
+
+	s, err := NewSource(srv.URL, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	mts := s.ModTimes()
+	if len(mts) != 2 {
+		t.Fatalf("got %d assets, want 2", len(mts))
+	}
+
+	ii, err := s.Info("immich:a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ii.Lat != 47.4979 || ii.Long != 19.0402 {
+		t.Errorf("location is %v,%v", ii.Lat, ii.Long)
+	}
+	if ii.Width != 3000 || ii.Height != 4000 {
+		t.Errorf("size is %dx%d, want 3000x4000", ii.Width, ii.Height)
+	}
+	if want := time.Date(2016, 7, 1, 7, 59, 0, 0, time.UTC); !ii.CreateTime.Equal(want) {
+		t.Errorf("create time is %v, want %v", ii.CreateTime, want)
+	}
+	if ii.Rating != 4 {
+		t.Errorf("rating is %d, want 4", ii.Rating)
+	}
+	wantCamera := source.Camera{
+		Make:        "Apple",
+		Model:       "iPhone 7",
+		Lens:        "iPhone 7 back camera 3.99mm f/1.8",
+		FocalLength: 3.99,
+		Aperture:    1.8,
+		Exposure:    0.004,
+		ISO:         25,
+	}
+	if ii.Camera != wantCamera {
+		t.Errorf("camera is %+v, want %+v", ii.Camera, wantCamera)
+	}
+	if previews != 0 {
+		t.Error("info fetched image")
+	}
+
+	ii, err = s.Info("immich:v1")
+	if !source.IsNoLoc(err) {
+		t.Errorf("video info error is %v, want no location", err)
+	}
+	if ii.MediaType != source.MediaVideo || ii.Duration != 62500*time.Millisecond {
+		t.Errorf("video info is %+v", ii)
+	}
+
+	tests := []struct {
+		id, name    string
+		open, thumb string
+	}{
+		{"immich:a1", "IMG_0001.jpg", "preview", "preview"},
+		{"immich:v1", "IMG_0002.MOV", "video", "poster"},
+	}
+	read := func(rc io.ReadCloser, err error) string {
+		if err != nil {
+			t.Error(err)
+			return ""
+		}
+		defer rc.Close()
+		data, _ := ioutil.ReadAll(rc)
+		return string(data)
+	}
+	for _, tt := range tests {
+		if n := s.Name(tt.id); n != tt.name {
+			t.Errorf("%s: name is %q, want %q", tt.id, n, tt.name)
+		}
+		if got := read(s.Open(tt.id)); got != tt.open {
+			t.Errorf("%s: opened %q, want %q", tt.id, got, tt.open)
+		}
+		if got := read(s.Thumbnail(context.Background(), tt.id, 100)); got != tt.thumb {
+			t.Errorf("%s: thumbnail is %q, want %q", tt.id, got, tt.thumb)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+// Package lightroom implements an image source for the
+// images in Adobe Lightroom Classic catalogs.
+//
+// Catalogs are opened read-only, and the location, capture time,
+// rating and keywords in the catalog are used instead of those in the
+// image files, because Lightroom often has them only in the catalog.
+package lightroom
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+func init() {
+	source.Register("lightroom", func(catalog string) (source.ImageSource, error) {
+		return NewSource(catalog)
+	})
+	// lrcat:///path/catalog.lrcat?collection=Trips/2016
+	source.RegisterScheme("lrcat", func(u *url.URL) (source.ImageSource, error) {
+		return NewSource(source.FilePath(u), u.Query()["collection"]...)
+	})
+}
+
+const lrprefix = "lrcat:"
+
+// Source is an image source for the images in a Lightroom catalog.
+// It implements source.Collector to report the collections of images.
+type Source struct {
+	images   map[string]*image
+	modTimes map[string]time.Time
+}
+
+// image is an image in the catalog.
+type image struct {
+	path    string
+	capture time.Time // local time without zone, zero if unknown
+	rating  int
+	touched time.Time // time of last edit in the catalog
+
+	hasLoc    bool
+	lat, long float64
+
+	width, height int
+
+	collections []string
+	keywords    []string
+}
+
+// NewSource returns a new Source for the images in the catalog
+// at path. If collections are specified, only the images in those
+// collections or collection sets are used.
+func NewSource(catalog string, collections ...string) (*Source, error) {
+	abs, err := filepath.Abs(catalog)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(abs); err != nil {
+		return nil, err
+	}
+	dsn := (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs), RawQuery: "mode=ro"}).String()
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	s := &Source{
+		images:   make(map[string]*image),
+		modTimes: make(map[string]time.Time),
+	}
+	if err := s.load(db); err != nil {
+		return nil, err
+	}
+	if len(collections) != 0 {
+		s.filter(collections)
+	}
+	for id, im := range s.images {
+		mt := im.touched
+		if fi, err := os.Stat(im.path); err == nil && fi.ModTime().After(mt) {
+			mt = fi.ModTime()
+		}
+		s.modTimes[id] = mt
+	}
+	return s, nil
+}
+
+const imageQuery = `
+SELECT img.id_local, root.absolutePath, folder.pathFromRoot,
+	file.baseName, file.extension,
+	img.captureTime, img.rating, img.pick, img.touchTime,
+	img.orientation, img.fileWidth, img.fileHeight,
+	exif.hasGPS, exif.gpsLatitude, exif.gpsLongitude
+FROM Adobe_images img
+JOIN AgLibraryFile file ON img.rootFile = file.id_local
+JOIN AgLibraryFolder folder ON file.folder = folder.id_local
+JOIN AgLibraryRootFolder root ON folder.rootFolder = root.id_local
+LEFT JOIN AgHarvestedExifMetadata exif ON exif.image = img.id_local
+WHERE img.masterImage IS NULL`
+
+func (s *Source) load(db *sql.DB) error {
+	rows, err := db.Query(imageQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	byLocalId := make(map[int64]*image)
+	for rows.Next() {
+		var (
+			localId                 int64
+			root, folder, base, ext string
+			capture                 sql.NullString
+			rating, touch           sql.NullFloat64
+			pick                    sql.NullFloat64
+			orientation             sql.NullString
+			width, height           sql.NullFloat64
+			hasGPS                  sql.NullInt64
+			lat, long               sql.NullFloat64
+		)
+		err := rows.Scan(&localId, &root, &folder, &base, &ext,
+			&capture, &rating, &pick, &touch,
+			&orientation, &width, &height,
+			&hasGPS, &lat, &long)
+		if err != nil {
+			return err
+		}
+		name := base
+		if ext != "" {
+			name += "." + ext
+		}
+		if !source.HasImageExt(name) {
+			continue
+		}
+		im := &image{
+			path:   filepath.FromSlash(root + folder + name),
+			width:  int(width.Float64),
+			height: int(height.Float64),
+			rating: int(rating.Float64),
+		}
+		if pick.Valid && pick.Float64 < 0 {
+			im.rating = -1
+		}
+		if t, err := parseCaptureTime(capture.String); capture.Valid && err == nil {
+			im.capture = t
+		}
+		if touch.Valid {
+			im.touched = cocoaTime(touch.Float64)
+		}
+		switch orientation.String {
+		case "BC", "DA":
+			// rotated by 90 or 270 degrees
+			im.width, im.height = im.height, im.width
+		}
+		if hasGPS.Int64 != 0 && lat.Valid && long.Valid {
+			im.hasLoc, im.lat, im.long = true, lat.Float64, long.Float64
+		}
+		byLocalId[localId] = im
+		s.images[lrprefix+filepath.ToSlash(im.path)] = im
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := s.loadKeywords(db, byLocalId); err != nil {
+		return err
+	}
+	return s.loadCollections(db, byLocalId)
+}
+
+// loadKeywords sets the keywords of images. Keywords are named
+// without the keywords containing them in the keyword list.
+func (s *Source) loadKeywords(db *sql.DB, byLocalId map[int64]*image) error {
+	rows, err := db.Query(`
+SELECT ki.image, k.name
+FROM AgLibraryKeywordImage ki
+JOIN AgLibraryKeyword k ON ki.tag = k.id_local`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var iid int64
+		var name sql.NullString
+		if err := rows.Scan(&iid, &name); err != nil {
+			return err
+		}
+		if im := byLocalId[iid]; im != nil && name.String != "" {
+			im.keywords = append(im.keywords, name.String)
+		}
+	}
+	for _, im := range byLocalId {
+		sort.Strings(im.keywords)
+	}
+	return rows.Err()
+}
+
+// loadCollections sets the collections of images. Collections are
+// named by their path within collection sets, such as "Trips/2016".
+// Smart collections are not used, because their images are not
+// stored in the catalog.
+func (s *Source) loadCollections(db *sql.DB, byLocalId map[int64]*image) error {
+	type coll struct {
+		name   string
+		parent sql.NullInt64
+	}
+	colls := make(map[int64]coll)
+	rows, err := db.Query(`SELECT id_local, name, parent FROM AgLibraryCollection`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id int64
+		var c coll
+		var name sql.NullString
+		if err := rows.Scan(&id, &name, &c.parent); err != nil {
+			rows.Close()
+			return err
+		}
+		c.name = name.String
+		colls[id] = c
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	fullName := func(id int64) string {
+		var v []string
+		for i := 0; i < 64; i++ {
+			c, ok := colls[id]
+			if !ok {
+				break
+			}
+			v = append([]string{c.name}, v...)
+			if !c.parent.Valid {
+				break
+			}
+			id = c.parent.Int64
+		}
+		return strings.Join(v, "/")
+	}
+
+	rows, err = db.Query(`SELECT collection, image FROM AgLibraryCollectionImage`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, iid int64
+		if err := rows.Scan(&cid, &iid); err != nil {
+			return err
+		}
+		if im := byLocalId[iid]; im != nil {
+			im.collections = append(im.collections, fullName(cid))
+		}
+	}
+	for _, im := range byLocalId {
+		sort.Strings(im.collections)
+	}
+	return rows.Err()
+}
+
+// filter removes images not in collections, or in collections within them.
+func (s *Source) filter(collections []string) {
+	for id, im := range s.images {
+		if !inCollections(im.collections, collections) {
+			delete(s.images, id)
+		}
+	}
+}
+
+func inCollections(have, want []string) bool {
+	for _, h := range have {
+		for _, w := range want {
+			w = strings.Trim(w, "/")
+			if h == w || strings.HasPrefix(h, w+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *Source) ModTimes() map[string]time.Time {
+	return s.modTimes
+}
+
+// Info returns the info of the image id. The location, capture time,
+// rating and keywords in the catalog override those of the file. The catalog is used
+// alone if the file is not available, eg. because it is on an offline drive.
+func (s *Source) Info(id string) (source.ImageInfo, error) {
+	im, ok := s.images[id]
+	if !ok {
+		return source.ImageInfo{}, os.ErrNotExist
+	}
+	hook := func(ii *source.ImageInfo) bool {
+		if im.hasLoc {
+			ii.Lat, ii.Long = im.lat, im.long
+		}
+		if !im.capture.IsZero() {
+			loc := ii.CreateTime.Location()
+			if im.hasLoc {
+				if l := source.LookupLocation(im.lat, im.long); l != nil {
+					loc = l
+				}
+			}
+			c := im.capture
+			ii.CreateTime = time.Date(c.Year(), c.Month(), c.Day(),
+				c.Hour(), c.Minute(), c.Second(), c.Nanosecond(), loc)
+			ii.TimeSource = source.TimeMeta
+		}
+		ii.Rating = im.rating
+		ii.Keywords = im.keywords
+		return im.hasLoc
+	}
+
+	f, err := os.Open(im.path)
+	if err != nil {
+		ii := source.ImageInfo{
+			CreateTime: s.modTimes[id],
+			TimeSource: source.TimeModTime,
+			Width:      im.width,
+			Height:     im.height,
+		}
+		if !hook(&ii) {
+			return ii, source.NoLoc(errNoLoc)
+		}
+		return ii, nil
+	}
+	defer f.Close()
+	return source.InfoFromReader(s.modTimes[id], f, hook)
+}
+
+var errNoLoc = errors.New("lightroom: image has no location")
+
+func (s *Source) Open(id string) (io.ReadCloser, error) {
+	im, ok := s.images[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(im.path)
+}
+
+// Collections implements source.Collector.
+func (s *Source) Collections(id string) []string {
+	if im, ok := s.images[id]; ok {
+		return im.collections
+	}
+	return nil
+}
+
+func (s *Source) Close() error {
+	return nil
+}
+
+// cocoaEpoch is the epoch of timestamps in catalogs.
+var cocoaEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func cocoaTime(sec float64) time.Time {
+	return cocoaEpoch.Add(time.Duration(sec * float64(time.Second)))
+}
+
+// parseCaptureTime parses catalog capture times such as 2016-07-01T10:30:00.25.
+func parseCaptureTime(s string) (time.Time, error) {
+	for _, l := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.Parse(l, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("lightroom: invalid capture time " + s)
+}
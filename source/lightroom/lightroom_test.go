@@ -0,0 +1,124 @@
+package lightroom
+
+import (
+	"database/sql"
+	"fmt"
+	stdimage "image"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+const testSchema = `
+CREATE TABLE AgLibraryRootFolder (id_local INTEGER PRIMARY KEY, absolutePath, name);
+CREATE TABLE AgLibraryFolder (id_local INTEGER PRIMARY KEY, pathFromRoot, rootFolder);
+CREATE TABLE AgLibraryFile (id_local INTEGER PRIMARY KEY, baseName, extension, folder);
+CREATE TABLE Adobe_images (id_local INTEGER PRIMARY KEY, rootFile, masterImage,
+	captureTime, rating, pick, touchTime, orientation, fileWidth, fileHeight);
+CREATE TABLE AgHarvestedExifMetadata (id_local INTEGER PRIMARY KEY, image,
+	hasGPS, gpsLatitude, gpsLongitude);
+CREATE TABLE AgLibraryCollection (id_local INTEGER PRIMARY KEY, name, parent, creationId);
+CREATE TABLE AgLibraryCollectionImage (id_local INTEGER PRIMARY KEY, collection, image);
+CREATE TABLE AgLibraryKeyword (id_local INTEGER PRIMARY KEY, name, parent);
+CREATE TABLE AgLibraryKeywordImage (id_local INTEGER PRIMARY KEY, image, tag);
+
+INSERT INTO AgLibraryRootFolder VALUES (1, '%s/', 'Photos');
+INSERT INTO AgLibraryFolder VALUES (10, '2016/', 1);
+INSERT INTO AgLibraryFile VALUES (100, 'a', 'jpg', 10), (101, 'offline', 'NEF', 10), (102, 'notes', 'txt', 10);
+INSERT INTO Adobe_images VALUES
+	(1000, 100, NULL, '2016-07-01T10:30:00.25', 4, 0, 500000000, 'AB', 32, 24),
+	(1001, 101, NULL, '2016-07-02T11:00:00', NULL, -1, 500000000, 'BC', 6000, 4000),
+	(1002, 100, 1000, '2016-07-01T10:30:00', 5, 0, 500000000, 'AB', 32, 24),
+	(1003, 102, NULL, NULL, NULL, 0, 500000000, NULL, NULL, NULL);
+INSERT INTO AgHarvestedExifMetadata VALUES
+	(1, 1000, 1, 47.4979, 19.0402),
+	(2, 1001, 0, NULL, NULL);
+INSERT INTO AgLibraryCollection VALUES
+	(1, 'Trips', NULL, 'com.adobe.ag.library.group'),
+	(2, '2016', 1, 'com.adobe.ag.library.collection'),
+	(3, 'Family', NULL, 'com.adobe.ag.library.collection');
+INSERT INTO AgLibraryCollectionImage VALUES (1, 2, 1000), (2, 3, 1001);
+INSERT INTO AgLibraryKeyword VALUES (1, NULL, NULL), (2, 'Places', 1), (3, 'Budapest', 2), (4, 'bridge', 1);
+INSERT INTO AgLibraryKeywordImage VALUES (1, 1000, 4), (2, 1000, 3);
+`
+
+func TestSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lrcat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	photos := filepath.Join(dir, "Photos")
+	if err := os.MkdirAll(filepath.Join(photos, "2016"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(photos, "2016", "a.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpeg.Encode(f, stdimage.NewGray(stdimage.Rect(0, 0, 32, 24)), nil)
+	f.Close()
+
+	catalog := filepath.Join(dir, "test.lrcat")
+	db, err := sql.Open("sqlite3", catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(fmt.Sprintf(testSchema, filepath.ToSlash(photos)))
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSource(catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	idA := lrprefix + filepath.ToSlash(filepath.Join(photos, "2016", "a.jpg"))
+	idOff := lrprefix + filepath.ToSlash(filepath.Join(photos, "2016", "offline.NEF"))
+	if n := len(s.ModTimes()); n != 2 {
+		t.Fatalf("got %d images, want 2: %v", n, s.ModTimes())
+	}
+
+	ii, err := s.Info(idA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ii.Lat != 47.4979 || ii.Long != 19.0402 || ii.Rating != 4 {
+		t.Errorf("got %v,%v rating %d", ii.Lat, ii.Long, ii.Rating)
+	}
+	if kw := ii.Keywords; len(kw) != 2 || kw[0] != "Budapest" || kw[1] != "bridge" {
+		t.Errorf("keywords are %v, want [Budapest bridge]", kw)
+	}
+	// local time in the catalog
+	if got := ii.CreateTime.Format("2006-01-02 15:04:05.00"); got != "2016-07-01 10:30:00.25" {
+		t.Errorf("create time is %v, want 2016-07-01 10:30:00.25", got)
+	}
+	if got := s.Collections(idA); len(got) != 1 || got[0] != "Trips/2016" {
+		t.Errorf("collections are %v, want [Trips/2016]", got)
+	}
+
+	// offline file, info from catalog only
+	ii, err = s.Info(idOff)
+	if !source.IsNoLoc(err) {
+		t.Errorf("offline image error is %v, want no location", err)
+	}
+	if ii.Width != 4000 || ii.Height != 6000 || ii.Rating != -1 {
+		t.Errorf("offline image info is %+v", ii)
+	}
+
+	s, err = NewSource(catalog, "Trips")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.ModTimes()[idA]; !ok || len(s.ModTimes()) != 1 {
+		t.Errorf("images in Trips are %v, want %s", s.ModTimes(), idA)
+	}
+}
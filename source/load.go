@@ -3,7 +3,7 @@ package source
 import (
 	"bytes"
 	"image"
-	_ "image/jpeg"
+	"image/jpeg"
 	_ "image/png"
 	"io"
 	"log"
@@ -11,7 +11,23 @@ import (
 	"github.com/rwcarlsen/goexif/exif"
 )
 
+// LoadImage loads the image in r, and rotates it according
+// to its exif orientation. The largest embedded preview
+// is loaded from TIFF based RAW files, and the cover image
+// from videos. ErrNoPoster is returned for videos without one.
 func LoadImage(r io.Reader) (image.Image, error) {
+	ra, size, r, err := sniffRaw(r)
+	if err != nil {
+		return nil, err
+	}
+	if ra != nil {
+		return loadRaw(ra, size)
+	}
+	hdr, r := peek(r, 8)
+	if isMP4(hdr) {
+		return loadPoster(r)
+	}
+
 	buf := new(bytes.Buffer)
 	tr := io.TeeReader(r, buf)
 	x, xerr := exif.Decode(tr)
@@ -38,6 +54,29 @@ func LoadImage(r io.Reader) (image.Image, error) {
 		return im, nil
 	}
 
+	return orientImage(im, orient), nil
+}
+
+// loadRaw loads the largest preview of a RAW file. The orientation
+// of the RAW is used, because previews often have none of their own.
+func loadRaw(ra io.ReaderAt, size int64) (image.Image, error) {
+	f, err := parseRaw(ra, size)
+	if err != nil {
+		return nil, err
+	}
+	pr, _, err := f.preview()
+	if err != nil {
+		return nil, err
+	}
+	im, err := jpeg.Decode(pr)
+	if err != nil {
+		return nil, err
+	}
+	return orientImage(im, f.orientation), nil
+}
+
+// orientImage transforms im according to the exif orientation orient.
+func orientImage(im image.Image, orient int) image.Image {
 	// http://www.daveperrett.com/articles/2012/07/28/exif-orientation-handling-is-a-ghetto/
 	switch orient {
 	case 1:
@@ -57,8 +96,7 @@ func LoadImage(r io.Reader) (image.Image, error) {
 	case 8:
 		im = rotate(im, 90)
 	}
-
-	return im, nil
+	return im
 }
 
 func fliph(im image.Image) image.Image {
@@ -0,0 +1,33 @@
+package source
+
+import "time"
+
+// Fix is a location provided by a Locator.
+type Fix struct {
+	Lat  float64
+	Long float64
+
+	// Source describes where the fix comes from,
+	// such as the track and segment of a track log.
+	Source string
+}
+
+// Locator provides locations for images that have none embedded.
+type Locator interface {
+	// Locate returns the location at time t.
+	// It reports false if no location is known for t.
+	Locate(t time.Time) (Fix, bool)
+}
+
+// Locators is a Locator using the first
+// of its elements that can locate a time.
+type Locators []Locator
+
+func (ls Locators) Locate(t time.Time) (Fix, bool) {
+	for _, l := range ls {
+		if f, ok := l.Locate(t); ok {
+			return f, true
+		}
+	}
+	return Fix{}, false
+}
@@ -0,0 +1,390 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MP4 and MOV files are ISO base media files (ISO/IEC 14496-12)
+// made of nested boxes (atoms). The metadata is in the moov box,
+// that is read into memory while other top level boxes are skipped.
+
+// MediaVideo is the MediaType of videos.
+const MediaVideo = "video"
+
+// ErrNoPoster is returned by LoadImage for videos without a cover image.
+var ErrNoPoster = errors.New("video has no poster image")
+
+// maxMoovSize limits the size of moov boxes read into memory.
+const maxMoovSize = 64 << 20
+
+// isMP4 reports whether hdr is the start of an ISO base media file.
+func isMP4(hdr []byte) bool {
+	if len(hdr) < 8 {
+		return false
+	}
+	switch string(hdr[4:8]) {
+	case "ftyp", "moov", "wide", "free", "mdat", "skip":
+		return true
+	}
+	return false
+}
+
+// peek returns the first n bytes of r, and the reader to use instead of r.
+func peek(r io.Reader, n int) ([]byte, io.Reader) {
+	switch x := r.(type) {
+	case *bufio.Reader:
+		p, _ := x.Peek(n)
+		return p, x
+	case readSeekerAt:
+		if pos, err := x.Seek(0, io.SeekCurrent); err == nil {
+			p := make([]byte, n)
+			m, _ := x.ReadAt(p, pos)
+			return p[:m], r
+		}
+	}
+	br := bufio.NewReader(r)
+	p, _ := br.Peek(n)
+	return p, br
+}
+
+// videoMeta is the metadata of a video.
+type videoMeta struct {
+	created  time.Time // zero if unknown
+	duration time.Duration
+	width    int
+	height   int
+
+	hasLoc    bool
+	lat, long float64
+
+	poster []byte // cover image data, if any
+}
+
+type mp4Box struct {
+	typ  string
+	data []byte
+}
+
+// mp4Meta reads the metadata from the ISO base media file r.
+func mp4Meta(r io.Reader) (*videoMeta, error) {
+	var moov []byte
+	for moov == nil {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, errors.New("mp4: no moov box")
+			}
+			return nil, err
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[:]))
+		typ := string(hdr[4:])
+		hlen := int64(8)
+		if size == 1 {
+			var ext [8]byte
+			if _, err := io.ReadFull(r, ext[:]); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(ext[:]))
+			hlen = 16
+		}
+		if size == 0 && typ != "moov" {
+			return nil, errors.New("mp4: no moov box")
+		}
+		if size != 0 && size < hlen {
+			return nil, fmt.Errorf("mp4: invalid %q box size %d", typ, size)
+		}
+		if typ != "moov" {
+			if err := skip(r, size-hlen); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if size == 0 || size-hlen > maxMoovSize {
+			return nil, errors.New("mp4: moov box too large")
+		}
+		moov = make([]byte, size-hlen)
+		if _, err := io.ReadFull(r, moov); err != nil {
+			return nil, err
+		}
+	}
+
+	m := new(videoMeta)
+	m.parseMoov(moov)
+	if m.width == 0 || m.height == 0 {
+		return nil, errors.New("mp4: no video track")
+	}
+	return m, nil
+}
+
+func skip(r io.Reader, n int64) error {
+	if s, ok := r.(io.Seeker); ok {
+		_, err := s.Seek(n, io.SeekCurrent)
+		return err
+	}
+	_, err := io.CopyN(ioutil.Discard, r, n)
+	return err
+}
+
+// boxes returns the boxes in data.
+func boxes(data []byte) []mp4Box {
+	var v []mp4Box
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		hlen := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return v
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			hlen = 16
+		}
+		if size < hlen || size > uint64(len(data)) {
+			return v
+		}
+		v = append(v, mp4Box{typ, data[hlen:size]})
+		data = data[size:]
+	}
+	return v
+}
+
+func (m *videoMeta) parseMoov(moov []byte) {
+	var keys []string // keys of QuickTime meta box
+	for _, b := range boxes(moov) {
+		switch b.typ {
+		case "mvhd":
+			m.parseMvhd(b.data)
+		case "trak":
+			m.parseTrak(b.data)
+		case "udta":
+			m.parseUdta(b.data)
+		case "meta":
+			keys = m.parseMeta(b.data, keys)
+		}
+	}
+}
+
+// mp4Epoch is the epoch of times in ISO base media files.
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func (m *videoMeta) parseMvhd(d []byte) {
+	var created uint64
+	var scale uint32
+	var dur uint64
+	switch {
+	case len(d) >= 20 && d[0] == 0:
+		created = uint64(binary.BigEndian.Uint32(d[4:]))
+		scale = binary.BigEndian.Uint32(d[12:])
+		dur = uint64(binary.BigEndian.Uint32(d[16:]))
+	case len(d) >= 32 && d[0] == 1:
+		created = binary.BigEndian.Uint64(d[4:])
+		scale = binary.BigEndian.Uint32(d[20:])
+		dur = binary.BigEndian.Uint64(d[24:])
+	default:
+		return
+	}
+	if created != 0 && m.created.IsZero() {
+		m.created = mp4Epoch.Add(time.Duration(created) * time.Second)
+	}
+	if scale != 0 {
+		m.duration = time.Duration(float64(dur) / float64(scale) * float64(time.Second))
+	}
+}
+
+func (m *videoMeta) parseTrak(trak []byte) {
+	for _, b := range boxes(trak) {
+		switch b.typ {
+		case "tkhd":
+			m.parseTkhd(b.data)
+		case "udta":
+			m.parseUdta(b.data)
+		}
+	}
+}
+
+// parseTkhd reads the dimensions of the first video track.
+func (m *videoMeta) parseTkhd(d []byte) {
+	if m.width != 0 || len(d) < 1 {
+		return
+	}
+	// matrix and dimensions are at the end
+	var off int
+	switch d[0] {
+	case 0:
+		off = 40
+	case 1:
+		off = 52
+	default:
+		return
+	}
+	if len(d) < off+36+8 {
+		return
+	}
+	w := int(binary.BigEndian.Uint32(d[off+36:]) >> 16)
+	h := int(binary.BigEndian.Uint32(d[off+40:]) >> 16)
+	if w == 0 || h == 0 {
+		// audio track
+		return
+	}
+	a := int32(binary.BigEndian.Uint32(d[off:]))
+	b := int32(binary.BigEndian.Uint32(d[off+4:]))
+	if a == 0 && b != 0 {
+		// rotated by 90 or 270 degrees
+		w, h = h, w
+	}
+	m.width, m.height = w, h
+}
+
+func (m *videoMeta) parseUdta(udta []byte) {
+	for _, b := range boxes(udta) {
+		switch b.typ {
+		case "\xa9xyz":
+			// 16 bit length, 16 bit language, ISO 6709 string
+			if len(b.data) >= 4 {
+				n := int(binary.BigEndian.Uint16(b.data))
+				if s := b.data[4:]; n <= len(s) {
+					m.setISO6709(string(s[:n]))
+				}
+			}
+		case "meta":
+			m.parseMeta(b.data, nil)
+		}
+	}
+}
+
+// parseMeta reads a QuickTime metadata box, or an iTunes style metadata
+// box within udta. The keys of a meta box in moov are returned.
+func (m *videoMeta) parseMeta(meta []byte, keys []string) []string {
+	// iTunes style meta boxes are full boxes with version and flags
+	if len(meta) >= 8 && string(meta[4:8]) != "hdlr" {
+		meta = meta[4:]
+	}
+	for _, b := range boxes(meta) {
+		switch b.typ {
+		case "keys":
+			keys = parseKeys(b.data)
+		case "ilst":
+			for _, item := range boxes(b.data) {
+				var key string
+				if i := int(binary.BigEndian.Uint32([]byte(item.typ))); i >= 1 && i <= len(keys) {
+					key = keys[i-1]
+				} else {
+					key = item.typ
+				}
+				for _, db := range boxes(item.data) {
+					if db.typ == "data" && len(db.data) >= 8 {
+						m.setMeta(key, db.data[8:])
+					}
+				}
+			}
+		}
+	}
+	return keys
+}
+
+func parseKeys(d []byte) []string {
+	if len(d) < 8 {
+		return nil
+	}
+	n := int(binary.BigEndian.Uint32(d[4:]))
+	d = d[8:]
+	var keys []string
+	for i := 0; i < n && len(d) >= 8; i++ {
+		size := int(binary.BigEndian.Uint32(d))
+		if size < 8 || size > len(d) {
+			break
+		}
+		keys = append(keys, string(d[8:size]))
+		d = d[size:]
+	}
+	return keys
+}
+
+func (m *videoMeta) setMeta(key string, v []byte) {
+	switch key {
+	case "com.apple.quicktime.location.ISO6709", "\xa9xyz":
+		m.setISO6709(string(v))
+	case "com.apple.quicktime.creationdate":
+		for _, l := range []string{"2006-01-02T15:04:05-0700", time.RFC3339} {
+			if t, err := time.Parse(l, strings.TrimSpace(string(v))); err == nil {
+				m.created = t
+				break
+			}
+		}
+	case "covr":
+		m.poster = v
+	}
+}
+
+var iso6709Rx = regexp.MustCompile(`^([+-]\d+(?:\.\d*)?)([+-]\d+(?:\.\d*)?)`)
+
+// setISO6709 sets the location from an ISO 6709 string
+// in decimal degrees, such as "+47.4979+019.0402+110.000/".
+func (m *videoMeta) setISO6709(s string) {
+	sm := iso6709Rx.FindStringSubmatch(strings.TrimSpace(s))
+	if sm == nil {
+		return
+	}
+	lat, err1 := strconv.ParseFloat(sm[1], 64)
+	long, err2 := strconv.ParseFloat(sm[2], 64)
+	if err1 != nil || err2 != nil || lat < -90 || lat > 90 || long < -180 || long > 180 {
+		return
+	}
+	m.hasLoc, m.lat, m.long = true, lat, long
+}
+
+// videoInfo returns the info of the video in r.
+func videoInfo(mt time.Time, r io.Reader) (ImageInfo, error) {
+	m, err := mp4Meta(r)
+	if err != nil {
+		return ImageInfo{}, err
+	}
+	ii := ImageInfo{
+		MediaType:  MediaVideo,
+		CreateTime: mt,
+		TimeSource: TimeModTime,
+		Duration:   m.duration,
+		Width:      m.width,
+		Height:     m.height,
+	}
+	if !m.created.IsZero() {
+		ii.CreateTime, ii.TimeSource = m.created, TimeUTC
+	}
+	if !m.hasLoc {
+		return ii, &ErrNoLoc{errors.New("video has no location")}
+	}
+	ii.Lat, ii.Long = m.lat, m.long
+	if loc := LookupLocation(ii.Lat, ii.Long); loc != nil {
+		ii.CreateTime = ii.CreateTime.In(loc)
+	}
+	return ii, nil
+}
+
+// loadPoster loads the cover image of the video in r. Video frames
+// are not decoded, so the poster is available only in videos having
+// cover art in their metadata.
+func loadPoster(r io.Reader) (image.Image, error) {
+	m, err := mp4Meta(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.poster) == 0 {
+		return nil, ErrNoPoster
+	}
+	im, _, err := image.Decode(bytes.NewReader(m.poster))
+	return im, err
+}
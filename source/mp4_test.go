@@ -0,0 +1,86 @@
+package source
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func mp4TestBox(typ string, data ...[]byte) []byte {
+	body := bytes.Join(data, nil)
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b, uint32(8+len(body)))
+	copy(b[4:], typ)
+	return append(b, body...)
+}
+
+// testMP4 returns a minimal MP4 file having a single video track
+// and a location in the udta box.
+func testMP4(created time.Time) []byte {
+	be := binary.BigEndian
+
+	mvhd := make([]byte, 100)
+	be.PutUint32(mvhd[4:], uint32(created.Sub(mp4Epoch)/time.Second))
+	be.PutUint32(mvhd[12:], 600)    // timescale
+	be.PutUint32(mvhd[16:], 600*90) // duration
+
+	tkhd := make([]byte, 84)
+	be.PutUint32(tkhd[40:], 0x10000) // identity matrix
+	be.PutUint32(tkhd[56:], 0x10000)
+	be.PutUint32(tkhd[72:], 0x40000000)
+	be.PutUint32(tkhd[76:], 1920<<16)
+	be.PutUint32(tkhd[80:], 1080<<16)
+
+	loc := "+47.4979+019.0402+110.000/"
+	xyz := make([]byte, 4, 4+len(loc))
+	be.PutUint16(xyz, uint16(len(loc)))
+	xyz = append(xyz, loc...)
+
+	return bytes.Join([][]byte{
+		mp4TestBox("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41")),
+		mp4TestBox("mdat", make([]byte, 1000)),
+		mp4TestBox("moov",
+			mp4TestBox("mvhd", mvhd),
+			mp4TestBox("trak", mp4TestBox("tkhd", tkhd)),
+			mp4TestBox("udta", mp4TestBox("\xa9xyz", xyz)),
+		),
+	}, nil)
+}
+
+func TestVideoInfo(t *testing.T) {
+	created := time.Date(2016, 7, 1, 10, 30, 0, 0, time.UTC)
+	data := testMP4(created)
+
+	for _, seek := range []bool{true, false} {
+		var ii ImageInfo
+		var err error
+		if seek {
+			ii, err = InfoFromReader(time.Now(), bytes.NewReader(data))
+		} else {
+			ii, err = InfoFromReader(time.Now(), bytes.NewBuffer(data))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ii.MediaType != MediaVideo {
+			t.Errorf("media type is %q, want %q", ii.MediaType, MediaVideo)
+		}
+		if !ii.CreateTime.Equal(created) {
+			t.Errorf("create time is %v, want %v", ii.CreateTime, created)
+		}
+		if ii.Duration != 90*time.Second {
+			t.Errorf("duration is %v, want 90s", ii.Duration)
+		}
+		if ii.Width != 1920 || ii.Height != 1080 {
+			t.Errorf("size is %dx%d, want 1920x1080", ii.Width, ii.Height)
+		}
+		if ii.Lat != 47.4979 || ii.Long != 19.0402 {
+			t.Errorf("location is %v,%v, want 47.4979,19.0402", ii.Lat, ii.Long)
+		}
+	}
+
+	if _, err := LoadImage(bytes.NewReader(data)); err != ErrNoPoster {
+		t.Errorf("LoadImage error is %v, want ErrNoPoster", err)
+	}
+}
@@ -0,0 +1,385 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Multi is an ImageSource combining other sources.
+// Ids of images are prefixed with the label
+// of their source followed by a colon.
+type Multi struct {
+	srcs []*member
+	locs Locators
+}
+
+type member struct {
+	ImageSource
+	label string
+}
+
+// NewMulti returns a new Multi without sources.
+func NewMulti() *Multi {
+	return new(Multi)
+}
+
+// Add adds src to m with label. Labels must be unique
+// within m and must not contain colons.
+func (m *Multi) Add(label string, src ImageSource) error {
+	if label == "" || strings.Contains(label, ":") {
+		return fmt.Errorf("invalid source label %q", label)
+	}
+	if m.member(label) != nil {
+		return fmt.Errorf("duplicate source label %q", label)
+	}
+	m.srcs = append(m.srcs, &member{src, label})
+	return nil
+}
+
+// AddLocator adds l to the locators of m.
+func (m *Multi) AddLocator(l Locator) {
+	m.locs = append(m.locs, l)
+}
+
+// OpenURL opens the image source or locator specified by spec, and adds
+// it to m. The scheme of spec selects the source registered with
+// RegisterScheme or RegisterLocatorScheme, or else the one registered
+// with Register, which then gets the rest of spec after "scheme:".
+//
+// The label of image sources is taken from the "label" query parameter
+// of spec. It defaults to the last path element, or the host in spec.
+// Labels are part of image ids, so they are never chosen based on the
+// other sources of m: an error is returned if the default label is
+// already in use, and label must then be set explicitly.
+func (m *Multi) OpenURL(spec string) error {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" {
+		return fmt.Errorf("source %q has no scheme", spec)
+	}
+	q := u.Query()
+	label := q.Get("label")
+	if _, ok := q["label"]; ok {
+		q.Del("label")
+		u.RawQuery = q.Encode()
+	}
+
+	var src ImageSource
+	if f, ok := locSchemes[u.Scheme]; ok {
+		l, err := f(u)
+		if err != nil {
+			return err
+		}
+		m.AddLocator(l)
+		return nil
+	} else if f, ok := schemes[u.Scheme]; ok {
+		src, err = f(u)
+	} else if f, ok := sources[u.Scheme]; ok {
+		src, err = f(strings.TrimPrefix(u.String(), u.Scheme+":"))
+	} else {
+		return fmt.Errorf("unknown source scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return err
+	}
+
+	if label == "" {
+		label = defaultLabel(u)
+		if m.member(label) != nil {
+			src.Close()
+			return fmt.Errorf("source %q: label %q already in use, set another one with label=", spec, label)
+		}
+	}
+	if err := m.Add(label, src); err != nil {
+		src.Close()
+		return err
+	}
+	return nil
+}
+
+func defaultLabel(u *url.URL) string {
+	p := strings.TrimRight(u.Path, "/")
+	if p == "" {
+		p = u.Opaque
+	}
+	label := path.Base(p)
+	if p == "" || label == "/" || label == "." {
+		label = u.Host
+	}
+	if label == "" {
+		label = u.Scheme
+	}
+	return strings.Replace(label, ":", "_", -1)
+}
+
+// Labels returns the labels of the sources in m.
+func (m *Multi) Labels() []string {
+	var v []string
+	for _, s := range m.srcs {
+		v = append(v, s.label)
+	}
+	return v
+}
+
+// Locators returns the locators added to m.
+func (m *Multi) Locators() Locators {
+	return m.locs
+}
+
+// Label implements Labeler.
+func (m *Multi) Label(id string) string {
+	if s, _, ok := m.split(id); ok {
+		return s.label
+	}
+	return ""
+}
+
+// Aliases implements Aliaser. The id of an image without
+// the label of its source is the id it had before sources
+// were labelled, or in another Multi with different labels.
+func (m *Multi) Aliases(id string) []string {
+	s, sid, ok := m.split(id)
+	if !ok {
+		return nil
+	}
+	v := []string{sid}
+	if a, ok := s.ImageSource.(Aliaser); ok {
+		v = append(v, a.Aliases(sid)...)
+	}
+	return v
+}
+
+// Collections implements Collector.
+func (m *Multi) Collections(id string) []string {
+	s, id, ok := m.split(id)
+	if !ok {
+		return nil
+	}
+	if c, ok := s.ImageSource.(Collector); ok {
+		return c.Collections(id)
+	}
+	return nil
+}
+
+// Name implements Namer. It returns the empty string
+// if the source of id is not a Namer.
+func (m *Multi) Name(id string) string {
+	s, id, ok := m.split(id)
+	if !ok {
+		return ""
+	}
+	if n, ok := s.ImageSource.(Namer); ok {
+		return n.Name(id)
+	}
+	return ""
+}
+
+func (m *Multi) ModTimes() map[string]time.Time {
+	r := make(map[string]time.Time)
+	for _, s := range m.srcs {
+		for id, mt := range s.ModTimes() {
+			r[s.label+":"+id] = mt
+		}
+	}
+	return r
+}
+
+func (m *Multi) Info(id string) (ImageInfo, error) {
+	s, id, ok := m.split(id)
+	if !ok {
+		return ImageInfo{}, os.ErrNotExist
+	}
+	return s.Info(id)
+}
+
+func (m *Multi) Open(id string) (io.ReadCloser, error) {
+	s, id, ok := m.split(id)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return s.Open(id)
+}
+
+// List implements Lister by listing the sources of m one by one.
+func (m *Multi) List(ctx context.Context, f func(id string, modTime time.Time) error) error {
+	for _, s := range m.srcs {
+		label := s.label
+		err := Adapt(s.ImageSource).List(ctx, func(id string, mt time.Time) error {
+			return f(label+":"+id, mt)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InfoContext implements ContextSource.
+func (m *Multi) InfoContext(ctx context.Context, id string) (ImageInfo, error) {
+	s, id, ok := m.split(id)
+	if !ok {
+		return ImageInfo{}, os.ErrNotExist
+	}
+	return Adapt(s.ImageSource).Info(ctx, id)
+}
+
+// OpenContext implements ContextSource.
+func (m *Multi) OpenContext(ctx context.Context, id string) (io.ReadCloser, error) {
+	s, id, ok := m.split(id)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return Adapt(s.ImageSource).Open(ctx, id)
+}
+
+// InfoMany implements InfoBatcher by passing
+// the ids of each source to it in a single batch.
+func (m *Multi) InfoMany(ctx context.Context, ids []string) ([]ImageInfo, []error) {
+	infos := make([]ImageInfo, len(ids))
+	errs := make([]error, len(ids))
+	type batch struct {
+		ids []string
+		idx []int
+	}
+	batches := make(map[*member]*batch)
+	var order []*member
+	for i, id := range ids {
+		s, sid, ok := m.split(id)
+		if !ok {
+			errs[i] = os.ErrNotExist
+			continue
+		}
+		b := batches[s]
+		if b == nil {
+			b = new(batch)
+			batches[s] = b
+			order = append(order, s)
+		}
+		b.ids = append(b.ids, sid)
+		b.idx = append(b.idx, i)
+	}
+	for _, s := range order {
+		b := batches[s]
+		vi, ve := Adapt(s.ImageSource).(InfoBatcher).InfoMany(ctx, b.ids)
+		for j, i := range b.idx {
+			infos[i], errs[i] = vi[j], ve[j]
+		}
+	}
+	return infos, errs
+}
+
+// Thumbnail implements Thumbnailer. It returns ErrNotSupported
+// if the source of id is not a Thumbnailer.
+func (m *Multi) Thumbnail(ctx context.Context, id string, size int) (io.ReadCloser, error) {
+	s, id, ok := m.split(id)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	t, ok := s.ImageSource.(Thumbnailer)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return t.Thumbnail(ctx, id, size)
+}
+
+// WriteInfo implements Writer. It returns ErrNotSupported
+// if the source of id is not a Writer.
+func (m *Multi) WriteInfo(id string, e Edit) (time.Time, error) {
+	s, id, ok := m.split(id)
+	if !ok {
+		return time.Time{}, os.ErrNotExist
+	}
+	w, ok := s.ImageSource.(Writer)
+	if !ok {
+		return time.Time{}, ErrNotSupported
+	}
+	return w.WriteInfo(id, e)
+}
+
+// Watch implements Watcher by watching the sources of m that are
+// Watchers. It returns ErrNotSupported if there are none.
+func (m *Multi) Watch() (<-chan Change, error) {
+	type watched struct {
+		label string
+		ch    <-chan Change
+	}
+	var ws []watched
+	for _, s := range m.srcs {
+		w, ok := s.ImageSource.(Watcher)
+		if !ok {
+			log.Printf("source %q can't be watched for changes", s.label)
+			continue
+		}
+		ch, err := w.Watch()
+		if err == ErrNotSupported {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("source %q: %v", s.label, err)
+		}
+		ws = append(ws, watched{s.label, ch})
+	}
+	if len(ws) == 0 {
+		return nil, ErrNotSupported
+	}
+
+	out := make(chan Change, 64)
+	var wg sync.WaitGroup
+	for _, w := range ws {
+		wg.Add(1)
+		go func(label string, ch <-chan Change) {
+			defer wg.Done()
+			for c := range ch {
+				c.Id = label + ":" + c.Id
+				out <- c
+			}
+		}(w.label, w.ch)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out, nil
+}
+
+func (m *Multi) Close() error {
+	var err error
+	for _, s := range m.srcs {
+		if e := s.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (m *Multi) member(label string) *member {
+	for _, s := range m.srcs {
+		if s.label == label {
+			return s
+		}
+	}
+	return nil
+}
+
+// split returns the source of id in m, and the id within the source.
+func (m *Multi) split(id string) (*member, string, bool) {
+	i := strings.IndexByte(id, ':')
+	if i < 0 {
+		return nil, "", false
+	}
+	s := m.member(id[:i])
+	if s == nil {
+		return nil, "", false
+	}
+	return s, id[i+1:], true
+}
@@ -0,0 +1,89 @@
+package source
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testSource map[string]time.Time
+
+func (s testSource) ModTimes() map[string]time.Time { return s }
+func (s testSource) Open(id string) (io.ReadCloser, error) {
+	return nil, os.ErrNotExist
+}
+func (s testSource) Close() error { return nil }
+func (s testSource) Info(id string) (ImageInfo, error) {
+	if _, ok := s[id]; !ok {
+		return ImageInfo{}, os.ErrNotExist
+	}
+	return ImageInfo{Lat: 1, Long: 2}, nil
+}
+
+func TestMulti(t *testing.T) {
+	RegisterScheme("multitest", func(u *url.URL) (ImageSource, error) {
+		return testSource{u.Path: time.Unix(1, 0)}, nil
+	})
+
+	m := NewMulti()
+	specs := []string{
+		"multitest:///a/b",
+		"multitest:///c/b?label=cb",
+		"multitest:///x?label=mine",
+	}
+	for _, s := range specs {
+		if err := m.OpenURL(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Add("mine", testSource{}); err == nil {
+		t.Error("duplicate label accepted")
+	}
+	if err := m.OpenURL("multitest:///d/b"); err == nil {
+		t.Error("duplicate default label accepted")
+	}
+
+	mt := m.ModTimes()
+	for _, id := range []string{"b:/a/b", "cb:/c/b", "mine:/x"} {
+		if _, ok := mt[id]; !ok {
+			t.Errorf("%s missing from %v", id, mt)
+		}
+		if _, err := m.Info(id); err != nil {
+			t.Errorf("info %s: %v", id, err)
+		}
+	}
+	if len(mt) != 3 {
+		t.Errorf("got %d ids, want 3", len(mt))
+	}
+	if l := m.Label("cb:/c/b"); l != "cb" {
+		t.Errorf("label is %q, want cb", l)
+	}
+	if a := m.Aliases("cb:/c/b"); len(a) != 1 || a[0] != "/c/b" {
+		t.Errorf("aliases are %q, want [/c/b]", a)
+	}
+	if _, err := m.WriteInfo("mine:/x", Edit{}); err != ErrNotSupported {
+		t.Errorf("write info: %v", err)
+	}
+}
+
+func TestFilePath(t *testing.T) {
+	tests := map[string]string{
+		"file:///photos/a":   "/photos/a",
+		"file:rel/dir":       "rel/dir",
+		"file:///C:/photos":  "C:/photos",
+		"file://localhost/p": "/p",
+		"file:///a%20b":      "/a b",
+	}
+	for spec, want := range tests {
+		u, err := url.Parse(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := FilePath(u); got != filepath.FromSlash(want) {
+			t.Errorf("%s: got %q, want %q", spec, got, want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Package plugin implements image sources served by external programs,
+// so that sources can be written in any language.
+//
+// The plugin is started as a child process, and requests are sent to
+// it as JSON objects on its standard input, one per line. The plugin
+// answers each with a JSON object on a line on its standard output:
+//
+//	→ {"id":1,"method":"hello","params":{"version":1}}
+//	← {"id":1,"result":{"version":1,"name":"archive"}}
+//	→ {"id":2,"method":"list"}
+//	← {"id":2,"result":{"images":[{"id":"a","modtime":"2016-07-01T10:30:00Z"}]}}
+//	→ {"id":3,"method":"info","params":{"id":"a"}}
+//	← {"id":3,"result":{"lat":47.49,"long":19.04,"createTime":"2016-07-01T10:30:00+02:00","width":4000,"height":3000}}
+//	→ {"id":4,"method":"open","params":{"id":"a"}}
+//	← {"id":4,"chunk":"/9j/4AAQ..."}
+//	← {"id":4,"result":{}}
+//	→ {"id":5,"method":"close"}
+//	← {"id":5,"result":{}}
+//
+// Failed requests are answered with {"id":N,"error":"message"}.
+//
+// The result of info may have "readImage":true instead of the metadata,
+// to have photomap read the metadata from the image itself. Locations
+// and times present in such results override those in the image.
+// An info result without location reports an image without one.
+//
+// The result of open is either the path of a local file as
+// {"path":"/photos/a.jpg"}, or empty after the contents of the
+// image have been sent in base64 encoded chunks. Chunks are read
+// as the image is read, and a request times out only if nothing
+// is sent for it within the timeout.
+//
+// A plugin that crashes, times out or sends invalid messages is
+// restarted for the next request. The plugin should exit
+// after close, or when its standard input is closed.
+package plugin
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+// ProtocolVersion is the version of the protocol spoken with plugins.
+const ProtocolVersion = 1
+
+// DefaultTimeout is the default timeout of requests.
+const DefaultTimeout = 30 * time.Second
+
+func init() {
+	// command line of the plugin
+	source.Register("exec", func(cmdline string) (source.ImageSource, error) {
+		f := strings.Fields(cmdline)
+		if len(f) == 0 {
+			return nil, errors.New("plugin: missing command")
+		}
+		return NewSource(f[0], f[1:], DefaultTimeout)
+	})
+	// exec:///path/plugin.py?arg=--archive&arg=main&timeout=1m
+	source.RegisterScheme("exec", func(u *url.URL) (source.ImageSource, error) {
+		q := u.Query()
+		timeout := DefaultTimeout
+		if v := q.Get("timeout"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, err
+			}
+			timeout = d
+		}
+		return NewSource(source.FilePath(u), q["arg"], timeout)
+	})
+}
+
+// Source is an image source served by a plugin.
+type Source struct {
+	c        *conn
+	modTimes map[string]time.Time
+}
+
+// NewSource starts the plugin name with args, and returns the
+// source listing its images. Requests to the plugin failing to
+// answer or send the next chunk within timeout fail, and the
+// plugin is restarted.
+func NewSource(name string, args []string, timeout time.Duration) (*Source, error) {
+	s := &Source{
+		c:        &conn{name: name, args: args, timeout: timeout},
+		modTimes: make(map[string]time.Time),
+	}
+	var list struct {
+		Images []struct {
+			Id      string    `json:"id"`
+			ModTime time.Time `json:"modtime"`
+		} `json:"images"`
+	}
+	if err := s.c.call("list", nil, &list); err != nil {
+		s.c.close()
+		return nil, err
+	}
+	for _, im := range list.Images {
+		s.modTimes[im.Id] = im.ModTime
+	}
+	return s, nil
+}
+
+func (s *Source) ModTimes() map[string]time.Time {
+	return s.modTimes
+}
+
+type idParams struct {
+	Id string `json:"id"`
+}
+
+// info is the result of info.
+type info struct {
+	ReadImage  bool       `json:"readImage"`
+	Type       string     `json:"type"`
+	CreateTime *time.Time `json:"createTime"`
+	Duration   float64    `json:"duration"` // seconds
+	Width      int        `json:"width"`
+	Height     int        `json:"height"`
+	Lat        *float64   `json:"lat"`
+	Long       *float64   `json:"long"`
+	LocSource  string     `json:"locSource"`
+	Rating     int        `json:"rating"`
+}
+
+var errNoLoc = errors.New("plugin: image has no location")
+
+func (s *Source) Info(id string) (source.ImageInfo, error) {
+	mt, ok := s.modTimes[id]
+	if !ok {
+		return source.ImageInfo{}, os.ErrNotExist
+	}
+	var r info
+	if err := s.c.call("info", idParams{id}, &r); err != nil {
+		return source.ImageInfo{}, err
+	}
+	hasLoc := r.Lat != nil && r.Long != nil
+
+	if r.ReadImage {
+		rc, err := s.Open(id)
+		if err != nil {
+			return source.ImageInfo{}, err
+		}
+		defer rc.Close()
+		return source.InfoFromReader(mt, rc, func(ii *source.ImageInfo) bool {
+			if r.CreateTime != nil {
+				ii.CreateTime = *r.CreateTime
+			}
+			if hasLoc {
+				ii.Lat, ii.Long, ii.LocSource = *r.Lat, *r.Long, r.LocSource
+			}
+			return hasLoc
+		})
+	}
+
+	ii := source.ImageInfo{
+		CreateTime: mt,
+		TimeSource: source.TimeModTime,
+		Duration:   time.Duration(r.Duration * float64(time.Second)),
+		Width:      r.Width,
+		Height:     r.Height,
+		LocSource:  r.LocSource,
+		Rating:     r.Rating,
+	}
+	if r.Type == source.MediaVideo {
+		ii.MediaType = source.MediaVideo
+	}
+	if r.CreateTime != nil {
+		ii.CreateTime, ii.TimeSource = *r.CreateTime, source.TimeMeta
+	}
+	if !hasLoc {
+		return ii, source.NoLoc(errNoLoc)
+	}
+	ii.Lat, ii.Long = *r.Lat, *r.Long
+	return ii, nil
+}
+
+// Open returns the image id. Images sent by the plugin in chunks
+// are streamed, and other requests to the plugin wait until the
+// image is read to the end or closed.
+func (s *Source) Open(id string) (io.ReadCloser, error) {
+	if _, ok := s.modTimes[id]; !ok {
+		return nil, os.ErrNotExist
+	}
+	var r struct {
+		Path string `json:"path"`
+	}
+	rc, err := s.c.stream("open", idParams{id}, &r)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case rc != nil:
+		return rc, nil
+	case r.Path != "":
+		return os.Open(r.Path)
+	}
+	// empty image
+	return ioutil.NopCloser(bytes.NewReader(nil)), nil
+}
+
+// Close sends close to the plugin, and waits for it to exit.
+func (s *Source) Close() error {
+	return s.c.close()
+}
@@ -0,0 +1,147 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+// TestHelperProcess is not a real test. It is run
+// as the plugin by the other tests.
+func TestHelperProcess(t *testing.T) {
+	crashFile := os.Getenv("PHOTOMAP_TEST_PLUGIN")
+	if crashFile == "" {
+		return
+	}
+	defer os.Exit(0)
+
+	sc := bufio.NewScanner(os.Stdin)
+	enc := json.NewEncoder(os.Stdout)
+	for sc.Scan() {
+		var req struct {
+			Id     int64
+			Method string
+			Params struct {
+				Version int
+				Id      string
+			}
+		}
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		reply := func(result interface{}) {
+			enc.Encode(map[string]interface{}{"id": req.Id, "result": result})
+		}
+		switch req.Method {
+		case "hello":
+			reply(map[string]interface{}{"version": req.Params.Version, "name": "test"})
+		case "list":
+			reply(map[string]interface{}{"images": []map[string]string{
+				{"id": "a", "modtime": "2016-07-01T10:30:00Z"},
+				{"id": "b", "modtime": "2016-07-02T10:30:00Z"},
+			}})
+		case "info":
+			if req.Params.Id == "b" {
+				// crash once
+				if _, err := os.Stat(crashFile); err != nil {
+					ioutil.WriteFile(crashFile, nil, 0666)
+					os.Exit(2)
+				}
+				enc.Encode(map[string]interface{}{"id": req.Id, "error": "broken image"})
+				continue
+			}
+			reply(map[string]interface{}{
+				"lat": 47.5, "long": 19.0,
+				"createTime": "2016-07-01T12:30:00+02:00",
+				"width":      4000, "height": 3000,
+			})
+		case "open":
+			enc.Encode(map[string]interface{}{"id": req.Id, "chunk": []byte("hello ")})
+			enc.Encode(map[string]interface{}{"id": req.Id, "chunk": []byte("world")})
+			reply(struct{}{})
+		case "close":
+			reply(struct{}{})
+			return
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("PHOTOMAP_TEST_PLUGIN", filepath.Join(dir, "crashed"))
+	defer os.Unsetenv("PHOTOMAP_TEST_PLUGIN")
+
+	s, err := NewSource(os.Args[0], []string{"-test.run=TestHelperProcess"}, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if n := len(s.ModTimes()); n != 2 {
+		t.Fatalf("got %d images, want 2", n)
+	}
+
+	ii, err := s.Info("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ii.Lat != 47.5 || ii.Long != 19.0 || ii.Width != 4000 || ii.Height != 3000 {
+		t.Errorf("info is %+v", ii)
+	}
+	if want := time.Date(2016, 7, 1, 10, 30, 0, 0, time.UTC); !ii.CreateTime.Equal(want) {
+		t.Errorf("create time is %v, want %v", ii.CreateTime, want)
+	}
+
+	// plugin crashes, and is restarted for the retry
+	_, err = s.Info("b")
+	if e, ok := err.(*Error); !ok || e.Msg != "broken image" {
+		t.Errorf("info error is %v, want broken image", err)
+	}
+
+	rc, err := s.Open("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if string(data) != "hello world" {
+		t.Errorf("opened %q, want hello world", data)
+	}
+
+	// the rest of the image is skipped on close
+	rc, err = s.Open("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 3)
+	n, err := rc.Read(buf)
+	if err := rc.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+	if err != nil || string(buf[:n]) != "hel" {
+		t.Errorf("read %q, %v; want hel", buf[:n], err)
+	}
+	if _, err := rc.Read(buf); err == nil {
+		t.Error("read after close succeeded")
+	}
+	if _, err := s.Info("a"); err != nil {
+		t.Errorf("info after partial read: %v", err)
+	}
+
+	if _, err := s.Info("missing"); !os.IsNotExist(err) {
+		t.Errorf("missing image error is %v", err)
+	}
+	var _ source.ImageSource = s
+}
@@ -0,0 +1,393 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// maxLine limits the size of messages from plugins.
+const maxLine = 16 << 20
+
+var (
+	errTimeout    = errors.New("plugin: request timed out")
+	errReadClosed = errors.New("plugin: read after close")
+)
+
+// request is a message to the plugin.
+type request struct {
+	Id     int64       `json:"id"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params,omitempty"`
+}
+
+// response is a message from the plugin. Responses to open
+// may be preceded by messages having only Chunk set.
+type response struct {
+	Id     int64           `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Chunk  []byte          `json:"chunk,omitempty"` // base64 in JSON
+}
+
+// Error is an error reported by the plugin.
+type Error struct {
+	Method string
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("plugin: %s: %s", e.Method, e.Msg)
+}
+
+// proc is a running plugin process.
+type proc struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	msgs  chan response // closed when stdout is closed
+	err   error         // read error, valid after msgs is closed
+}
+
+func startProc(name string, args []string) (*proc, error) {
+	cmd := exec.Command(name, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	p := &proc{cmd: cmd, stdin: stdin, msgs: make(chan response)}
+	go p.read(stdout)
+	go logStderr(name, stderr)
+	return p, nil
+}
+
+func (p *proc) read(r io.Reader) {
+	defer close(p.msgs)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), maxLine)
+	for sc.Scan() {
+		var m response
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			p.err = fmt.Errorf("plugin: invalid message: %v", err)
+			return
+		}
+		p.msgs <- m
+	}
+	p.err = sc.Err()
+	if p.err == nil {
+		p.err = errors.New("plugin: process exited")
+	}
+}
+
+func logStderr(name string, r io.Reader) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		log.Printf("plugin %s: %s", name, sc.Text())
+	}
+}
+
+// send sends req to the plugin.
+func (p *proc) send(req request) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	_, err = p.stdin.Write(append(data, '\n'))
+	return err
+}
+
+// recv returns the next message from the plugin having id. Messages
+// of earlier requests that have timed out are dropped.
+func (p *proc) recv(id int64, timer <-chan time.Time) (response, error) {
+	for {
+		select {
+		case m, ok := <-p.msgs:
+			if !ok {
+				return response{}, p.err
+			}
+			if m.Id == id {
+				return m, nil
+			}
+		case <-timer:
+			return response{}, errTimeout
+		}
+	}
+}
+
+// stop asks the process to exit by closing its stdin,
+// and kills it if it is still running after timeout.
+func (p *proc) stop(timeout time.Duration) {
+	p.stdin.Close()
+	done := make(chan struct{})
+	go func() {
+		for range p.msgs {
+		}
+		p.cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		p.cmd.Process.Kill()
+		<-done
+	}
+}
+
+// conn is a connection to a plugin that is (re)started as needed.
+type conn struct {
+	name    string
+	args    []string
+	timeout time.Duration
+
+	mu     sync.Mutex // serializes requests
+	p      *proc      // nil if not running
+	nextId int64
+}
+
+// call calls method of the plugin with params, and decodes the result
+// into result. A crashed plugin is restarted and the call retried once.
+func (c *conn) call(method string, params, result interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+	for try := 0; try < 2; try++ {
+		var retry bool
+		retry, err = c.callOnce(method, params, result)
+		if !retry {
+			break
+		}
+		log.Printf("plugin %s: %v, restarting", c.name, err)
+	}
+	return err
+}
+
+// callOnce performs a call, and reports whether
+// it should be retried with a restarted plugin.
+func (c *conn) callOnce(method string, params, result interface{}) (retry bool, err error) {
+	if c.p == nil {
+		if err := c.start(); err != nil {
+			return false, err
+		}
+	}
+	m, err := c.roundTrip(method, params)
+	if err != nil {
+		return c.failed(err), err
+	}
+	return false, decodeResult(method, m, result)
+}
+
+// stream calls method of the plugin with params like call, but returns
+// a reader of the data the plugin sends in chunks before the result.
+// If the plugin sends no chunks, the result is decoded into result and
+// the reader is nil. Otherwise the plugin is busy until the reader is
+// read to the end or closed, and other requests wait until then.
+func (c *conn) stream(method string, params, result interface{}) (io.ReadCloser, error) {
+	c.mu.Lock()
+	var err error
+	for try := 0; try < 2; try++ {
+		var rc io.ReadCloser
+		var retry bool
+		rc, retry, err = c.streamOnce(method, params, result)
+		if rc != nil {
+			// c.mu is unlocked by rc
+			return rc, nil
+		}
+		if !retry {
+			break
+		}
+		log.Printf("plugin %s: %v, restarting", c.name, err)
+	}
+	c.mu.Unlock()
+	return nil, err
+}
+
+// streamOnce performs a streamed call, and reports whether
+// it should be retried with a restarted plugin.
+func (c *conn) streamOnce(method string, params, result interface{}) (rc io.ReadCloser, retry bool, err error) {
+	if c.p == nil {
+		if err := c.start(); err != nil {
+			return nil, false, err
+		}
+	}
+	id, err := c.send(method, params)
+	var m response
+	if err == nil {
+		m, err = c.recv(method, id)
+	}
+	if err != nil {
+		return nil, c.failed(err), err
+	}
+	if m.Chunk != nil && m.Result == nil {
+		return &chunkReader{c: c, method: method, id: id, buf: m.Chunk}, false, nil
+	}
+	return nil, false, decodeResult(method, m, result)
+}
+
+// failed handles the error err of a request, and reports whether the
+// request should be retried. The plugin is stopped unless it has
+// reported err itself.
+func (c *conn) failed(err error) bool {
+	if _, ok := err.(*Error); ok {
+		return false
+	}
+	// plugin crashed, timed out or sent garbage
+	c.p.stop(c.timeout)
+	c.p = nil
+	return err != errTimeout
+}
+
+func decodeResult(method string, m response, result interface{}) error {
+	if result != nil && len(m.Result) != 0 {
+		if err := json.Unmarshal(m.Result, result); err != nil {
+			return fmt.Errorf("plugin: %s: invalid result: %v", method, err)
+		}
+	}
+	return nil
+}
+
+// roundTrip sends a request, and returns its result. Chunks are
+// expected only by stream, and are skipped.
+func (c *conn) roundTrip(method string, params interface{}) (response, error) {
+	id, err := c.send(method, params)
+	if err != nil {
+		return response{}, err
+	}
+	for {
+		m, err := c.recv(method, id)
+		if err != nil || m.Chunk == nil || m.Result != nil {
+			return m, err
+		}
+	}
+}
+
+// send sends a request to the plugin, and returns its id.
+func (c *conn) send(method string, params interface{}) (int64, error) {
+	c.nextId++
+	return c.nextId, c.p.send(request{Id: c.nextId, Method: method, Params: params})
+}
+
+// recv returns the next message for the request id. It fails if
+// the plugin sends no message within the timeout, or an error.
+func (c *conn) recv(method string, id int64) (response, error) {
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	m, err := c.p.recv(id, timer.C)
+	if err == nil && m.Error != "" {
+		err = &Error{method, m.Error}
+	}
+	return m, err
+}
+
+// chunkReader reads the chunks of a streamed response. It holds
+// c.mu until the response ends, the request fails or it is closed.
+type chunkReader struct {
+	c      *conn
+	method string
+	id     int64
+	buf    []byte // unread data of the last chunk
+	err    error  // set when c.mu is unlocked
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.next()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next receives the next chunk, or releases the
+// connection at the end of the response or on errors.
+func (r *chunkReader) next() {
+	m, err := r.c.recv(r.method, r.id)
+	switch {
+	case err != nil:
+		r.c.failed(err)
+		r.release(err)
+	case m.Chunk == nil || m.Result != nil:
+		r.release(io.EOF)
+	default:
+		r.buf = m.Chunk
+	}
+}
+
+func (r *chunkReader) release(err error) {
+	r.err = err
+	r.c.mu.Unlock()
+}
+
+// Close skips the rest of the response, so that
+// the plugin is ready for the next request.
+func (r *chunkReader) Close() error {
+	for r.err == nil {
+		r.next()
+	}
+	r.buf = nil
+	err := r.err
+	r.err = errReadClosed
+	if err == io.EOF || err == errReadClosed {
+		return nil
+	}
+	return err
+}
+
+// start starts the plugin, and performs the handshake.
+func (c *conn) start() error {
+	p, err := startProc(c.name, c.args)
+	if err != nil {
+		return err
+	}
+	c.p = p
+	var hello struct {
+		Version int    `json:"version"`
+		Name    string `json:"name"`
+	}
+	m, err := c.roundTrip("hello", map[string]int{"version": ProtocolVersion})
+	if err == nil {
+		err = json.Unmarshal(m.Result, &hello)
+	}
+	if err == nil && hello.Version != ProtocolVersion {
+		err = fmt.Errorf("plugin: %s speaks protocol version %d, want %d",
+			c.name, hello.Version, ProtocolVersion)
+	}
+	if err != nil {
+		p.stop(c.timeout)
+		c.p = nil
+		return err
+	}
+	return nil
+}
+
+// close sends close to the plugin, and stops it.
+func (c *conn) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.p == nil {
+		return nil
+	}
+	_, err := c.roundTrip("close", nil)
+	c.p.stop(c.timeout)
+	c.p = nil
+	return err
+}
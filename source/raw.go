@@ -0,0 +1,349 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+)
+
+// RAW files of most cameras (DNG, CR2, NEF, ARW...) are TIFF files
+// having the raw sensor data and one or more JPEG previews in their
+// IFDs. The largest preview is used as the image. The metadata is
+// read by the exif package from the part of the file having the IFDs.
+
+var (
+	errNoPreview = errors.New("raw: no embedded jpeg preview")
+	errRawSize   = errors.New("raw: file too large to read into memory")
+)
+
+const (
+	tagCompression     = 0x103
+	tagPhotometric     = 0x106
+	tagMake            = 0x10f
+	tagStripOffsets    = 0x111
+	tagOrientation     = 0x112
+	tagStripByteCounts = 0x117
+	tagSubIFDs         = 0x14a
+	tagJPEGOffset      = 0x201
+	tagJPEGLength      = 0x202
+	tagExifIFD         = 0x8769
+	tagGPSIFD          = 0x8825
+	tagInteropIFD      = 0xa005
+	tagDNGVersion      = 0xc612
+
+	photometricCFA       = 32803
+	photometricLinearRaw = 34892
+)
+
+// maxIFDs limits the number of IFDs read from broken or hostile files.
+const maxIFDs = 64
+
+// maxRawSize limits the size of RAW files read into memory
+// from readers that are not io.ReaderAt.
+const maxRawSize = 256 << 20
+
+// rawHintLen is the length of the start of files searched for
+// the IFD0 of RAW files. It follows the header in RAW files.
+const rawHintLen = 4 << 10
+
+// isTIFF reports whether hdr is the start of a TIFF file.
+func isTIFF(hdr []byte) bool {
+	if len(hdr) < 4 {
+		return false
+	}
+	s := string(hdr[:4])
+	return s == "II*\x00" || s == "MM\x00*"
+}
+
+type readSeekerAt interface {
+	io.ReaderAt
+	io.Seeker
+}
+
+// isRaw reports whether hdr is the start of a TIFF based RAW file,
+// having the camera maker or the DNG version in its IFD0. Other TIFF
+// files such as scanned images are not RAW files.
+func isRaw(hdr []byte) bool {
+	if !isTIFF(hdr) || len(hdr) < 8 {
+		return false
+	}
+	var bo binary.ByteOrder = binary.LittleEndian
+	if hdr[0] == 'M' {
+		bo = binary.BigEndian
+	}
+	off := int64(bo.Uint32(hdr[4:]))
+	if off < 8 || off+2 > int64(len(hdr)) {
+		return false
+	}
+	n := int64(bo.Uint16(hdr[off:]))
+	for i := int64(0); i < n; i++ {
+		e := off + 2 + 12*i
+		if e+2 > int64(len(hdr)) {
+			break
+		}
+		switch bo.Uint16(hdr[e:]) {
+		case tagMake, tagDNGVersion:
+			return true
+		}
+	}
+	return false
+}
+
+// sniffRaw returns r as an io.ReaderAt with its size if r is a TIFF
+// based RAW file. Otherwise rest should be used instead of r.
+// RAW files are read into memory from readers other than
+// io.ReaderAt, failing for files larger than maxRawSize.
+func sniffRaw(r io.Reader) (ra io.ReaderAt, size int64, rest io.Reader, err error) {
+	if rs, ok := r.(readSeekerAt); ok {
+		// avoid reading the whole file into memory
+		pos, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		end, err := rs.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		if _, err := rs.Seek(pos, io.SeekStart); err != nil {
+			return nil, 0, nil, err
+		}
+		hdr := make([]byte, rawHintLen)
+		n, _ := rs.ReadAt(hdr, pos)
+		if isRaw(hdr[:n]) {
+			return io.NewSectionReader(rs, pos, end-pos), end - pos, nil, nil
+		}
+		return nil, 0, r, nil
+	}
+
+	br := bufio.NewReaderSize(r, rawHintLen)
+	hdr, _ := br.Peek(rawHintLen)
+	if !isRaw(hdr) {
+		return nil, 0, br, nil
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(br, maxRawSize+1))
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if len(data) > maxRawSize {
+		return nil, 0, nil, errRawSize
+	}
+	return bytes.NewReader(data), int64(len(data)), nil, nil
+}
+
+// rawFile is the structure of a TIFF based RAW file.
+type rawFile struct {
+	ra   io.ReaderAt
+	size int64
+	bo   binary.ByteOrder
+
+	orientation int // from IFD0, 0 if unspecified
+	previews    []section
+
+	// metaEnd is the end of the IFD0, Exif and GPS IFDs and their values
+	metaEnd int64
+
+	seen map[int64]bool // IFD offsets read
+}
+
+type section struct {
+	off, n int64
+}
+
+func parseRaw(ra io.ReaderAt, size int64) (*rawFile, error) {
+	hdr := make([]byte, 8)
+	if _, err := ra.ReadAt(hdr, 0); err != nil {
+		return nil, err
+	}
+	f := &rawFile{ra: ra, size: size, seen: make(map[int64]bool)}
+	switch string(hdr[:2]) {
+	case "II":
+		f.bo = binary.LittleEndian
+	case "MM":
+		f.bo = binary.BigEndian
+	default:
+		return nil, errors.New("raw: not a tiff file")
+	}
+	f.metaEnd = 8
+
+	// IFD0 and the IFDs chained to it
+	off := int64(f.bo.Uint32(hdr[4:]))
+	for i := 0; off != 0 && i < maxIFDs; i++ {
+		next, err := f.readIFD(off, i == 0, true)
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			break
+		}
+		off = next
+	}
+	return f, nil
+}
+
+// ifdEntry is an entry of an IFD.
+type ifdEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte // inline value, or the offset of the value
+}
+
+var typeSize = map[uint16]int64{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4,
+}
+
+// readIFD reads the IFD at off, and returns the offset of the next
+// IFD in the chain. The IFD is part of the metadata if meta is set.
+func (f *rawFile) readIFD(off int64, ifd0, meta bool) (int64, error) {
+	if f.seen[off] || len(f.seen) >= maxIFDs {
+		return 0, errors.New("raw: ifd loop")
+	}
+	f.seen[off] = true
+
+	var nb [2]byte
+	if _, err := f.ra.ReadAt(nb[:], off); err != nil {
+		return 0, err
+	}
+	n := int64(f.bo.Uint16(nb[:]))
+	buf := make([]byte, n*12+4)
+	if _, err := f.ra.ReadAt(buf, off+2); err != nil {
+		return 0, err
+	}
+
+	ent := make(map[uint16]ifdEntry)
+	for i := int64(0); i < n; i++ {
+		p := buf[i*12:]
+		e := ifdEntry{
+			tag:   f.bo.Uint16(p),
+			typ:   f.bo.Uint16(p[2:]),
+			count: f.bo.Uint32(p[4:]),
+			value: p[8:12],
+		}
+		ent[e.tag] = e
+		if meta {
+			if sz := typeSize[e.typ] * int64(e.count); sz > 4 {
+				f.extendMeta(int64(f.bo.Uint32(e.value)) + sz)
+			}
+		}
+	}
+	if meta {
+		f.extendMeta(off + 2 + int64(len(buf)))
+	}
+
+	if ifd0 {
+		if v, ok := f.uints(ent, tagOrientation); ok && len(v) == 1 {
+			f.orientation = int(v[0])
+		}
+	}
+	f.addPreviews(ent)
+
+	for _, t := range []uint16{tagExifIFD, tagGPSIFD, tagInteropIFD} {
+		if v, ok := f.uints(ent, t); ok && len(v) == 1 && meta {
+			f.readIFD(int64(v[0]), false, true)
+		}
+	}
+	if v, ok := f.uints(ent, tagSubIFDs); ok {
+		for _, o := range v {
+			f.readIFD(int64(o), false, false)
+		}
+	}
+
+	return int64(f.bo.Uint32(buf[n*12:])), nil
+}
+
+func (f *rawFile) extendMeta(end int64) {
+	if end > f.metaEnd && end <= f.size {
+		f.metaEnd = end
+	}
+}
+
+// addPreviews adds the possible JPEG previews in the IFD entries ent.
+func (f *rawFile) addPreviews(ent map[uint16]ifdEntry) {
+	off, ok1 := f.uints(ent, tagJPEGOffset)
+	n, ok2 := f.uints(ent, tagJPEGLength)
+	if ok1 && ok2 && len(off) == 1 && len(n) == 1 {
+		f.previews = append(f.previews, section{int64(off[0]), int64(n[0])})
+	}
+
+	comp, _ := f.uints(ent, tagCompression)
+	if len(comp) != 1 || (comp[0] != 6 && comp[0] != 7) {
+		return
+	}
+	if pi, ok := f.uints(ent, tagPhotometric); ok && len(pi) == 1 &&
+		(pi[0] == photometricCFA || pi[0] == photometricLinearRaw) {
+		// raw sensor data
+		return
+	}
+	off, ok1 = f.uints(ent, tagStripOffsets)
+	n, ok2 = f.uints(ent, tagStripByteCounts)
+	if ok1 && ok2 && len(off) == 1 && len(n) == 1 {
+		f.previews = append(f.previews, section{int64(off[0]), int64(n[0])})
+	}
+}
+
+// uints returns the values of the SHORT, LONG or IFD entry tag in ent.
+func (f *rawFile) uints(ent map[uint16]ifdEntry, tag uint16) ([]uint32, bool) {
+	e, ok := ent[tag]
+	if !ok || e.count == 0 || e.count > 1024 {
+		return nil, false
+	}
+	var sz int64
+	switch e.typ {
+	case 3: // SHORT
+		sz = 2
+	case 4, 13: // LONG, IFD
+		sz = 4
+	default:
+		return nil, false
+	}
+	data := e.value
+	if n := sz * int64(e.count); n > 4 {
+		data = make([]byte, n)
+		if _, err := f.ra.ReadAt(data, int64(f.bo.Uint32(e.value))); err != nil {
+			return nil, false
+		}
+	}
+	v := make([]uint32, e.count)
+	for i := range v {
+		if sz == 2 {
+			v[i] = uint32(f.bo.Uint16(data[i*2:]))
+		} else {
+			v[i] = f.bo.Uint32(data[i*4:])
+		}
+	}
+	return v, true
+}
+
+// preview returns the largest JPEG preview
+// that can be decoded by image/jpeg.
+func (f *rawFile) preview() (io.Reader, image.Config, error) {
+	var best *io.SectionReader
+	var bestCfg image.Config
+	for _, s := range f.previews {
+		if s.off <= 0 || s.n <= 0 || s.off+s.n > f.size {
+			continue
+		}
+		sr := io.NewSectionReader(f.ra, s.off, s.n)
+		cfg, err := jpeg.DecodeConfig(sr)
+		if err != nil {
+			// eg. lossless jpeg raw data
+			continue
+		}
+		if best == nil || cfg.Width*cfg.Height > bestCfg.Width*bestCfg.Height {
+			best, bestCfg = io.NewSectionReader(f.ra, s.off, s.n), cfg
+		}
+	}
+	if best == nil {
+		return nil, image.Config{}, errNoPreview
+	}
+	return best, bestCfg, nil
+}
+
+// meta returns the part of the file having the metadata IFDs.
+func (f *rawFile) meta() io.Reader {
+	return io.NewSectionReader(f.ra, 0, f.metaEnd)
+}
@@ -0,0 +1,112 @@
+package source
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// testRaw returns a little endian TIFF having a small JPEG thumbnail in
+// IFD0 and a larger JPEG preview as a strip in a SubIFD, similar to NEF.
+func testRaw(t *testing.T, orientation int) []byte {
+	enc := func(w, h int) []byte {
+		buf := new(bytes.Buffer)
+		if err := jpeg.Encode(buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	thumb, preview := enc(16, 12), enc(64, 48)
+
+	type entry struct {
+		tag, typ uint16
+		val      uint32
+	}
+	ifd := func(entries []entry, next uint32) []byte {
+		b := make([]byte, 2+len(entries)*12+4)
+		le := binary.LittleEndian
+		le.PutUint16(b, uint16(len(entries)))
+		for i, e := range entries {
+			p := b[2+i*12:]
+			le.PutUint16(p, e.tag)
+			le.PutUint16(p[2:], e.typ)
+			le.PutUint32(p[4:], 1)
+			if e.typ == 3 {
+				le.PutUint16(p[8:], uint16(e.val))
+			} else {
+				le.PutUint32(p[8:], e.val)
+			}
+		}
+		le.PutUint32(b[len(b)-4:], next)
+		return b
+	}
+
+	// layout: header, IFD0, SubIFD, thumb, preview
+	const ifd0Off = 8
+	ifd0Len := 2 + 5*12 + 4
+	subOff := ifd0Off + ifd0Len
+	subLen := 2 + 4*12 + 4
+	thumbOff := subOff + subLen
+	previewOff := thumbOff + len(thumb)
+
+	buf := new(bytes.Buffer)
+	buf.WriteString("II*\x00")
+	binary.Write(buf, binary.LittleEndian, uint32(ifd0Off))
+	buf.Write(ifd([]entry{
+		{tagMake, 2, 0},
+		{tagOrientation, 3, uint32(orientation)},
+		{tagSubIFDs, 13, uint32(subOff)},
+		{tagJPEGOffset, 4, uint32(thumbOff)},
+		{tagJPEGLength, 4, uint32(len(thumb))},
+	}, 0))
+	buf.Write(ifd([]entry{
+		{tagCompression, 3, 6},
+		{tagPhotometric, 3, 6},
+		{tagStripOffsets, 4, uint32(previewOff)},
+		{tagStripByteCounts, 4, uint32(len(preview))},
+	}, 0))
+	buf.Write(thumb)
+	buf.Write(preview)
+	return buf.Bytes()
+}
+
+func TestRaw(t *testing.T) {
+	raw := testRaw(t, 6)
+
+	ii, err := InfoFromReader(time.Now(), bytes.NewReader(raw))
+	if err != nil && !IsNoLoc(err) {
+		t.Fatal(err)
+	}
+	if ii.Width != 64 || ii.Height != 48 {
+		t.Errorf("info size is %dx%d, want 64x48", ii.Width, ii.Height)
+	}
+
+	// plain reader without seeking
+	im, err := LoadImage(bytes.NewBufferString(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rotated by orientation 6
+	if b := im.Bounds(); b.Dx() != 48 || b.Dy() != 64 {
+		t.Errorf("image size is %dx%d, want 48x64", b.Dx(), b.Dy())
+	}
+
+	// a TIFF without the camera maker is not a RAW file
+	plain := append([]byte(nil), raw...)
+	binary.LittleEndian.PutUint16(plain[8+2:], 0x10e) // ImageDescription
+	for _, r := range []io.Reader{bytes.NewReader(plain), bytes.NewBuffer(plain)} {
+		ra, _, rest, err := sniffRaw(r)
+		if ra != nil || err != nil {
+			t.Errorf("%T: plain TIFF sniffed as RAW, error %v", r, err)
+			continue
+		}
+		if data, _ := ioutil.ReadAll(rest); !bytes.Equal(data, plain) {
+			t.Errorf("%T: rest of plain TIFF differs", r)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package source
+
+import (
+	"errors"
+	"hash/fnv"
+	"io"
+	"time"
+)
+
+// RangeFunc returns the bytes [off, off+n) of a remote file.
+type RangeFunc func(off, n int64) (io.ReadCloser, error)
+
+const (
+	// minChunk is the size of the first range of sequential reads,
+	// enough for the headers of most photos.
+	minChunk = 64 << 10
+
+	// maxChunk limits the size of ranges of sequential reads.
+	maxChunk = 8 << 20
+)
+
+// RangeReader reads a remote file using range requests. Sequential reads
+// use ranges of growing size, so that reading just the header of a photo
+// transfers little more than the header itself. It implements io.Seeker
+// and io.ReaderAt, so that InfoFromReader and LoadImage can read
+// RAW files without fetching all of them.
+type RangeReader struct {
+	get  RangeFunc
+	size int64
+
+	pos int64 // position for Read and Seek
+
+	body    io.ReadCloser // current range, or nil
+	bodyPos int64         // position of body within the file
+	bodyEnd int64         // end of the range of body
+	chunk   int64         // size of the next range
+}
+
+// NewRangeReader returns a reader of the remote file of size
+// having its ranges fetched with get.
+func NewRangeReader(size int64, get RangeFunc) *RangeReader {
+	return &RangeReader{get: get, size: size, chunk: minChunk}
+}
+
+func (r *RangeReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if r.body != nil && (r.bodyPos != r.pos || r.bodyPos >= r.bodyEnd) {
+		if r.bodyPos != r.pos {
+			// seeked away, start over with small ranges
+			r.chunk = minChunk
+		}
+		r.closeBody()
+	}
+	if r.body == nil {
+		n := r.chunk
+		if r.pos+n > r.size {
+			n = r.size - r.pos
+		}
+		body, err := r.get(r.pos, n)
+		if err != nil {
+			return 0, err
+		}
+		r.body, r.bodyPos, r.bodyEnd = body, r.pos, r.pos+n
+		if r.chunk < maxChunk {
+			r.chunk *= 2
+		}
+	}
+	if max := r.bodyEnd - r.pos; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := r.body.Read(p)
+	r.pos += int64(n)
+	r.bodyPos = r.pos
+	if err == io.EOF {
+		if r.pos < r.bodyEnd {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt reads len(p) bytes at off with a single range request.
+func (r *RangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("source: negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	n := int64(len(p))
+	if off+n > r.size {
+		n = r.size - off
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	body, err := r.get(off, n)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	m, err := io.ReadFull(body, p[:n])
+	if err == nil && n < int64(len(p)) {
+		err = io.EOF
+	}
+	return m, err
+}
+
+func (r *RangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("source: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("source: negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *RangeReader) Close() error {
+	r.closeBody()
+	return nil
+}
+
+func (r *RangeReader) closeBody() {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+}
+
+// ETagTime returns the modification time of a remote file for ModTimes.
+// Remote modification times have a resolution of a second or millisecond,
+// the remaining nanoseconds are set from the ETag, so that the time
+// changes when the file is replaced within that period.
+func ETagTime(mt time.Time, etag string) time.Time {
+	h := fnv.New32a()
+	h.Write([]byte(etag))
+	return mt.Truncate(time.Millisecond).
+		Add(time.Duration(h.Sum32() % uint32(time.Millisecond)))
+}
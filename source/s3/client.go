@@ -0,0 +1,266 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+// client is a minimal S3 client using path style requests
+// signed with AWS Signature Version 4.
+type client struct {
+	cfg  Config
+	base *url.URL
+	hc   *http.Client
+
+	now func() time.Time // for tests
+}
+
+func newClient(cfg Config) (*client, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("s3: invalid endpoint %q", cfg.Endpoint)
+	}
+	return &client{cfg: cfg, base: u, hc: http.DefaultClient, now: time.Now}, nil
+}
+
+// object is an object in a listing.
+type object struct {
+	Key          string
+	LastModified time.Time
+	ETag         string
+	Size         int64
+}
+
+type listResult struct {
+	Contents              []object
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// list returns the objects in the bucket under prefix.
+func (c *client) list(prefix string) ([]object, error) {
+	var objs []object
+	var token string
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		if prefix != "" {
+			q.Set("prefix", prefix)
+		}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		resp, err := c.do(context.Background(), "GET", "", q, nil)
+		if err != nil {
+			return nil, err
+		}
+		var lr listResult
+		err = xml.NewDecoder(resp.Body).Decode(&lr)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3: list %s: %v", c.cfg.Bucket, err)
+		}
+		objs = append(objs, lr.Contents...)
+		if !lr.IsTruncated || lr.NextContinuationToken == "" {
+			return objs, nil
+		}
+		token = lr.NextContinuationToken
+	}
+}
+
+// getRange returns the body of bytes [off, off+n) of key.
+// The request fails if the ETag of the object is no longer etag,
+// or the response is not the partial content requested.
+func (c *client) getRange(ctx context.Context, key, etag string, off, n int64) (io.ReadCloser, error) {
+	h := make(http.Header)
+	h.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	if etag != "" {
+		h.Set("If-Match", etag)
+	}
+	resp, err := c.do(ctx, "GET", key, nil, h)
+	if err != nil {
+		return nil, err
+	}
+	// a server ignoring the range would send the object from the start
+	cr := resp.Header.Get("Content-Range")
+	if resp.StatusCode != http.StatusPartialContent || !strings.HasPrefix(cr, fmt.Sprintf("bytes %d-", off)) {
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3: GET %s: %s with content range %q for bytes %d-%d",
+			key, resp.Status, cr, off, off+n-1)
+	}
+	return resp.Body, nil
+}
+
+// reader returns a reader of o using ranged GETs
+// that are cancelled when ctx is done.
+func (c *client) reader(ctx context.Context, o *object) *source.RangeReader {
+	return source.NewRangeReader(o.Size, func(off, n int64) (io.ReadCloser, error) {
+		return c.getRange(ctx, o.Key, o.ETag, off, n)
+	})
+}
+
+// errorResponse is the error document returned by S3.
+type errorResponse struct {
+	Code    string
+	Message string
+}
+
+// do performs a signed request for key in the bucket. The response
+// is returned only if it has a 2xx status code.
+func (c *client) do(ctx context.Context, method, key string, q url.Values, h http.Header) (*http.Response, error) {
+	u := *c.base
+	u.Path = strings.TrimRight(u.Path, "/") + "/" + c.cfg.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = ""
+	u.RawQuery = ""
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.URL.Opaque = "//" + u.Host + uriEncode(u.Path, false)
+	req.URL.RawQuery = canonicalQuery(q)
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	c.sign(req)
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	var er errorResponse
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(data, &er) == nil && er.Code != "" {
+		return nil, fmt.Errorf("s3: %s %s: %s: %s", method, u.Path, er.Code, er.Message)
+	}
+	return nil, fmt.Errorf("s3: %s %s: %s", method, u.Path, resp.Status)
+}
+
+// emptyHash is the SHA256 hash of the empty payload.
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign signs req having no body using AWS Signature Version 4.
+// Requests are left unsigned without credentials.
+func (c *client) sign(req *http.Request) {
+	if c.cfg.AccessKey == "" {
+		return
+	}
+	t := c.now().UTC()
+	date := t.Format("20060102")
+	stamp := t.Format("20060102T150405Z")
+
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", emptyHash)
+	if c.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.cfg.SessionToken)
+	}
+
+	// canonical headers
+	hdr := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		hdr[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(hdr))
+	for k := range hdr {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var ch bytes.Buffer
+	for _, k := range names {
+		ch.WriteString(k + ":" + hdr[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	path := req.URL.Opaque[len("//"+req.URL.Host):]
+	creq := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		ch.String(),
+		signed,
+		emptyHash,
+	}, "\n")
+
+	scope := date + "/" + c.cfg.Region + "/s3/aws4_request"
+	sts := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hexHash(creq)
+
+	key := hmacSHA256([]byte("AWS4"+c.cfg.SecretKey), date)
+	key = hmacSHA256(key, c.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, sts))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.cfg.AccessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+sig)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	io.WriteString(h, data)
+	return h.Sum(nil)
+}
+
+func hexHash(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// canonicalQuery returns q encoded as required by Signature Version 4.
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var v []string
+	for _, k := range keys {
+		for _, x := range q[k] {
+			v = append(v, uriEncode(k, true)+"="+uriEncode(x, true))
+		}
+	}
+	return strings.Join(v, "&")
+}
+
+// uriEncode percent-encodes all bytes of s except unreserved
+// characters, and slashes unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	const digits = "0123456789ABCDEF"
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(digits[c>>4])
+			b.WriteByte(digits[c&15])
+		}
+	}
+	return b.String()
+}
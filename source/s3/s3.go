@@ -0,0 +1,222 @@
+// Package s3 implements an image source for objects in
+// Amazon S3 or compatible object storage such as MinIO.
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+	"github.com/tajtiattila/photomap/source/xmp"
+)
+
+func init() {
+	// bucket[/prefix]
+	source.Register("s3", func(spec string) (source.ImageSource, error) {
+		cfg := ConfigFromEnv()
+		cfg.Bucket, cfg.Prefix = splitBucket(spec)
+		return NewSource(cfg)
+	})
+	// s3://bucket/prefix?endpoint=http://minio:9000&region=eu-west-1
+	source.RegisterScheme("s3", func(u *url.URL) (source.ImageSource, error) {
+		cfg := ConfigFromEnv()
+		cfg.Bucket, cfg.Prefix = u.Host, strings.TrimPrefix(u.Path, "/")
+		q := u.Query()
+		if v := q.Get("endpoint"); v != "" {
+			cfg.Endpoint = v
+		}
+		if v := q.Get("region"); v != "" {
+			cfg.Region = v
+			if q.Get("endpoint") == "" && os.Getenv("AWS_ENDPOINT_URL") == "" {
+				cfg.Endpoint = awsEndpoint(v)
+			}
+		}
+		return NewSource(cfg)
+	})
+}
+
+// Config is the configuration of a Source.
+type Config struct {
+	// Endpoint is the URL of the service, eg. http://minio:9000.
+	// Requests use path style addressing.
+	Endpoint string
+
+	// Region used for signing requests.
+	Region string
+
+	// Bucket and Prefix of the objects used.
+	Bucket string
+	Prefix string
+
+	// Credentials. Requests are sent unsigned if AccessKey is empty.
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// ConfigFromEnv returns a Config having the endpoint, region and
+// credentials set from the usual AWS environment variables.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Region:       os.Getenv("AWS_REGION"),
+		Endpoint:     os.Getenv("AWS_ENDPOINT_URL"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if cfg.Region == "" {
+		cfg.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = awsEndpoint(cfg.Region)
+	}
+	return cfg
+}
+
+func awsEndpoint(region string) string {
+	return "https://s3." + region + ".amazonaws.com"
+}
+
+func splitBucket(spec string) (bucket, prefix string) {
+	if i := strings.IndexByte(spec, '/'); i >= 0 {
+		return spec[:i], spec[i+1:]
+	}
+	return spec, ""
+}
+
+const s3prefix = "s3:"
+
+// Source is an image source using the objects in a bucket.
+type Source struct {
+	c        *client
+	objects  map[string]*entry
+	modTimes map[string]time.Time
+}
+
+type entry struct {
+	obj object
+	sc  *object // xmp sidecar, or nil
+}
+
+// NewSource returns a new Source for the objects
+// in the bucket and under the prefix of cfg.
+func NewSource(cfg Config) (*Source, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3: missing bucket")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	c, err := newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &Source{
+		c:        c,
+		objects:  make(map[string]*entry),
+		modTimes: make(map[string]time.Time),
+	}
+	return s, s.init()
+}
+
+func (s *Source) init() error {
+	objs, err := s.c.list(s.c.cfg.Prefix)
+	if err != nil {
+		return err
+	}
+	byKey := make(map[string]*object)
+	for i := range objs {
+		byKey[objs[i].Key] = &objs[i]
+	}
+	for _, o := range objs {
+		if !source.HasImageExt(o.Key) {
+			continue
+		}
+		im := &entry{obj: o}
+		mt := modTime(&o)
+		for _, n := range xmp.SidecarNames(o.Key) {
+			if sc := byKey[n]; sc != nil {
+				im.sc = sc
+				if t := modTime(sc); t.After(mt) {
+					mt = t
+				}
+				break
+			}
+		}
+		id := s3prefix + s.c.cfg.Bucket + "/" + o.Key
+		s.objects[id] = im
+		s.modTimes[id] = mt
+	}
+	return nil
+}
+
+func modTime(o *object) time.Time {
+	return source.ETagTime(o.LastModified, o.ETag)
+}
+
+func (s *Source) ModTimes() map[string]time.Time {
+	return s.modTimes
+}
+
+func (s *Source) Info(id string) (source.ImageInfo, error) {
+	return s.InfoContext(context.Background(), id)
+}
+
+// InfoContext implements source.ContextSource.
+func (s *Source) InfoContext(ctx context.Context, id string) (source.ImageInfo, error) {
+	im, ok := s.objects[id]
+	if !ok {
+		return source.ImageInfo{}, os.ErrNotExist
+	}
+	r := s.c.reader(ctx, &im.obj)
+	defer r.Close()
+
+	var hooks []source.InfoHook
+	if im.sc != nil {
+		if m, err := s.loadSidecar(ctx, im.sc); err != nil {
+			log.Println(err)
+		} else {
+			hooks = append(hooks, m.Hook())
+		}
+	}
+	return source.InfoFromReader(s.modTimes[id], r, hooks...)
+}
+
+// Open returns a reader of the object id. The reader
+// implements io.Seeker and io.ReaderAt using ranged GETs.
+func (s *Source) Open(id string) (io.ReadCloser, error) {
+	return s.OpenContext(context.Background(), id)
+}
+
+// OpenContext implements source.ContextSource.
+func (s *Source) OpenContext(ctx context.Context, id string) (io.ReadCloser, error) {
+	im, ok := s.objects[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return s.c.reader(ctx, &im.obj), nil
+}
+
+func (s *Source) Close() error {
+	return nil
+}
+
+func (s *Source) loadSidecar(ctx context.Context, sc *object) (*xmp.Meta, error) {
+	r := s.c.reader(ctx, sc)
+	defer r.Close()
+	m, err := xmp.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("xmp: s3:%s/%s: %v", s.c.cfg.Bucket, sc.Key, err)
+	}
+	return m, nil
+}
@@ -1,14 +1,28 @@
 package source
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
 type ImageInfo struct {
+	// MediaType is MediaVideo for videos, or empty for photos.
+	MediaType string
+
 	CreateTime time.Time
 
+	// TimeSource is where CreateTime comes from, such as TimeOffset.
+	TimeSource string
+
+	// Duration is the length of videos.
+	Duration time.Duration
+
 	// image dimensions
 	Width  int
 	Height int
@@ -16,6 +30,27 @@ type ImageInfo struct {
 	// gps location
 	Lat  float64
 	Long float64
+
+	// LocSource describes where the location comes from
+	// if it was not embedded in the image, eg. a track log.
+	LocSource string
+
+	// star rating, 0 if unrated or -1 if rejected
+	Rating int
+
+	// keywords of the image, if known
+	Keywords []string
+
+	// Camera is the camera metadata from EXIF.
+	Camera Camera
+
+	// GPS altitude in meters and image direction in
+	// degrees from true or magnetic north, nil if unknown.
+	Altitude  *float64
+	Direction *float64
+
+	// Magnetic reports whether Direction is from magnetic north.
+	Magnetic bool
 }
 
 type ImageSource interface {
@@ -35,6 +70,101 @@ type ImageSource interface {
 	Close() error
 }
 
+// Edit is a change to the metadata of an image.
+type Edit struct {
+	// Loc is the new location of the image, if not nil.
+	Loc *Loc
+
+	// ClearLoc removes the location written by earlier edits
+	// if Loc is nil, leaving the original location of the image.
+	ClearLoc bool
+
+	// CreateTime is the new creation time, if not zero.
+	CreateTime time.Time
+}
+
+// Loc is a gps location.
+type Loc struct {
+	Lat  float64
+	Long float64
+}
+
+// Writer is implemented by image sources
+// that can store changes to image metadata.
+type Writer interface {
+	// WriteInfo applies e to the image with id,
+	// and returns the new modtime of the image.
+	WriteInfo(id string, e Edit) (time.Time, error)
+}
+
+// Change is a change of an image in an ImageSource.
+type Change struct {
+	Op ChangeOp
+	Id string
+
+	// ModTime is the new modtime of the image, zero if removed.
+	ModTime time.Time
+}
+
+type ChangeOp int
+
+const (
+	Added ChangeOp = iota
+	Modified
+	Removed
+)
+
+// Watcher is implemented by image sources that can report changes.
+type Watcher interface {
+	// Watch starts watching for changes, and returns the channel
+	// on which they are reported. The channel is closed when the
+	// source is closed.
+	Watch() (<-chan Change, error)
+}
+
+// ErrNotSupported is returned by image sources implementing an optional
+// interface such as Writer or Watcher if the operation is not supported
+// for the source or the image in question.
+var ErrNotSupported = errors.New("source: operation not supported")
+
+// Collector is implemented by image sources organizing
+// images into collections, such as Lightroom catalogs.
+type Collector interface {
+	// Collections returns the names of the collections of the image with id.
+	Collections(id string) []string
+}
+
+// Thumbnailer is implemented by image sources that can provide
+// downscaled images, such as servers resizing images themselves.
+type Thumbnailer interface {
+	// Thumbnail returns the image with id encoded as JPEG or PNG,
+	// scaled to fit within size×size pixels. It returns
+	// ErrNotSupported if the image has no thumbnail.
+	// Reading fails with the error of ctx after ctx is done.
+	Thumbnail(ctx context.Context, id string, size int) (io.ReadCloser, error)
+}
+
+// Labeler is implemented by image sources combining other sources.
+type Labeler interface {
+	// Label returns the label of the source of the image with id.
+	Label(id string) string
+}
+
+// Aliaser is implemented by image sources that have changed the ids
+// of their images, so that data kept for earlier ids can be found.
+type Aliaser interface {
+	// Aliases returns other ids the image with id may have had.
+	Aliases(id string) []string
+}
+
+// Namer is implemented by image sources having ids
+// that do not end with the file names of their images.
+type Namer interface {
+	// Name returns the file name of the image with id used to find
+	// its content type, or the empty string if it is unknown.
+	Name(id string) string
+}
+
 // Open opens the source registered with name using
 // the argument provided.
 func Open(name string, arg string) (ImageSource, error) {
@@ -58,3 +188,74 @@ func Register(name string, f NewSourceFunc) {
 	}
 	sources[name] = f
 }
+
+// OpenURLFunc opens an image source from a URL.
+type OpenURLFunc func(u *url.URL) (ImageSource, error)
+
+// OpenLocatorFunc opens a locator from a URL.
+type OpenLocatorFunc func(u *url.URL) (Locator, error)
+
+var (
+	schemes    map[string]OpenURLFunc
+	locSchemes map[string]OpenLocatorFunc
+)
+
+// RegisterScheme registers f to open image sources
+// with URLs having the specified scheme.
+func RegisterScheme(scheme string, f OpenURLFunc) {
+	if schemes == nil {
+		schemes = make(map[string]OpenURLFunc)
+	}
+	checkScheme(scheme)
+	schemes[scheme] = f
+}
+
+// RegisterLocatorScheme registers f to open locators
+// with URLs having the specified scheme.
+func RegisterLocatorScheme(scheme string, f OpenLocatorFunc) {
+	if locSchemes == nil {
+		locSchemes = make(map[string]OpenLocatorFunc)
+	}
+	checkScheme(scheme)
+	locSchemes[scheme] = f
+}
+
+func checkScheme(scheme string) {
+	_, ok1 := schemes[scheme]
+	_, ok2 := locSchemes[scheme]
+	if ok1 || ok2 {
+		panic(fmt.Sprintf("scheme %q already exists", scheme))
+	}
+}
+
+// FilePath returns the local file path of a file URL
+// such as file:///photos or file:relative/dir.
+func FilePath(u *url.URL) string {
+	if u.Opaque != "" {
+		return filepath.FromSlash(u.Opaque)
+	}
+	p := u.Path
+	if u.Host != "" && u.Host != "localhost" {
+		// file://photos is most likely meant to be relative
+		p = u.Host + p
+	}
+	if len(p) >= 3 && p[0] == '/' && p[2] == ':' {
+		// windows drive: file:///C:/photos
+		p = p[1:]
+	}
+	return filepath.FromSlash(p)
+}
+
+// QueryBool reports whether the boolean query parameter key is set in v.
+// A parameter without value such as "?follow" is considered true.
+func QueryBool(v url.Values, key string) bool {
+	vs, ok := v[key]
+	if !ok {
+		return false
+	}
+	switch strings.ToLower(vs[len(vs)-1]) {
+	case "", "1", "t", "true", "y", "yes":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package takeout
+
+import (
+	"encoding/json"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+// meta is the metadata in a Takeout JSON sidecar.
+type meta struct {
+	Title          string    `json:"title"`
+	PhotoTakenTime timestamp `json:"photoTakenTime"`
+	GeoData        geoData   `json:"geoData"`
+	GeoDataExif    geoData   `json:"geoDataExif"`
+}
+
+type timestamp struct {
+	Timestamp string `json:"timestamp"` // unix seconds
+}
+
+func (t timestamp) time() (time.Time, bool) {
+	s, err := strconv.ParseInt(t.Timestamp, 10, 64)
+	if err != nil || s == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(s, 0).UTC(), true
+}
+
+type geoData struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// valid reports whether g has a location.
+// Takeout uses 0, 0 for unknown locations.
+func (g geoData) valid() bool {
+	return g.Latitude != 0 || g.Longitude != 0
+}
+
+func parseMeta(r io.Reader) (*meta, error) {
+	m := new(meta)
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// hook returns a source.InfoHook that uses the location of m if the image
+// has none, and the time the photo was taken in m as its CreateTime.
+func (m *meta) hook() source.InfoHook {
+	return func(ii *source.ImageInfo) bool {
+		setLoc := false
+		if ii.Lat == 0 && ii.Long == 0 {
+			switch {
+			case m.GeoData.valid():
+				ii.Lat, ii.Long = m.GeoData.Latitude, m.GeoData.Longitude
+				setLoc = true
+			case m.GeoDataExif.valid():
+				ii.Lat, ii.Long = m.GeoDataExif.Latitude, m.GeoDataExif.Longitude
+				setLoc = true
+			}
+			if setLoc {
+				ii.LocSource = "takeout"
+			}
+		}
+		if t, ok := m.PhotoTakenTime.time(); ok {
+			ii.CreateTime = t
+		}
+		return setLoc
+	}
+}
+
+// Takeout shortens the names of sidecars to this length without ".json".
+const maxSidecarName = 46
+
+// minTruncated is the minimum length of names
+// that are considered to be truncated.
+const minTruncated = 40
+
+const supplemental = ".supplemental-metadata"
+
+var dupRx = regexp.MustCompile(`^(.*)(\(\d+\))$`)
+
+// splitDup splits the duplicate suffix such as "(1)"
+// from the stem of the file name n.
+func splitDup(n string) (name, dup string) {
+	ext := path.Ext(n)
+	if m := dupRx.FindStringSubmatch(strings.TrimSuffix(n, ext)); m != nil {
+		return m[1] + ext, m[2]
+	}
+	return n, ""
+}
+
+// sidecarKey returns the normalized name and duplicate suffix of
+// the sidecar name n. The result is the name of the media file
+// if it has not been truncated.
+func sidecarKey(n string) (name, dup string) {
+	n = strings.TrimSuffix(n, ".json")
+	if m := dupRx.FindStringSubmatch(n); m != nil {
+		n, dup = m[1], m[2]
+	}
+	// remove possibly truncated ".supplemental-metadata"
+	if i := strings.LastIndex(n, "."); i >= 0 && len(n)-i > 1 && strings.HasPrefix(supplemental, n[i:]) {
+		if len(n[i:]) > 5 || len(n) >= maxSidecarName {
+			n = n[:i]
+		}
+	}
+	return n, dup
+}
+
+// mediaKey returns the name and duplicate suffix of a sidecar
+// matching the media file name n. Edited copies use the
+// sidecar of the original.
+func mediaKey(n string) (name, dup string) {
+	name, dup = splitDup(n)
+	ext := path.Ext(name)
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ext), "-edited") + ext
+	return name, dup
+}
+
+// sidecarSet finds the sidecars of media files within a directory.
+type sidecarSet struct {
+	byTitle map[string]*sidecar
+	all     []*sidecar
+}
+
+type sidecar struct {
+	f    *file
+	m    *meta
+	name string // from sidecarKey
+	dup  string
+}
+
+func (s *sidecarSet) add(f *file, m *meta) {
+	if s.byTitle == nil {
+		s.byTitle = make(map[string]*sidecar)
+	}
+	sc := &sidecar{f: f, m: m}
+	sc.name, sc.dup = sidecarKey(path.Base(f.name))
+	s.all = append(s.all, sc)
+	if m.Title != "" {
+		s.byTitle[m.Title+"|"+sc.dup] = sc
+	}
+}
+
+// find returns the sidecar for the media file named n, or nil.
+func (s *sidecarSet) find(n string) *sidecar {
+	name, dup := mediaKey(n)
+	if sc := s.byTitle[name+"|"+dup]; sc != nil {
+		return sc
+	}
+
+	// titles may differ from file names because of
+	// replaced characters, fall back to sidecar names
+	stem := strings.TrimSuffix(name, path.Ext(name))
+	var best *sidecar
+	for _, sc := range s.all {
+		if sc.dup != dup {
+			continue
+		}
+		ok := sc.name == name || sc.name == stem ||
+			(len(sc.name) >= minTruncated && strings.HasPrefix(name, sc.name)) ||
+			(len(name) >= minTruncated && strings.HasPrefix(sc.name, stem))
+		if ok && (best == nil || len(sc.name) > len(best.name)) {
+			best = sc
+		}
+	}
+	return best
+}
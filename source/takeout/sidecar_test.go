@@ -0,0 +1,78 @@
+package takeout
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+func TestFindSidecar(t *testing.T) {
+	long := "Screenshot_20170312-154512_Google Play Store.jpg" // 48 chars
+	sidecars := map[string]string{
+		"IMG_0001.JPG.json":                          "IMG_0001.JPG",
+		"IMG_0001.JPG(1).json":                       "IMG_0001.JPG",
+		"IMG_0002.json":                              "IMG_0002.JPG",
+		"IMG_0003.jpg.supplemental-metadata.json":    "",
+		"IMG_20190704_183945.jpg.supplemental-.json": "",
+		long[:maxSidecarName] + ".json":              "",
+		"Sz?p kil?t?s.jpg.json":                      "Szép kilátás.jpg",
+		"metadata.json":                              "",
+	}
+	want := map[string]string{
+		"IMG_0001.JPG":            "IMG_0001.JPG.json",
+		"IMG_0001(1).JPG":         "IMG_0001.JPG(1).json",
+		"IMG_0001-edited.JPG":     "IMG_0001.JPG.json",
+		"IMG_0002.JPG":            "IMG_0002.json",
+		"IMG_0003.jpg":            "IMG_0003.jpg.supplemental-metadata.json",
+		"IMG_20190704_183945.jpg": "IMG_20190704_183945.jpg.supplemental-.json",
+		long:                      long[:maxSidecarName] + ".json",
+		"Sz?p kil?t?s.jpg":        "Sz?p kil?t?s.jpg.json",
+		"IMG_0004.JPG":            "",
+	}
+
+	set := new(sidecarSet)
+	for name, title := range sidecars {
+		set.add(&file{name: "Takeout/Google Photos/2017/" + name}, &meta{Title: title})
+	}
+	for media, sc := range want {
+		got := ""
+		if s := set.find(media); s != nil {
+			got = s.f.name[strings.LastIndex(s.f.name, "/")+1:]
+		}
+		if got != sc {
+			t.Errorf("sidecar of %q is %q, want %q", media, got, sc)
+		}
+	}
+}
+
+func TestParseMeta(t *testing.T) {
+	const doc = `{
+  "title": "IMG_0001.JPG",
+  "photoTakenTime": {"timestamp": "1489333512", "formatted": "Mar 12, 2017, 3:45:12 PM UTC"},
+  "geoData": {"latitude": 0.0, "longitude": 0.0, "altitude": 0.0},
+  "geoDataExif": {"latitude": 47.4979, "longitude": 19.0402, "altitude": 110.0}
+}`
+	m, err := parseMeta(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := infoWith(m, 0, 0)
+	if info.Lat != 47.4979 || info.Long != 19.0402 || info.LocSource != "takeout" {
+		t.Errorf("location not from geoDataExif: %+v", info)
+	}
+	want := time.Date(2017, 3, 12, 15, 45, 12, 0, time.UTC)
+	if !info.CreateTime.Equal(want) || info.CreateTime.Location() != time.UTC {
+		t.Errorf("create time is %v, want %v", info.CreateTime, want)
+	}
+	if info := infoWith(m, 1, 2); info.Lat != 1 || info.Long != 2 {
+		t.Errorf("embedded location overridden: %+v", info)
+	}
+}
+
+func infoWith(m *meta, lat, long float64) source.ImageInfo {
+	ii := source.ImageInfo{Lat: lat, Long: long}
+	m.hook()(&ii)
+	return ii
+}
@@ -0,0 +1,215 @@
+// Package takeout implements an image source for Google Photos
+// exports from Google Takeout, using the location and time
+// in the JSON sidecars of the photos.
+package takeout
+
+import (
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+	"github.com/tajtiattila/photomap/source/archive"
+)
+
+func init() {
+	source.Register("takeout", func(paths string) (source.ImageSource, error) {
+		return NewSource(filepath.SplitList(paths)...)
+	})
+	source.RegisterScheme("takeout", func(u *url.URL) (source.ImageSource, error) {
+		return NewSource(source.FilePath(u))
+	})
+}
+
+const takeoutprefix = "takeout:"
+
+// Source is an image source for Takeout exports.
+type Source struct {
+	archives []*archive.Archive
+	images   map[string]*image
+	modTimes map[string]time.Time
+}
+
+type image struct {
+	f  *file
+	sc *sidecar // nil if none found
+}
+
+// file is a file in a directory or archive.
+type file struct {
+	id   string // source id
+	name string // slash separated path within the export
+	mt   time.Time
+
+	open func() (io.ReadCloser, error)
+}
+
+// NewSource returns a new Source for the exports in paths. Paths may
+// be directories of extracted exports or Takeout archives, or directories
+// having such archives. Exports split into several archives are handled
+// as one, so that sidecars are found in archives other than their photos.
+func NewSource(paths ...string) (*Source, error) {
+	s := &Source{
+		images:   make(map[string]*image),
+		modTimes: make(map[string]time.Time),
+	}
+	var files []*file
+	for _, p := range paths {
+		fs, err := s.load(p)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		files = append(files, fs...)
+	}
+	s.pair(files)
+	return s, nil
+}
+
+// load returns the files within the export at root.
+func (s *Source) load(root string) ([]*file, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	var files []*file
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if archive.IsArchive(p) {
+			fs, err := s.loadArchive(p)
+			files = append(files, fs...)
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, &file{
+			id:   takeoutprefix + filepath.ToSlash(p),
+			name: filepath.ToSlash(rel),
+			mt:   info.ModTime(),
+			open: func() (io.ReadCloser, error) { return os.Open(p) },
+		})
+		return nil
+	})
+	return files, err
+}
+
+func (s *Source) loadArchive(p string) ([]*file, error) {
+	a, err := archive.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	s.archives = append(s.archives, a)
+	var files []*file
+	for _, af := range a.Files() {
+		mt := a.ModTime
+		if af.ModTime.After(mt) {
+			mt = af.ModTime
+		}
+		files = append(files, &file{
+			id:   takeoutprefix + filepath.ToSlash(p) + "!" + af.Name,
+			name: af.Name,
+			mt:   mt,
+			open: af.Open,
+		})
+	}
+	return files, nil
+}
+
+// pair pairs the images in files with their sidecars.
+func (s *Source) pair(files []*file) {
+	dirs := make(map[string]*sidecarSet)
+	var media []*file
+	for _, f := range files {
+		switch {
+		case strings.EqualFold(path.Ext(f.name), ".json"):
+			m, err := loadMeta(f)
+			if err != nil {
+				// not all json files are sidecars
+				continue
+			}
+			d := path.Dir(f.name)
+			if dirs[d] == nil {
+				dirs[d] = new(sidecarSet)
+			}
+			dirs[d].add(f, m)
+		case source.HasImageExt(f.name):
+			media = append(media, f)
+		}
+	}
+
+	for _, f := range media {
+		im := &image{f: f}
+		mt := f.mt
+		if set := dirs[path.Dir(f.name)]; set != nil {
+			im.sc = set.find(path.Base(f.name))
+		}
+		if im.sc != nil && im.sc.f.mt.After(mt) {
+			mt = im.sc.f.mt
+		}
+		s.images[f.id] = im
+		s.modTimes[f.id] = mt
+	}
+}
+
+func loadMeta(f *file) (*meta, error) {
+	rc, err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return parseMeta(rc)
+}
+
+func (s *Source) ModTimes() map[string]time.Time {
+	return s.modTimes
+}
+
+func (s *Source) Info(id string) (source.ImageInfo, error) {
+	im, ok := s.images[id]
+	if !ok {
+		return source.ImageInfo{}, os.ErrNotExist
+	}
+	rc, err := im.f.open()
+	if err != nil {
+		return source.ImageInfo{}, err
+	}
+	defer rc.Close()
+
+	var hooks []source.InfoHook
+	if im.sc != nil {
+		hooks = append(hooks, im.sc.m.hook())
+	} else {
+		log.Printf("takeout: no sidecar for %s", im.f.name)
+	}
+	return source.InfoFromReader(s.modTimes[id], rc, hooks...)
+}
+
+func (s *Source) Open(id string) (io.ReadCloser, error) {
+	im, ok := s.images[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return im.f.open()
+}
+
+func (s *Source) Close() error {
+	var err error
+	for _, a := range s.archives {
+		if e := a.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -2,11 +2,9 @@ package source
 
 import (
 	"bytes"
-	"errors"
 	"image"
 	"io"
 	"log"
-	"strings"
 	"sync"
 	"time"
 
@@ -15,7 +13,6 @@ import (
 
 	"github.com/bradfitz/latlong"
 	"github.com/rwcarlsen/goexif/exif"
-	"github.com/rwcarlsen/goexif/tiff"
 )
 
 type ErrNoLoc struct {
@@ -29,11 +26,55 @@ func IsNoLoc(err error) bool {
 	return ok
 }
 
+// NoLoc returns err as an *ErrNoLoc, for sources
+// reporting images without location.
+func NoLoc(err error) error {
+	return &ErrNoLoc{err}
+}
+
+// InfoHook is called by InfoFromReader to override the values
+// read from the image, eg. with metadata from a sidecar file.
+// It reports whether it has set the location in ii.
+type InfoHook func(ii *ImageInfo) (setLoc bool)
+
 // InfoFromReader initializes an ImageInfo from r.
 // If the source appears to be a valid image but has
 // no location, then the CreateTime, Width and Height are
 // initialized and an error of type *ErrNoLoc is returned.
-func InfoFromReader(mt time.Time, r io.Reader) (ImageInfo, error) {
+//
+// The hooks are called in order after the info is read from r.
+// The TimeSource is set to TimeMeta if hooks change the CreateTime
+// without setting it.
+func InfoFromReader(mt time.Time, r io.Reader, hooks ...InfoHook) (ImageInfo, error) {
+	ii, err := infoFromReader(mt, r)
+	if err != nil && !IsNoLoc(err) {
+		return ii, err
+	}
+	for _, h := range hooks {
+		ct, ts := ii.CreateTime, ii.TimeSource
+		if h(&ii) {
+			err = nil
+		}
+		if !ii.CreateTime.Equal(ct) && ii.TimeSource == ts {
+			ii.TimeSource = TimeMeta
+		}
+	}
+	return ii, err
+}
+
+func infoFromReader(mt time.Time, r io.Reader) (ImageInfo, error) {
+	ra, size, r, err := sniffRaw(r)
+	if err != nil {
+		return ImageInfo{}, err
+	}
+	if ra != nil {
+		return rawInfo(mt, ra, size)
+	}
+	hdr, r := peek(r, 8)
+	if isMP4(hdr) {
+		return videoInfo(mt, r)
+	}
+
 	buf := new(bytes.Buffer)
 	tr := io.TeeReader(r, buf)
 	cfg, _, err := image.DecodeConfig(tr)
@@ -42,6 +83,7 @@ func InfoFromReader(mt time.Time, r io.Reader) (ImageInfo, error) {
 	}
 	ii := ImageInfo{
 		CreateTime: mt, // will be overwritten with exif metadata below
+		TimeSource: TimeModTime,
 
 		Width:  cfg.Width,
 		Height: cfg.Height,
@@ -51,45 +93,57 @@ func InfoFromReader(mt time.Time, r io.Reader) (ImageInfo, error) {
 	if err != nil {
 		return ii, &ErrNoLoc{err}
 	}
+	return exifInfo(ii, x)
+}
 
-	ct, err := x.DateTime()
+// rawInfo returns the info of the TIFF based RAW file in ra.
+// The dimensions are those of the largest embedded preview.
+func rawInfo(mt time.Time, ra io.ReaderAt, size int64) (ImageInfo, error) {
+	f, err := parseRaw(ra, size)
+	if err != nil {
+		return ImageInfo{}, err
+	}
+	_, cfg, err := f.preview()
 	if err != nil {
-		ii.CreateTime = ct
+		return ImageInfo{}, err
 	}
+	ii := ImageInfo{
+		CreateTime: mt, // will be overwritten with exif metadata below
+		TimeSource: TimeModTime,
 
-	ii.Lat, ii.Long, err = x.LatLong()
+		Width:  cfg.Width,
+		Height: cfg.Height,
+	}
+	x, err := exif.Decode(f.meta())
 	if err != nil {
 		return ii, &ErrNoLoc{err}
 	}
+	return exifInfo(ii, x)
+}
 
-	// add CreateTime timezone based on exif lat/long
-	if loc := lookupLocation(latlong.LookupZoneName(ii.Lat, ii.Long)); loc != nil {
-		if t, err := exifDateTimeInLocation(x, loc); err == nil {
-			ii.CreateTime = t
-		}
+// exifInfo sets the create time, camera metadata and location of ii from x.
+func exifInfo(ii ImageInfo, x *exif.Exif) (ImageInfo, error) {
+	ii.Camera = exifCamera(x)
+	ii.Altitude = exifAltitude(x)
+	ii.Direction, ii.Magnetic = exifDirection(x)
+
+	var lerr error
+	ii.Lat, ii.Long, lerr = x.LatLong()
+
+	if t, src, err := captureTime(x, lerr == nil, ii.Lat, ii.Long); err == nil {
+		ii.CreateTime, ii.TimeSource = t, src
 	}
 
+	if lerr != nil {
+		return ii, &ErrNoLoc{lerr}
+	}
 	return ii, nil
 }
 
-// This is basically a copy of the exif.Exif.DateTime() method, except:
-//   * it takes a *time.Location to assume
-//   * the caller already assumes there's no timezone offset or GPS time
-//     in the EXIF, so any of that code can be ignored.
-func exifDateTimeInLocation(x *exif.Exif, loc *time.Location) (time.Time, error) {
-	tag, err := x.Get(exif.DateTimeOriginal)
-	if err != nil {
-		tag, err = x.Get(exif.DateTime)
-		if err != nil {
-			return time.Time{}, err
-		}
-	}
-	if tag.Format() != tiff.StringVal {
-		return time.Time{}, errors.New("DateTime[Original] not in string format")
-	}
-	const exifTimeLayout = "2006:01:02 15:04:05"
-	dateStr := strings.TrimRight(string(tag.Val), "\x00")
-	return time.ParseInLocation(exifTimeLayout, dateStr, loc)
+// LookupLocation returns the time zone at lat, long,
+// or nil if it is unknown.
+func LookupLocation(lat, long float64) *time.Location {
+	return lookupLocation(latlong.LookupZoneName(lat, long))
 }
 
 var zoneCache struct {
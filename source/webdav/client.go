@@ -0,0 +1,164 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+// client is a minimal WebDAV client.
+type client struct {
+	user, pass string
+	hc         *http.Client
+}
+
+// resource is a file or collection on the server.
+type resource struct {
+	u       *url.URL
+	dir     bool
+	modTime time.Time
+	etag    string
+	size    int64
+}
+
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				LastModified  string `xml:"getlastmodified"`
+				ETag          string `xml:"getetag"`
+				ContentLength int64  `xml:"getcontentlength"`
+				ResourceType  struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:">
+  <d:prop>
+    <d:resourcetype/>
+    <d:getlastmodified/>
+    <d:getetag/>
+    <d:getcontentlength/>
+  </d:prop>
+</d:propfind>`
+
+// list returns the members of the collection at u. Depth 1 is used,
+// because many servers don't support Depth infinity.
+func (c *client) list(u *url.URL) ([]resource, error) {
+	req, err := http.NewRequest("PROPFIND", u.String(), strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", `application/xml; charset="utf-8"`)
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("webdav: PROPFIND %s: %s", u, resp.Status)
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("webdav: PROPFIND %s: %v", u, err)
+	}
+	var v []resource
+	for _, r := range ms.Responses {
+		ru, err := u.Parse(r.Href)
+		if err != nil {
+			continue
+		}
+		if samePath(ru.Path, u.Path) {
+			// the collection itself
+			continue
+		}
+		res := resource{u: ru}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			p := ps.Prop
+			res.dir = res.dir || p.ResourceType.Collection != nil
+			if t, err := http.ParseTime(p.LastModified); err == nil {
+				res.modTime = t
+			}
+			if p.ETag != "" {
+				res.etag = p.ETag
+			}
+			if p.ContentLength != 0 {
+				res.size = p.ContentLength
+			}
+		}
+		v = append(v, res)
+	}
+	return v, nil
+}
+
+func samePath(a, b string) bool {
+	return strings.TrimRight(a, "/") == strings.TrimRight(b, "/")
+}
+
+// getRange returns the bytes [off, off+n) of r. The request fails
+// if the ETag of r has changed. Servers ignoring the Range
+// header are handled by skipping the unneeded bytes.
+func (c *client) getRange(ctx context.Context, r *resource, off, n int64) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", r.u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	if r.etag != "" {
+		req.Header.Set("If-Match", r.etag)
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, off); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(resp.Body, n), resp.Body}, nil
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("webdav: GET %s: %s", r.u, resp.Status)
+}
+
+// reader returns a reader of r using range
+// requests that are cancelled when ctx is done.
+func (c *client) reader(ctx context.Context, r *resource) *source.RangeReader {
+	return source.NewRangeReader(r.size, func(off, n int64) (io.ReadCloser, error) {
+		return c.getRange(ctx, r, off, n)
+	})
+}
+
+func (c *client) do(req *http.Request) (*http.Response, error) {
+	if c.user != "" || c.pass != "" {
+		req.SetBasicAuth(c.user, c.pass)
+	}
+	return c.hc.Do(req)
+}
@@ -0,0 +1,168 @@
+package webdav
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+// fakeDAV is a WebDAV server supporting only Depth 1 listings.
+type fakeDAV struct {
+	t     *testing.T
+	files map[string][]byte // by path
+	mt    time.Time
+}
+
+func (d *fakeDAV) etag(p string) string {
+	return fmt.Sprintf(`"%x"`, len(d.files[p]))
+}
+
+func (d *fakeDAV) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if u, p, ok := req.BasicAuth(); !ok || u != "alice" || p != "app-password" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch req.Method {
+	case "PROPFIND":
+		d.propfind(w, req)
+	case "GET":
+		data, ok := d.files[req.URL.Path]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		if req.Header.Get("Range") == "" {
+			d.t.Errorf("GET %s without range", req.URL.Path)
+		}
+		w.Header().Set("ETag", d.etag(req.URL.Path))
+		http.ServeContent(w, req, path.Base(req.URL.Path), d.mt, bytes.NewReader(data))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (d *fakeDAV) propfind(w http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Depth") != "1" {
+		http.Error(w, "depth not supported", http.StatusForbidden)
+		return
+	}
+	dir := req.URL.Path
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	members := make(map[string]bool) // name to isDir
+	for p := range d.files {
+		if !strings.HasPrefix(p, dir) {
+			continue
+		}
+		rest := p[len(dir):]
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			members[rest[:i]] = true
+		} else {
+			members[rest] = false
+		}
+	}
+	if len(members) == 0 {
+		http.NotFound(w, req)
+		return
+	}
+	var names []string
+	for n := range members {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	lm := d.mt.Format(http.TimeFormat)
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	fmt.Fprint(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:">`)
+	fmt.Fprintf(w, `<d:response><d:href>%s</d:href><d:propstat><d:prop>`+
+		`<d:resourcetype><d:collection/></d:resourcetype></d:prop>`+
+		`<d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`, dir)
+	for _, n := range names {
+		href := (&url.URL{Path: dir + n}).EscapedPath()
+		if members[n] {
+			fmt.Fprintf(w, `<d:response><d:href>%s/</d:href><d:propstat><d:prop>`+
+				`<d:resourcetype><d:collection/></d:resourcetype><d:getlastmodified>%s</d:getlastmodified>`+
+				`</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`, href, lm)
+			continue
+		}
+		p := dir + n
+		fmt.Fprintf(w, `<d:response><d:href>%s</d:href><d:propstat><d:prop>`+
+			`<d:resourcetype/><d:getlastmodified>%s</d:getlastmodified>`+
+			`<d:getetag>%s</d:getetag><d:getcontentlength>%d</d:getcontentlength>`+
+			`</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>`+
+			`<d:propstat><d:prop><d:quota-used-bytes/></d:prop>`+
+			`<d:status>HTTP/1.1 404 Not Found</d:status></d:propstat></d:response>`,
+			href, lm, d.etag(p), len(d.files[p]))
+	}
+	fmt.Fprint(w, `</d:multistatus>`)
+}
+
+func TestSource(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, image.NewGray(image.Rect(0, 0, 32, 24)), nil); err != nil {
+		t.Fatal(err)
+	}
+	photo := buf.Bytes()
+
+	const root = "/remote.php/dav/files/alice/Photos"
+	d := &fakeDAV{
+		t: t,
+		files: map[string][]byte{
+			root + "/a.jpg":                         photo,
+			root + "/notes.txt":                     []byte("not a photo"),
+			root + "/2016/Summer trip/b.jpg":        photo,
+			root + "/2016/c.png":                    []byte("broken"),
+			"/remote.php/dav/files/alice/other.jpg": photo,
+		},
+		mt: time.Date(2016, 7, 1, 10, 0, 0, 0, time.UTC),
+	}
+	srv := httptest.NewServer(d)
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL + root)
+	u.User = url.UserPassword("alice", "app-password")
+	s, err := NewSource(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	var ids []string
+	for id := range s.ModTimes() {
+		if strings.Contains(id, "app-password") {
+			t.Errorf("password in id %s", id)
+		}
+		ids = append(ids, strings.TrimPrefix(id, davprefix+srv.URL+root))
+	}
+	sort.Strings(ids)
+	want := []string{"/2016/Summer%20trip/b.jpg", "/2016/c.png", "/a.jpg"}
+	if fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Fatalf("got ids %v, want %v", ids, want)
+	}
+
+	id := davprefix + srv.URL + root + "/2016/Summer%20trip/b.jpg"
+	ii, err := s.Info(id)
+	if err != nil && !source.IsNoLoc(err) {
+		t.Fatal(err)
+	}
+	if ii.Width != 32 || ii.Height != 24 {
+		t.Errorf("size is %dx%d, want 32x24", ii.Width, ii.Height)
+	}
+	if mt := s.ModTimes()[id]; !mt.Truncate(time.Second).Equal(d.mt) {
+		t.Errorf("modtime is %v, want %v", mt, d.mt)
+	}
+}
@@ -0,0 +1,282 @@
+package xmp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+const nsRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+
+// emptySidecar is used for new sidecars.
+const emptySidecar = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:exif="http://ns.adobe.com/exif/1.0/"/>
+ </rdf:RDF>
+</x:xmpmeta>
+`
+
+// WriteSidecar applies e to the sidecar of the image at path, and
+// returns the path of the sidecar. A new sidecar is created if the
+// image has none yet. The sidecar is replaced atomically.
+func WriteSidecar(path string, e source.Edit) (string, error) {
+	sp, fi, ok := FindSidecar(path)
+	doc := []byte(emptySidecar)
+	perm := os.FileMode(0644)
+	if ok {
+		var err error
+		if doc, err = ioutil.ReadFile(sp); err != nil {
+			return "", err
+		}
+		perm = fi.Mode().Perm()
+	} else {
+		sp = SidecarNames(path)[0]
+	}
+
+	doc, err := Update(doc, e)
+	if err != nil {
+		return "", fmt.Errorf("xmp: %s: %v", sp, err)
+	}
+	return sp, writeFileAtomic(sp, doc, perm)
+}
+
+// Update applies e to the XMP document doc. Properties not affected
+// by e are left untouched in the result.
+func Update(doc []byte, e source.Edit) ([]byte, error) {
+	var set []prop
+	var del []xml.Name
+	if e.Loc != nil || e.ClearLoc {
+		del = append(del, gpsLatitude, gpsLongitude)
+	}
+	if e.Loc != nil {
+		set = append(set,
+			prop{gpsLatitude, formatCoord(e.Loc.Lat, 'N', 'S')},
+			prop{gpsLongitude, formatCoord(e.Loc.Long, 'E', 'W')})
+	}
+	if !e.CreateTime.IsZero() {
+		del = append(del, dateTimeOriginal)
+		set = append(set, prop{dateTimeOriginal, e.CreateTime.Format(time.RFC3339)})
+	}
+	if len(del) == 0 {
+		return doc, nil
+	}
+
+	sc, err := scan(doc, del)
+	if err != nil {
+		return nil, err
+	}
+	if sc.desc < 0 {
+		return nil, errors.New("no rdf:Description")
+	}
+
+	edits := sc.del
+
+	// add new properties as attributes of the first rdf:Description
+	ins := new(bytes.Buffer)
+	pfx := sc.prefix(nsExif)
+	if pfx == "" {
+		pfx = "exif"
+		fmt.Fprintf(ins, "\n    xmlns:%s=%q", pfx, nsExif)
+	}
+	for _, p := range set {
+		fmt.Fprintf(ins, "\n   %s:%s=%q", pfx, p.name.Local, p.value)
+	}
+	edits = append(edits, edit{sc.desc, sc.desc, ins.String()})
+
+	sort.Sort(editsByPos(edits))
+	out := new(bytes.Buffer)
+	pos := 0
+	for _, ed := range edits {
+		out.Write(doc[pos:ed.start])
+		out.WriteString(ed.repl)
+		pos = ed.end
+	}
+	out.Write(doc[pos:])
+	return out.Bytes(), nil
+}
+
+type prop struct {
+	name  xml.Name
+	value string
+}
+
+// edit replaces doc[start:end] with repl
+type edit struct {
+	start, end int
+	repl       string
+}
+
+type editsByPos []edit
+
+func (s editsByPos) Len() int           { return len(s) }
+func (s editsByPos) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s editsByPos) Less(i, j int) bool { return s[i].start < s[j].start }
+
+type scanResult struct {
+	ns map[string]string // namespace prefixes
+
+	desc int // insert position within the first rdf:Description start tag, or -1
+
+	del []edit // removal of properties
+}
+
+func (sc *scanResult) prefix(space string) string {
+	for p, s := range sc.ns {
+		if s == space {
+			return p
+		}
+	}
+	return ""
+}
+
+var attrRx = regexp.MustCompile(`^\s+([\w.-]+(?::[\w.-]+)?)\s*=\s*("[^"]*"|'[^']*')`)
+
+// scan finds the location of the properties in del within doc.
+// Namespace prefixes are assumed to be unique within doc,
+// which is true for sidecars written by common tools.
+func scan(doc []byte, del []xml.Name) (*scanResult, error) {
+	sc := &scanResult{ns: make(map[string]string), desc: -1}
+	isDel := func(n xml.Name) bool {
+		for _, d := range del {
+			if n == d {
+				return true
+			}
+		}
+		return false
+	}
+	resolve := func(n xml.Name) xml.Name {
+		if n.Space != "" {
+			n.Space = sc.ns[n.Space]
+		}
+		return n
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(doc))
+	elemStart := -1 // start of property element to remove
+	elemDepth := 0  // nesting level within element to remove
+	for {
+		off0 := int(d.InputOffset())
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		off1 := int(d.InputOffset())
+		switch t := tok.(type) {
+		case xml.StartElement:
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "xmlns":
+					sc.ns[a.Name.Local] = a.Value
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					sc.ns[""] = a.Value
+				}
+			}
+			name := resolve(t.Name)
+			tag := doc[off0:off1]
+			if elemStart >= 0 {
+				elemDepth++
+				break
+			}
+			if isDel(name) {
+				elemStart, elemDepth = off0, 1
+			}
+
+			// find attribute spans within start tag
+			i := 1 + len(rawName(t.Name))
+			for {
+				m := attrRx.FindSubmatchIndex(tag[i:])
+				if m == nil {
+					break
+				}
+				an := string(tag[i+m[2] : i+m[3]])
+				if isDel(resolve(parseRawName(an))) {
+					sc.del = append(sc.del, edit{off0 + i, off0 + i + m[1], ""})
+				}
+				i += m[1]
+			}
+
+			if sc.desc < 0 && name == (xml.Name{Space: nsRDF, Local: "Description"}) {
+				end := off1 - 1
+				if bytes.HasSuffix(tag, []byte("/>")) {
+					end--
+				}
+				sc.desc = end
+			}
+		case xml.EndElement:
+			if elemStart < 0 {
+				break
+			}
+			elemDepth--
+			if elemDepth == 0 {
+				sc.del = append(sc.del, edit{elemStart, off1, ""})
+				elemStart = -1
+			}
+		}
+	}
+	return sc, nil
+}
+
+func rawName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+func parseRawName(s string) xml.Name {
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		return xml.Name{Space: s[:i], Local: s[i+1:]}
+	}
+	return xml.Name{Local: s}
+}
+
+// formatCoord formats v as an XMP GPSCoordinate "DDD,MM.mmmmmmk".
+func formatCoord(v float64, pos, neg byte) string {
+	dir := pos
+	if v < 0 {
+		dir, v = neg, -v
+	}
+	deg := math.Floor(v)
+	min := (v - deg) * 60
+	return fmt.Sprintf("%d,%.6f%c", int(deg), min, dir)
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".photomap-xmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,253 @@
+// Package xmp reads image metadata from XMP sidecar files.
+package xmp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+const (
+	nsExif = "http://ns.adobe.com/exif/1.0/"
+	nsXMP  = "http://ns.adobe.com/xap/1.0/"
+)
+
+var (
+	gpsLatitude      = xml.Name{Space: nsExif, Local: "GPSLatitude"}
+	gpsLongitude     = xml.Name{Space: nsExif, Local: "GPSLongitude"}
+	dateTimeOriginal = xml.Name{Space: nsExif, Local: "DateTimeOriginal"}
+	rating           = xml.Name{Space: nsXMP, Local: "Rating"}
+)
+
+// Meta is the metadata in a sidecar
+// that is relevant to photomap.
+type Meta struct {
+	// HasLoc reports whether Lat and Long are valid.
+	HasLoc bool
+	Lat    float64
+	Long   float64
+
+	// CreateTime is the time the photo was taken, or the zero time
+	// if the sidecar has none. If the sidecar value had no zone,
+	// then CreateTime is in UTC and HasZone is false.
+	CreateTime time.Time
+	HasZone    bool
+
+	// HasRating reports whether Rating is valid.
+	HasRating bool
+	Rating    int
+}
+
+// IsSidecar reports whether path looks like an XMP sidecar.
+func IsSidecar(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".xmp")
+}
+
+// SidecarNames returns the possible sidecar paths for the file at path
+// in order of preference: <file>.xmp is used by eg. Darktable,
+// and <basename>.xmp is used by eg. Lightroom.
+func SidecarNames(path string) []string {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	return []string{
+		path + ".xmp",
+		path + ".XMP",
+		base + ".xmp",
+		base + ".XMP",
+	}
+}
+
+// FindSidecar returns the path and FileInfo of the sidecar
+// of the file at path. It reports false if there is none.
+func FindSidecar(path string) (string, os.FileInfo, bool) {
+	for _, n := range SidecarNames(path) {
+		if fi, err := os.Stat(n); err == nil && fi.Mode().IsRegular() {
+			return n, fi, true
+		}
+	}
+	return "", nil, false
+}
+
+// LoadSidecar loads the sidecar metadata of the file at path.
+// It returns nil and no error if there is no sidecar.
+func LoadSidecar(path string) (*Meta, error) {
+	sp, _, ok := FindSidecar(path)
+	if !ok {
+		return nil, nil
+	}
+	f, err := os.Open(sp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("xmp: %s: %v", sp, err)
+	}
+	return m, nil
+}
+
+// Parse parses the XMP document in r.
+func Parse(r io.Reader) (*Meta, error) {
+	props, err := readProps(r)
+	if err != nil {
+		return nil, err
+	}
+
+	m := new(Meta)
+	slat, ok1 := props[gpsLatitude]
+	slong, ok2 := props[gpsLongitude]
+	if ok1 && ok2 {
+		lat, err1 := parseCoord(slat, 'N', 'S')
+		long, err2 := parseCoord(slong, 'E', 'W')
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid gps position %q, %q", slat, slong)
+		}
+		m.HasLoc, m.Lat, m.Long = true, lat, long
+	}
+	if s, ok := props[dateTimeOriginal]; ok {
+		m.CreateTime, m.HasZone, err = parseTime(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s, ok := props[rating]; ok {
+		// some tools write ratings as reals
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rating %q", s)
+		}
+		m.HasRating, m.Rating = true, int(v)
+	}
+	return m, nil
+}
+
+// Hook returns a source.InfoHook that overrides
+// image info with the values in m.
+func (m *Meta) Hook() source.InfoHook {
+	return func(ii *source.ImageInfo) bool {
+		if m.HasLoc {
+			ii.Lat, ii.Long = m.Lat, m.Long
+			ii.LocSource = "xmp"
+		}
+		if !m.CreateTime.IsZero() {
+			t := m.CreateTime
+			if !m.HasZone {
+				loc := source.LookupLocation(ii.Lat, ii.Long)
+				if loc == nil {
+					loc = time.Local
+				}
+				t = time.Date(t.Year(), t.Month(), t.Day(),
+					t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+			}
+			ii.CreateTime = t
+		}
+		if m.HasRating {
+			ii.Rating = m.Rating
+		}
+		return m.HasLoc
+	}
+}
+
+// readProps returns the values of simple XMP properties in r,
+// whether they are specified as attributes or elements.
+func readProps(r io.Reader) (map[xml.Name]string, error) {
+	props := make(map[xml.Name]string)
+	d := xml.NewDecoder(r)
+	text := new(bytes.Buffer)
+	leaf := false
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			for _, a := range t.Attr {
+				props[a.Name] = a.Value
+			}
+			text.Reset()
+			leaf = true
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if leaf {
+				props[t.Name] = strings.TrimSpace(text.String())
+			}
+			leaf = false
+		}
+	}
+	return props, nil
+}
+
+// parseCoord parses an XMP GPSCoordinate value
+// in the form "DDD,MM,SSk", "DDD,MM.mmk" or decimal degrees.
+func parseCoord(s string, pos, neg byte) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("empty coordinate")
+	}
+	sign := 1.0
+	switch s[len(s)-1] {
+	case pos:
+		s = s[:len(s)-1]
+	case neg:
+		s = s[:len(s)-1]
+		sign = -1
+	}
+	var v float64
+	div := 1.0
+	for i, p := range strings.Split(s, ",") {
+		if i > 2 {
+			return 0, errors.New("invalid coordinate")
+		}
+		f, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return 0, err
+		}
+		v += f / div
+		div *= 60
+	}
+	return sign * v, nil
+}
+
+var (
+	zoneLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04Z07:00",
+	}
+	localLayouts = []string{
+		"2006-01-02T15:04:05.999999999",
+		"2006-01-02T15:04",
+		"2006-01-02",
+		"2006:01:02 15:04:05", // exif style used by some tools
+	}
+)
+
+// parseTime parses an XMP date, and reports
+// whether it had a time zone specified.
+func parseTime(s string) (t time.Time, hasZone bool, err error) {
+	s = strings.TrimSpace(s)
+	for _, l := range zoneLayouts {
+		if t, err = time.Parse(l, s); err == nil {
+			return t, true, nil
+		}
+	}
+	for _, l := range localLayouts {
+		if t, err = time.ParseInLocation(l, s, time.UTC); err == nil {
+			return t, false, nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("invalid date %q", s)
+}
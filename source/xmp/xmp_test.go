@@ -0,0 +1,105 @@
+package xmp
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tajtiattila/photomap/source"
+)
+
+const attrXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:exif="http://ns.adobe.com/exif/1.0/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+   exif:DateTimeOriginal="2016-05-01T10:20:30.5+02:00"
+   exif:GPSLatitude="47,30.6N"
+   exif:GPSLongitude="19,2,30W"
+   xmp:Rating="4"/>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+const elemXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:exif="http://ns.adobe.com/exif/1.0/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/">
+   <exif:DateTimeOriginal>2016-05-01T10:20:30</exif:DateTimeOriginal>
+   <xmp:Rating>-1</xmp:Rating>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+func TestParse(t *testing.T) {
+	m, err := Parse(strings.NewReader(attrXMP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.HasLoc || math.Abs(m.Lat-47.51) > 1e-9 || math.Abs(m.Long+(19+2.5/60)) > 1e-9 {
+		t.Errorf("got location %v %v,%v", m.HasLoc, m.Lat, m.Long)
+	}
+	want := time.Date(2016, 5, 1, 8, 20, 30, 5e8, time.UTC)
+	if !m.HasZone || !m.CreateTime.Equal(want) {
+		t.Errorf("got time %v (zone %v), want %v", m.CreateTime, m.HasZone, want)
+	}
+	if !m.HasRating || m.Rating != 4 {
+		t.Errorf("got rating %v %v", m.HasRating, m.Rating)
+	}
+
+	m, err = Parse(strings.NewReader(elemXMP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.HasLoc {
+		t.Error("unexpected location")
+	}
+	want = time.Date(2016, 5, 1, 10, 20, 30, 0, time.UTC)
+	if m.HasZone || !m.CreateTime.Equal(want) {
+		t.Errorf("got time %v (zone %v), want %v", m.CreateTime, m.HasZone, want)
+	}
+	if !m.HasRating || m.Rating != -1 {
+		t.Errorf("got rating %v %v", m.HasRating, m.Rating)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	ct := time.Date(2016, 6, 1, 12, 0, 0, 0, time.FixedZone("", 3600))
+	e := source.Edit{
+		Loc:        &source.Loc{Lat: -33.5, Long: 151.25},
+		CreateTime: ct,
+	}
+	for _, doc := range []string{attrXMP, elemXMP, emptySidecar} {
+		out, err := Update([]byte(doc), e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := Parse(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("%v in\n%s", err, out)
+		}
+		if !m.HasLoc || math.Abs(m.Lat+33.5) > 1e-6 || math.Abs(m.Long-151.25) > 1e-6 {
+			t.Errorf("got location %v %v,%v in\n%s", m.HasLoc, m.Lat, m.Long, out)
+		}
+		if !m.CreateTime.Equal(ct) {
+			t.Errorf("got time %v, want %v in\n%s", m.CreateTime, ct, out)
+		}
+		if strings.Contains(doc, "Rating") && !m.HasRating {
+			t.Errorf("rating lost in\n%s", out)
+		}
+	}
+
+	out, err := Update([]byte(attrXMP), source.Edit{ClearLoc: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Parse(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.HasLoc || !m.HasRating {
+		t.Errorf("location not cleared in\n%s", out)
+	}
+}
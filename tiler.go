@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image"
 	"image/color"
@@ -12,8 +13,13 @@ import (
 	"math"
 	"math/rand"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/tajtiattila/photomap/clusterer"
+	"github.com/tajtiattila/photomap/gazetteer"
 	"github.com/tajtiattila/photomap/imagecache"
 	"github.com/tajtiattila/photomap/quadtree"
 
@@ -26,68 +32,185 @@ type TileMap struct {
 	Lat, Long   float64 // center of boundary of all photos
 	Dlat, Dlong float64 // size of boundary in lat/long direction
 
-	ic     *imagecache.ImageCache
+	ic  *imagecache.ImageCache
+	gaz *gazetteer.Gazetteer // names photo places, may be nil
+
+	d atomic.Value // *tileData, swapped on change
+
+	refreshMtx sync.Mutex // serializes Refresh
+
+	spotg    singleflight.Group
+	photog   singleflight.Group
+	headingg singleflight.Group
+
+	emptyTile []byte // empty tile in png format
+
+	spot *image.RGBA // photo spot image
+}
+
+// tileData is the set of photos shown along with their indexes.
+// Its photos and indexes are never modified after creation.
+type tileData struct {
 	images []imagecache.ImageInfo
 
 	qt   *quadtree.Quadtree // for photo spots
 	tree *clusterer.Tree    // for photo piles
 
-	spotg  singleflight.Group
-	photog singleflight.Group
+	modTime time.Time // creation time
 
-	emptyTile []byte // empty tile in png format
+	collMtx sync.Mutex
+	colls   map[string]*tileData // photos of collections, made on demand
+}
 
-	spot *image.RGBA // photo spot image
+// maxCollData is the number of collections
+// kept by tileData.collection.
+const maxCollData = 32
+
+// collection returns the data of the photos in collection c, or in the
+// collections within the collection set c. It returns d if c is empty.
+func (d *tileData) collection(c string) *tileData {
+	if c == "" {
+		return d
+	}
+	d.collMtx.Lock()
+	defer d.collMtx.Unlock()
+	if cd, ok := d.colls[c]; ok {
+		return cd
+	}
+	var images []imagecache.ImageInfo
+	for _, ii := range d.images {
+		if inCollection(ii.Collections, c) {
+			images = append(images, ii)
+		}
+	}
+	cd := newTileData(images)
+	cd.modTime = d.modTime
+	if d.colls == nil {
+		d.colls = make(map[string]*tileData)
+	}
+	if len(d.colls) < maxCollData {
+		d.colls[c] = cd
+	}
+	return cd
+}
+
+// inCollection reports whether collections has c,
+// or a collection within the collection set c.
+func inCollection(collections []string, c string) bool {
+	for _, x := range collections {
+		if x == c || strings.HasPrefix(x, c+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func newTileData(images []imagecache.ImageInfo) *tileData {
+	d := &tileData{images: images, modTime: time.Now()}
+	if len(images) != 0 {
+		d.qt = quadtree.New(iiarr(images), quadtree.MinDist(photoMinSep))
+		d.tree = clusterer.NewTree(iiarr(images), photoMinSep)
+	}
+	return d
 }
 
 const photoMinSep = 5e-5 // ~5 meters on equator
 const spotSize = 16
 
-func NewTileMap(ic *imagecache.ImageCache) *TileMap {
-	images := ic.Images()
-	if len(images) == 0 {
-		log.Fatal("empty image cache")
-	}
+func NewTileMap(ic *imagecache.ImageCache, gaz *gazetteer.Gazetteer) *TileMap {
 	tm := &TileMap{
-		ic:     ic,
-		qt:     quadtree.New(iiarr(images), quadtree.MinDist(photoMinSep)),
-		tree:   clusterer.NewTree(iiarr(images), photoMinSep),
-		images: images,
+		ic:  ic,
+		gaz: gaz,
 
 		emptyTile: pngBytes(image.NewNRGBA(image.Rect(0, 0, TileSize, TileSize))),
 		spot:      blurrySpot(color.NRGBA{255, 0, 0, 64}, spotSize),
 	}
+	tm.d.Store(newTileData(ic.Images()))
 	tm.findStartLocation()
 	return tm
 }
 
-func (tm *TileMap) PhotoTile(x, y, zoom int) []byte {
-	k := fmt.Sprintf("%d|%d|%d", x, y, zoom)
+// Refresh updates tm with the current images of the image cache.
+// Requests being served during the update use the previous data,
+// and tiles made from it are dropped afterwards. Concurrent calls are
+// serialized so that the data of the latest images is kept.
+func (tm *TileMap) Refresh() {
+	tm.refreshMtx.Lock()
+	defer tm.refreshMtx.Unlock()
+	tm.d.Store(newTileData(tm.ic.Images()))
+}
+
+// ModTime returns the time tm was last changed.
+func (tm *TileMap) ModTime() time.Time {
+	return tm.data("").modTime
+}
+
+// data returns the current data of the photos in collection coll,
+// or of all photos if coll is empty.
+func (tm *TileMap) data(coll string) *tileData {
+	return tm.d.Load().(*tileData).collection(coll)
+}
+
+// PhotoTile returns a tile with the piles of photos in coll.
+// Photo icons are loaded from the image cache using ctx,
+// and nil is returned if ctx is done before the tile is ready.
+func (tm *TileMap) PhotoTile(ctx context.Context, x, y, zoom int, coll string) []byte {
+	d := tm.data(coll)
+	k := fmt.Sprintf("%d|%d|%d|%d|%s", x, y, zoom, d.modTime.UnixNano(), coll)
+
+	for {
+		r, err := tm.photog.Do(k, func() (interface{}, error) {
+			return tm.photoTile(ctx, d, x, y, zoom), ctx.Err()
+		})
+		switch {
+		case err == nil:
+			return r.([]byte)
+		case ctx.Err() != nil:
+			return nil
+		}
+		// the tile was shared with a request that has gone away
+	}
+}
 
-	r, _ := tm.photog.Do(k, func() (interface{}, error) {
-		return tm.photoTile(x, y, zoom), nil
+// SpotsTile returns a tile with spots of the photos in coll.
+// It reads no image sources, so ctx is not used.
+func (tm *TileMap) SpotsTile(ctx context.Context, x, y, zoom int, coll string) []byte {
+	d := tm.data(coll)
+	k := fmt.Sprintf("%d|%d|%d|%d|%s", x, y, zoom, d.modTime.UnixNano(), coll)
+
+	r, _ := tm.spotg.Do(k, func() (interface{}, error) {
+		return tm.spotsTile(d, x, y, zoom), nil
 	})
 
 	return r.([]byte)
 }
 
-func (tm *TileMap) SpotsTile(x, y, zoom int) []byte {
-	k := fmt.Sprintf("%d|%d|%d", x, y, zoom)
+// HeadingTile returns a tile with wedges showing
+// the direction the camera faced for each photo in coll.
+// It reads no image sources, so ctx is not used.
+func (tm *TileMap) HeadingTile(ctx context.Context, x, y, zoom int, coll string) []byte {
+	d := tm.data(coll)
+	k := fmt.Sprintf("%d|%d|%d|%d|%s", x, y, zoom, d.modTime.UnixNano(), coll)
 
-	r, _ := tm.spotg.Do(k, func() (interface{}, error) {
-		return tm.spotsTile(x, y, zoom), nil
+	r, _ := tm.headingg.Do(k, func() (interface{}, error) {
+		return tm.headingTile(d, x, y, zoom), nil
 	})
 
 	return r.([]byte)
 }
 
-// PhotoPlaces returns clickable places with galleries within the requested boundary,
-// along with
-func (tm *TileMap) PhotoPlaces(la0, lo0, la1, lo1 float64, zoom int) ([]LatLong, float64) {
+// PhotoPlaces returns clickable places with galleries of photos in coll
+// within the requested boundary, along with their radius.
+func (tm *TileMap) PhotoPlaces(la0, lo0, la1, lo1 float64, zoom int, coll string) ([]PhotoPlace, float64) {
+	d := tm.data(coll)
+	if d.tree == nil {
+		return nil, 0
+	}
 	zd := zoomdist(zoom)
-	var r []LatLong
-	tm.tree.Query(lo0, lat2merc(la0), lo1, lat2merc(la1), zd, func(pt clusterer.Point, images []int) {
-		r = append(r, LatLong{merc2lat(pt.Y), pt.X})
+	var r []PhotoPlace
+	d.tree.Query(lo0, lat2merc(la0), lo1, lat2merc(la1), zd, func(pt clusterer.Point, images []int) {
+		lat := merc2lat(pt.Y)
+		r = append(r, PhotoPlace{LatLong{lat, pt.X}, tm.place(lat, pt.X, zd/2)})
 	})
 	return r, zd / 2
 }
@@ -97,36 +220,145 @@ type LatLong struct {
 	Long float64 `json:"long"`
 }
 
-// Gallery returns the ids to show in a gallery at the given location.
-func (tm *TileMap) Gallery(lat, long float64, zoom int) []string {
+// PhotoPlace is a pile of photos on the map.
+type PhotoPlace struct {
+	LatLong
+
+	// nearest place in the gazetteer, nil if unknown
+	Place *gazetteer.Place
+}
+
+// maxNamedRadius is the radius of photo piles in degrees
+// above which they are not named, as they span many places.
+const maxNamedRadius = 0.5
+
+// place returns the nearest place in tm.gaz to a pile of photos
+// at lat, long having radius r, or nil if it is unknown.
+func (tm *TileMap) place(lat, long, r float64) *gazetteer.Place {
+	if tm.gaz == nil || r > maxNamedRadius {
+		return nil
+	}
+	p, ok := tm.gaz.Nearest(lat, long, gazetteer.NearDist)
+	if !ok {
+		return nil
+	}
+	return &p
+}
+
+// Gallery returns the ids of photos in coll to show in a gallery
+// at the given location, and the place nearest to them.
+func (tm *TileMap) Gallery(lat, long float64, zoom int, coll string) ([]string, *gazetteer.Place) {
+	d := tm.data(coll)
+	if d.tree == nil {
+		return nil, nil
+	}
 	zd := zoomdist(zoom)
 	m := lat2merc(lat)
 	r := zd / 2
 	var im []int
+	var center clusterer.Point
 	var bestdist float64
-	tm.tree.Query(long-r, m-r, long+r, m+r, zd, func(pt clusterer.Point, images []int) {
+	d.tree.Query(long-r, m-r, long+r, m+r, zd, func(pt clusterer.Point, images []int) {
 		dx, dy := long-pt.X, m-pt.Y
 		d := dx*dx + dy*dy
 		if im == nil || d < bestdist {
 			im = images
+			center = pt
 			bestdist = d
 		}
 	})
 	if im == nil {
-		return nil
+		return nil, nil
 	}
 	iiv := make([]imagecache.ImageInfo, 0, len(im))
 	for _, i := range im {
-		iiv = append(iiv, tm.images[i])
+		iiv = append(iiv, d.images[i])
 	}
 	sort.Sort(iiByDate(iiv))
 	refs := make([]string, len(iiv))
 	for i, ii := range iiv {
 		refs[i] = ii.Id
 	}
-	return refs
+	return refs, tm.place(merc2lat(center.Y), center.X, r)
 }
 
+// placeRadius is the radius around places in degrees of arc
+// within which photos are counted by SearchPlaces.
+const placeRadius = 0.2 // ~22 km
+
+// FoundPlace is a place found by SearchPlaces.
+type FoundPlace struct {
+	gazetteer.Place
+
+	// photos within placeRadius
+	Photos int `json:"photos"`
+
+	// bounds of the photos, or the area around the place without photos
+	Bounds Bounds `json:"bounds"`
+}
+
+// Bounds is a rectangle on the map, like google.maps.LatLngBoundsLiteral.
+type Bounds struct {
+	South float64 `json:"south"`
+	West  float64 `json:"west"`
+	North float64 `json:"north"`
+	East  float64 `json:"east"`
+}
+
+// SearchPlaces returns at most n places in the gazetteer matching q.
+// Places are ranked by the number of photos in coll around them,
+// then by how well they match q.
+func (tm *TileMap) SearchPlaces(q string, n int, coll string) []FoundPlace {
+	if tm.gaz == nil {
+		return []FoundPlace{}
+	}
+	m := tm.gaz.Search(q)
+	d := tm.data(coll)
+	// count photos around all matches, as a small place
+	// with photos ranks above any place without them
+	r := make([]FoundPlace, len(m))
+	for i := range m {
+		r[i] = d.foundPlace(m[i].Place)
+	}
+	// keep the order of matches with the same number of photos
+	sort.Stable(placesByPhotos(r))
+	if len(r) > n {
+		r = r[:n]
+	}
+	return r
+}
+
+// foundPlace counts the photos around p.
+func (d *tileData) foundPlace(p gazetteer.Place) FoundPlace {
+	// placeRadius on the mercator map at p
+	r := placeRadius / math.Max(math.Cos(p.Lat*math.Pi/180), 0.01)
+
+	fp := FoundPlace{Place: p}
+	b := Bounds{p.Lat, p.Long, p.Lat, p.Long}
+	if d.qt != nil {
+		d.qt.CircleFunc(p.Long, lat2merc(p.Lat), r, func(i int) bool {
+			ii := &d.images[i]
+			b.South = math.Min(b.South, ii.Lat)
+			b.West = math.Min(b.West, ii.Long)
+			b.North = math.Max(b.North, ii.Lat)
+			b.East = math.Max(b.East, ii.Long)
+			fp.Photos++
+			return true
+		})
+	}
+	if fp.Photos == 0 {
+		b = Bounds{p.Lat - placeRadius, p.Long - r, p.Lat + placeRadius, p.Long + r}
+	}
+	fp.Bounds = b
+	return fp
+}
+
+type placesByPhotos []FoundPlace
+
+func (s placesByPhotos) Len() int           { return len(s) }
+func (s placesByPhotos) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s placesByPhotos) Less(i, j int) bool { return s[i].Photos > s[j].Photos }
+
 func (tm *TileMap) findStartLocation() {
 	w0 := tm.findStartLocationOfs(0, false)
 	w180 := tm.findStartLocationOfs(180, false) // when photos are near date line
@@ -139,7 +371,7 @@ func (tm *TileMap) findStartLocation() {
 
 func (tm *TileMap) findStartLocationOfs(lofs float64, set bool) (width float64) {
 	var x0, y0, x1, y1 float64
-	for i, ii := range tm.ic.Images() {
+	for i, ii := range tm.data("").images {
 		x, y := ii.Long+lofs, ii.Lat
 		if i == 0 {
 			x0, x1 = x, x
@@ -162,15 +394,19 @@ func (tm *TileMap) findStartLocationOfs(lofs float64, set bool) (width float64)
 	return dx
 }
 
-func (tm *TileMap) spotsTile(x, y, zoom int) []byte {
+func (tm *TileMap) spotsTile(d *tileData, x, y, zoom int) []byte {
+	if d.qt == nil {
+		return tm.emptyTile
+	}
+
 	t := makeTileInfo(x, y, zoom, spotSize)
 
 	im := image.NewRGBA(image.Rect(0, 0, TileSize, TileSize))
 
 	// draw spots
 	ndrawn := 0
-	tm.qt.NearFunc(t.lo0, lat2merc(t.la0), t.lo1, lat2merc(t.la1), func(i int) bool {
-		ii := tm.images[i]
+	d.qt.NearFunc(t.lo0, lat2merc(t.la0), t.lo1, lat2merc(t.la1), func(i int) bool {
+		ii := d.images[i]
 		px, py := t.pixel(ii.Lat, ii.Long)
 
 		dx := tm.spot.Bounds().Dx()
@@ -192,9 +428,109 @@ func (tm *TileMap) spotsTile(x, y, zoom int) []byte {
 	return pngBytes(im)
 }
 
-func (tm *TileMap) photoTile(x, y, zoom int) []byte {
+const (
+	headingRadius = 32 // length of heading wedges in pixels
+	headingSpread = 40 // angle of heading wedges in degrees
+)
+
+var (
+	headingColor  = color.NRGBA{0, 96, 255, 160}    // from true north
+	magneticColor = color.NRGBA{128, 176, 255, 160} // from magnetic north
+)
+
+func (tm *TileMap) headingTile(d *tileData, x, y, zoom int) []byte {
+	if d.qt == nil {
+		return tm.emptyTile
+	}
+
+	t := makeTileInfo(x, y, zoom, headingRadius)
+
+	im := image.NewRGBA(image.Rect(0, 0, TileSize, TileSize))
+	src := image.NewUniform(headingColor)
+	magsrc := image.NewUniform(magneticColor)
+
+	ndrawn := 0
+	d.qt.NearFunc(t.lo0, lat2merc(t.la0), t.lo1, lat2merc(t.la1), func(i int) bool {
+		ii := d.images[i]
+		if ii.Direction == nil {
+			return true
+		}
+		px, py := t.pixel(ii.Lat, ii.Long)
+		mask := headingMask(*ii.Direction)
+		xo := int(px) - headingRadius
+		yo := int(py) - headingRadius
+		r := mask.Bounds().Add(image.Pt(xo, yo))
+		if r.Overlaps(im.Bounds()) {
+			ndrawn++
+			c := src
+			if ii.Magnetic {
+				c = magsrc
+			}
+			draw.DrawMask(im, r, c, image.Point{}, mask, image.Point{}, draw.Over)
+		}
+		return true
+	})
+
+	if ndrawn == 0 {
+		return tm.emptyTile
+	}
+
+	return pngBytes(im)
+}
+
+// headingMasks are the wedges of headingMask by degree.
+var headingMasks struct {
+	sync.Mutex
+	m [360]*image.Alpha
+}
+
+// headingMask returns the wedge of headingWedge for dir rounded
+// to degrees. Masks are made on first use and never modified.
+func headingMask(dir float64) *image.Alpha {
+	deg := int(math.Floor(dir+0.5)) % 360
+	if deg < 0 {
+		deg += 360
+	}
+	headingMasks.Lock()
+	defer headingMasks.Unlock()
+	m := headingMasks.m[deg]
+	if m == nil {
+		m = headingWedge(float64(deg))
+		headingMasks.m[deg] = m
+	}
+	return m
+}
+
+// headingWedge returns the mask of a wedge centered in the mask
+// pointing to dir degrees clockwise from north, fading outwards.
+// Angles are kept on Mercator tiles, therefore dir needs no adjustment.
+func headingWedge(dir float64) *image.Alpha {
+	const siz = 2 * headingRadius
+	m := image.NewAlpha(image.Rect(0, 0, siz, siz))
+	rad := dir * math.Pi / 180
+	hx, hy := math.Sin(rad), -math.Cos(rad)
+	cosHalf := math.Cos(headingSpread / 2 * math.Pi / 180)
+	for yi := 0; yi < siz; yi++ {
+		for xi := 0; xi < siz; xi++ {
+			dx := float64(xi) + 0.5 - headingRadius
+			dy := float64(yi) + 0.5 - headingRadius
+			r := math.Sqrt(dx*dx + dy*dy)
+			if r == 0 || r > headingRadius || (dx*hx+dy*hy)/r < cosHalf {
+				continue
+			}
+			m.SetAlpha(xi, yi, color.Alpha{uint8(255 * (1 - r/headingRadius))})
+		}
+	}
+	return m
+}
+
+func (tm *TileMap) photoTile(ctx context.Context, d *tileData, x, y, zoom int) []byte {
 	const thumbSize = 20
 
+	if d.tree == nil {
+		return tm.emptyTile
+	}
+
 	t := makeTileInfo(x, y, zoom, thumbSize)
 
 	im := image.NewRGBA(image.Rect(0, 0, TileSize, TileSize))
@@ -202,9 +538,11 @@ func (tm *TileMap) photoTile(x, y, zoom int) []byte {
 	// draw photo piles
 	ndrawn := 0
 	drawPhoto := func(px, py float64, ii imagecache.ImageInfo) {
-		thumb, err := tm.ic.PhotoIcon(ii.Id)
+		thumb, err := tm.ic.PhotoIcon(ctx, ii.Id)
 		if err != nil {
-			log.Printf("can't get photo icon for %s: %s", ii.Id, err)
+			if ctx.Err() == nil {
+				log.Printf("can't get photo icon for %s: %s", ii.Id, err)
+			}
 			return
 		}
 
@@ -218,14 +556,14 @@ func (tm *TileMap) photoTile(x, y, zoom int) []byte {
 			draw.Draw(im, r, thumb, thumb.Bounds().Min, draw.Over)
 		}
 	}
-	tm.tree.Query(t.lo0, lat2merc(t.la0), t.lo1, lat2merc(t.la1), zoomdist(zoom),
+	d.tree.Query(t.lo0, lat2merc(t.la0), t.lo1, lat2merc(t.la1), zoomdist(zoom),
 		func(pt clusterer.Point, images []int) {
 			px, py := t.pixel(merc2lat(pt.Y), pt.X)
 
 			// have newest images first
 			vii := make([]imagecache.ImageInfo, len(images))
 			for i, x := range images {
-				vii[i] = tm.images[x]
+				vii[i] = d.images[x]
 			}
 			sort.Sort(sort.Reverse(iiByDate(vii)))
 
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/tajtiattila/photomap/gazetteer"
+	"github.com/tajtiattila/photomap/imagecache"
+)
+
+func TestHeadingTile(t *testing.T) {
+	tm := &TileMap{
+		emptyTile: pngBytes(image.NewNRGBA(image.Rect(0, 0, TileSize, TileSize))),
+	}
+	const zoom = 12
+	lat, long := 47.5, 19.05
+	x, y := makeTiler(zoom).Tile(lat, long)
+	tx, ty := int(x), int(y)
+	ti := makeTileInfo(tx, ty, zoom, headingRadius)
+	px, py := ti.pixel(lat, long)
+
+	dir := 90.2 // east
+	tests := []struct {
+		ii     imagecache.ImageInfo
+		magnet bool
+	}{
+		{imagecache.ImageInfo{Lat: lat, Long: long, Direction: &dir}, false},
+		{imagecache.ImageInfo{Lat: lat, Long: long, Direction: &dir, Magnetic: true}, true},
+	}
+	for _, tt := range tests {
+		d := newTileData([]imagecache.ImageInfo{tt.ii})
+		im, err := png.Decode(bytes.NewReader(tm.headingTile(d, tx, ty, zoom)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		at := func(dx, dy int) color.NRGBA {
+			return color.NRGBAModel.Convert(im.At(int(px)+dx, int(py)+dy)).(color.NRGBA)
+		}
+		c := at(headingRadius/2, 0)
+		if c.A == 0 {
+			t.Errorf("magnetic=%v: no wedge east of the photo", tt.magnet)
+		}
+		if want := c.R != 0; want != tt.magnet {
+			t.Errorf("magnetic=%v: wedge color is %v", tt.magnet, c)
+		}
+		for _, p := range []image.Point{{-headingRadius / 2, 0}, {0, -headingRadius / 2}, {0, headingRadius / 2}} {
+			if c := at(p.X, p.Y); c.A != 0 {
+				t.Errorf("magnetic=%v: wedge drawn at %v: %v", tt.magnet, p, c)
+			}
+		}
+	}
+
+	d := newTileData([]imagecache.ImageInfo{{Lat: lat, Long: long}})
+	if !bytes.Equal(tm.headingTile(d, tx, ty, zoom), tm.emptyTile) {
+		t.Error("tile of photo without direction is not empty")
+	}
+
+	if headingMask(359.7) != headingMask(0) || headingMask(90.2) != headingMask(89.9) {
+		t.Error("masks of the same rounded direction differ")
+	}
+	if headingMask(90) == headingMask(91) {
+		t.Error("masks of different directions are shared")
+	}
+}
+
+func TestPlace(t *testing.T) {
+	tm := &TileMap{gaz: gazetteer.New([]gazetteer.Place{{Name: "Lisbon", Lat: 38.71667, Long: -9.13333}})}
+	tests := []struct {
+		lat, long, r float64
+		want         string
+	}{
+		{38.7, -9.2, zoomdist(14) / 2, "Lisbon"},
+		{38.7, -9.2, zoomdist(3) / 2, ""}, // pile too large
+		{40, -8.7, zoomdist(14) / 2, ""},  // too far
+	}
+	for _, tt := range tests {
+		var got string
+		if p := tm.place(tt.lat, tt.long, tt.r); p != nil {
+			got = p.Name
+		}
+		if got != tt.want {
+			t.Errorf("place(%v, %v, %v) = %q, want %q", tt.lat, tt.long, tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestSearchPlaces(t *testing.T) {
+	// many populous places without photos match before the one with photos
+	var places []gazetteer.Place
+	for i := 0; i < 150; i++ {
+		places = append(places, gazetteer.Place{
+			Name:       fmt.Sprintf("San Place %d", i),
+			Lat:        float64(i%60) - 30,
+			Long:       float64(i) - 75,
+			Population: 1000000 - i,
+		})
+	}
+	small := gazetteer.Place{Name: "San Tiny", Lat: 40, Long: 100, Population: 100}
+	places = append(places, small)
+
+	tm := &TileMap{gaz: gazetteer.New(places)}
+	tm.d.Store(newTileData([]imagecache.ImageInfo{
+		{Id: "1", Lat: small.Lat + 0.01, Long: small.Long},
+		{Id: "2", Lat: small.Lat, Long: small.Long - 0.01},
+	}))
+
+	r := tm.SearchPlaces("san", 10, "")
+	if len(r) != 10 {
+		t.Fatalf("got %d places, want 10", len(r))
+	}
+	if r[0].Name != small.Name || r[0].Photos != 2 {
+		t.Errorf("first place is %s with %d photos, want %s with 2", r[0].Name, r[0].Photos, small.Name)
+	}
+	for _, p := range r[1:] {
+		if p.Photos != 0 {
+			t.Errorf("%s has %d photos, want none", p.Name, p.Photos)
+		}
+	}
+}
+
+func TestPhotoTileCancel(t *testing.T) {
+	tm := &TileMap{
+		emptyTile: pngBytes(image.NewNRGBA(image.Rect(0, 0, TileSize, TileSize))),
+	}
+	tm.d.Store(newTileData(nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if b := tm.PhotoTile(ctx, 0, 0, 1, ""); b != nil {
+		t.Error("got tile after the context is done")
+	}
+	if b := tm.PhotoTile(context.Background(), 0, 0, 1, ""); !bytes.Equal(b, tm.emptyTile) {
+		t.Error("got no empty tile")
+	}
+}